@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/subtle"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	CD_MESSAGING_UNAUTHORIZED = 62 //sendSms e lote sem Messaging.ApiKey válida
+)
+
+// requireKey confere o ?key= da requisição com a chave configurada para o endpoint. Sem chave configurada o endpoint
+// fica fechado. Se não autorizado, responde 401 com errorCode e retorna false.
+func requireKey(ctx *fasthttp.RequestCtx, configuredKey string, errorCode int) bool {
+	if configuredKey == "" {
+		requestLog(ctx).W("requireKey: chave não configurada para " + string(ctx.Path()) + ": pedido recusado")
+		util.SendResponse(ctx, fasthttp.StatusUnauthorized, newErrorResponseFromValues(errorCode, "Não autorizado", "Chave de acesso não configurada no serviço"))
+		return false
+	}
+	if subtle.ConstantTimeCompare(ctx.QueryArgs().Peek("key"), []byte(configuredKey)) != 1 {
+		util.SendResponse(ctx, fasthttp.StatusUnauthorized, newErrorResponseFromValues(errorCode, "Não autorizado", ""))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/metrics"
+	"gaudium.com.br/gaudiumsoftware/sms/quota"
+	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"strings"
+)
+
+// bandeiraChains são as cadeias de failover das bandeiras com ProviderChain próprio. Montadas no init.
+var bandeiraChains = map[string]*smsproviders.ProviderChain{}
+
+// availableProviderChain monta a cadeia só com os providers instanciados: sem credenciais um provider fica
+// indisponível e é ignorado
+func availableProviderChain(providerNames string) *smsproviders.ProviderChain {
+	var available []smsproviders.SmsProviderIntf
+	for _, name := range strings.Split(providerNames, ",") {
+		if provider := smsproviders.GetProvider(strings.TrimSpace(name)); provider != nil {
+			available = append(available, provider)
+		}
+	}
+	return smsproviders.NewProviderChain(available...)
+}
+
+func initBandeiraChains() {
+	for bandeira, cfg := range util.AppCfg.Bandeiras {
+		if cfg.ProviderChain == "" {
+			continue
+		}
+		chain, err := NewProviderChain(cfg.ProviderChain)
+		if err != nil {
+			util.LogE("ProviderChain da bandeira " + bandeira + ": " + err.Error() + ". Usando os providers disponíveis")
+			chain = availableProviderChain(cfg.ProviderChain)
+		}
+		util.LogI("ProviderChain da bandeira " + bandeira + ": " + chain.String())
+		bandeiraChains[bandeira] = chain
+	}
+}
+
+// chainForBandeira retorna os providers permitidos para a bandeira, em ordem de prioridade. Bandeiras sem
+// ProviderChain próprio usam a cadeia geral (que pode ser trocada em /provider).
+func chainForBandeira(bandeira string) *smsproviders.ProviderChain {
+	if chain, ok := bandeiraChains[bandeira]; ok {
+		return chain
+	}
+	return currentProviderChain()
+}
+
+// checkQuota recusa o envio quando a bandeira já usou a cota mensal (BandeiraOptions.MonthlyQuota). Responde com
+// QuotaExceededErrorCode e a cota em Data e retorna false.
+func checkQuota(ctx *fasthttp.RequestCtx, bandeira string) bool {
+	exceeded, used, hard := quota.Exceeded(bandeira)
+	if !exceeded {
+		return true
+	}
+	metrics.SendRejections.Inc("quota", bandeira)
+	requestLog(ctx).WithBandeira(bandeira).W(fmt.Sprintf("checkQuota: bandeira %s usou a cota mensal (%d de %d)", bandeira, used, hard))
+	util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(quota.QuotaExceededErrorCode, "Cota mensal de SMS esgotada", strconv.Itoa(hard)))
+	return false
+}
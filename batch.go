@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/fraud"
+	"gaudium.com.br/gaudiumsoftware/sms/metrics"
+	"gaudium.com.br/gaudiumsoftware/sms/phone"
+	"gaudium.com.br/gaudiumsoftware/sms/quota"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	batchPopTimeoutSeconds = 5
+
+	CD_BATCH_INVALID   = 60
+	CD_BATCH_NOT_FOUND = 61
+)
+
+func requestBatchHandler(ctx *fasthttp.RequestCtx) {
+	if !requireKey(ctx, util.AppCfg.MessagingOptions.ApiKey, CD_MESSAGING_UNAUTHORIZED) {
+		return
+	}
+	batchReq, err := util.NewBatchRequest(ctx.Request.Body())
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INVALID_JSON_READ, err.Error()))
+		return
+	}
+	maxRecipients := util.AppCfg.BatchOptions.MaxRecipients
+	if maxRecipients <= 0 {
+		maxRecipients = util.DefaultBatchMaxRecipients
+	}
+	if (batchReq.Template == "") || (len(batchReq.Recipients) == 0) {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_BATCH_INVALID, "template e recipients são obrigatórios", ""))
+		return
+	}
+	if len(batchReq.Recipients) > maxRecipients {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_BATCH_INVALID, fmt.Sprintf("Máximo de %d destinatários por job", maxRecipients), ""))
+		return
+	}
+	recipients := make([]string, 0, len(batchReq.Recipients))
+	var invalidPhones []string
+	for _, recipient := range batchReq.Recipients {
+		if recipient.PhoneNumber == "" {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_BATCH_INVALID, "Destinatário sem phoneNumber", ""))
+			return
+		}
+		e164, err := phone.NormalizeFor(recipient.PhoneNumber, batchReq.Bandeira)
+		if err != nil {
+			invalidPhones = append(invalidPhones, recipient.PhoneNumber)
+			continue
+		}
+		recipient.PhoneNumber = e164
+		bts, _ := json.Marshal(recipient)
+		recipients = append(recipients, string(bts))
+	}
+	if len(invalidPhones) > 0 {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(phone.InvalidPhoneErrorCode, "Destinatários com telefone inválido", strings.Join(invalidPhones, ",")))
+		return
+	}
+	jobId, err := db.CreateBatchJob(batchReq.Bandeira, batchReq.Template, clientIp(ctx), recipients)
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisWriteError, "Não foi possível criar o job", err.Error()))
+		return
+	}
+	util.LogI(fmt.Sprintf("requestBatchHandler: job %s criado com %d destinatários", jobId, len(recipients)))
+	util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("Job criado", jobId))
+}
+
+func batchStatusHandler(ctx *fasthttp.RequestCtx) {
+	sendBatchStatus(ctx, false)
+}
+
+func batchResultsHandler(ctx *fasthttp.RequestCtx) {
+	sendBatchStatus(ctx, true)
+}
+
+func sendBatchStatus(ctx *fasthttp.RequestCtx, withResults bool) {
+	if !requireKey(ctx, util.AppCfg.MessagingOptions.ApiKey, CD_MESSAGING_UNAUTHORIZED) {
+		return
+	}
+	jobId := fmt.Sprintf("%v", ctx.UserValue("id"))
+	job, err := db.ReadBatchJob(jobId)
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisNotFoundError, "A leitura do job falhou", err.Error()))
+		return
+	}
+	if job == nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_BATCH_NOT_FOUND, "Job inválido ou expirado", ""))
+		return
+	}
+	status := util.TBatchJobStatus{Id: job.Id, Bandeira: job.Bandeira, Status: job.Status, Total: job.Total,
+		Sent: job.Sent, Failed: job.Failed, Created: job.Created, Finished: job.Finished}
+	if withResults {
+		results, err := db.ReadBatchResults(jobId)
+		if err != nil {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisNotFoundError, "A leitura dos resultados falhou", err.Error()))
+			return
+		}
+		status.Results = make([]util.TBatchRecipientResult, 0, len(results))
+		for _, sResult := range results {
+			var result util.TBatchRecipientResult
+			if json.Unmarshal([]byte(sResult), &result) == nil {
+				status.Results = append(status.Results, result)
+			}
+		}
+		sort.Slice(status.Results, func(i, j int) bool { return status.Results[i].Index < status.Results[j].Index })
+	}
+	bts, err := json.Marshal(status)
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INAVLID_JSON_WRITE, err.Error()))
+		return
+	}
+	util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("OK", string(bts)))
+}
+
+// runBatchDispatcher consome a fila de jobs, um job por vez, até o encerramento do serviço
+func runBatchDispatcher() {
+	defer backgroundWorkers.Done()
+	for !isStopping() {
+		jobId, err := db.PopBatchJob(batchPopTimeoutSeconds)
+		if err != nil {
+			util.LogE("runBatchDispatcher: " + err.Error())
+			sleepUnlessStopping(batchPopTimeoutSeconds * time.Second)
+			continue
+		}
+		if jobId != "" {
+			processBatchJob(jobId)
+		}
+	}
+}
+
+func processBatchJob(jobId string) {
+	defer func() {
+		if r := recover(); r != nil {
+			util.LogE(fmt.Sprintf("processBatchJob %s: %v", jobId, r))
+		}
+	}()
+	job, err := db.ReadBatchJob(jobId)
+	if (err != nil) || (job == nil) {
+		util.LogE("processBatchJob: job " + jobId + " não encontrado")
+		return
+	}
+	recipients, err := db.ReadBatchRecipients(jobId)
+	if err != nil {
+		util.LogE("processBatchJob.recipients: " + err.Error())
+		return
+	}
+	//Ao retomar um job interrompido, destinatários que já têm resultado não recebem de novo
+	done, _ := db.ReadBatchResults(jobId)
+	util.LogI(fmt.Sprintf("processBatchJob: job %s, %d destinatários, %d já processados", jobId, len(recipients), len(done)))
+
+	concurrency := util.AppCfg.BatchOptions.Concurrency
+	if concurrency <= 0 {
+		concurrency = util.DefaultBatchConcurrency
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	interrupted := false
+	for index, sRecipient := range recipients {
+		//No encerramento do serviço o job fica em andamento e é retomado na próxima partida
+		if isStopping() {
+			interrupted = true
+			break
+		}
+		var recipient util.TBatchRecipient
+		if json.Unmarshal([]byte(sRecipient), &recipient) != nil {
+			continue
+		}
+		if _, isDone := done[strconv.Itoa(index)]; isDone {
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(index int, recipient util.TBatchRecipient) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			sendBatchMessage(job, index, recipient)
+		}(index, recipient)
+	}
+	wg.Wait()
+	if interrupted {
+		util.LogI("processBatchJob: job " + jobId + " interrompido pelo encerramento do serviço")
+		return
+	}
+	if err = db.FinishBatchJob(jobId); err != nil {
+		util.LogE("processBatchJob.finish: " + err.Error())
+	}
+	util.LogI("processBatchJob: job " + jobId + " concluído")
+}
+
+// rejectBatchRecipient aplica ao destinatário as mesmas verificações dos envios avulsos: cota, limites de envio e
+// antifraude. Retorna o resultado da recusa ou nil e a reserva nos limites de envio, se o envio pode seguir.
+func rejectBatchRecipient(job *db.BatchJob, recipient util.TBatchRecipient) (*util.TBatchRecipientResult, *db.RateLimitReservation) {
+	jobLog := util.Logger{}.WithBandeira(job.Bandeira)
+	if exceeded, _, _ := quota.Exceeded(job.Bandeira); exceeded {
+		metrics.SendRejections.Inc("quota", job.Bandeira)
+		return &util.TBatchRecipientResult{PhoneNumber: recipient.PhoneNumber, Code: quota.QuotaExceededErrorCode, Msg: "Cota mensal de SMS esgotada"}, nil
+	}
+	rule, waitSeconds, rateLimits := sendRateLimited(jobLog, job.ClientIp, job.Bandeira, recipient.PhoneNumber)
+	if rule != nil {
+		return &util.TBatchRecipientResult{PhoneNumber: recipient.PhoneNumber, Code: CD_RATE_LIMITED,
+			Msg: fmt.Sprintf("Limite de envios atingido (%s). Libera em %d segundos", rule.Name, waitSeconds)}, nil
+	}
+	switch assessFraud(jobLog, job.ClientIp, job.Bandeira, recipient.PhoneNumber) {
+	case fraud.Block:
+		rateLimits.Release()
+		return &util.TBatchRecipientResult{PhoneNumber: recipient.PhoneNumber, Code: CD_FRAUD_BLOCKED, Msg: "Envio não permitido para este número"}, nil
+	case fraud.Challenge:
+		rateLimits.Release()
+		return &util.TBatchRecipientResult{PhoneNumber: recipient.PhoneNumber, Code: CD_FRAUD_CHALLENGE, Msg: "Envio em análise"}, nil
+	}
+	return nil, rateLimits
+}
+
+func sendBatchMessage(job *db.BatchJob, index int, recipient util.TBatchRecipient) {
+	rejected, rateLimits := rejectBatchRecipient(job, recipient)
+	if rejected != nil {
+		rejected.Index = index
+		bts, _ := json.Marshal(rejected)
+		if err := db.WriteBatchResult(job.Id, index, string(bts), false); err != nil {
+			util.LogE("sendBatchMessage: " + err.Error())
+		}
+		return
+	}
+	content := recipient.Render(job.Template)
+	senderId := util.AppCfg.Bandeira(job.Bandeira).SenderId
+	result, provider := chainForBandeira(job.Bandeira).Do(func(provider smsproviders.SmsProviderIntf) smsproviders.SmsResult {
+		return provider.SendMessageRequest(recipient.PhoneNumber, content, "", senderId)
+	})
+	countSend(sendKindBatch, job.Bandeira, provider, result)
+	rcptResult := util.TBatchRecipientResult{Index: index, PhoneNumber: recipient.PhoneNumber, Success: result.IsSuccess, Code: result.Code, Msg: result.Msg}
+	if provider != nil {
+		rcptResult.Provider = provider.ProviderName()
+	}
+	if result.IsSuccess {
+		rcptResult.SmsId = fmt.Sprintf("%v", result.Data)
+		quota.Account(job.Bandeira, provider.ProviderName(), recipient.PhoneNumber, result.Segments)
+		registerMessage(provider, result, recipient.PhoneNumber, job.Bandeira)
+	} else {
+		accountFailure(provider, job.Bandeira, recipient.PhoneNumber)
+		rateLimits.Release()
+	}
+	bts, _ := json.Marshal(rcptResult)
+	if err := db.WriteBatchResult(job.Id, index, string(bts), result.IsSuccess); err != nil {
+		util.LogE("sendBatchMessage: " + err.Error())
+	}
+}
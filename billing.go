@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/billing"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"os"
+)
+
+const (
+	CD_BILLING_INVALID = 110
+	CD_BILLING_FAILED  = 111
+
+	reportCommand = "report"
+)
+
+// accountFailure conta o envio recusado no detalhe de cobrança do provider que respondeu por último
+func accountFailure(provider smsproviders.SmsProviderIntf, bandeira string, phoneNumber string) {
+	if provider != nil {
+		db.AccountFailure(bandeira, provider.ProviderName(), phoneNumber)
+	}
+}
+
+// billingHandler retorna o uso e o custo estimado por mês, bandeira, provider e país.
+// Parâmetros: from e to (AAAA-MM), bandeira (opcional) e format (json ou csv).
+func billingHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	from, to, err := billing.ParseRange(string(args.Peek("from")), string(args.Peek("to")))
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_BILLING_INVALID, err.Error(), ""))
+		return
+	}
+	report, err := billing.Report(from, to, string(args.Peek("bandeira")))
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_BILLING_FAILED, "A leitura do uso falhou", err.Error()))
+		return
+	}
+	if string(args.Peek("format")) == "csv" {
+		var csv bytes.Buffer
+		if err = billing.WriteCSV(&csv, report); err != nil {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_BILLING_FAILED, "Não foi possível gerar o CSV", err.Error()))
+			return
+		}
+		ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"sms-%s-%s.csv\"", report.From, report.To))
+		ctx.SetContentType("text/csv; charset=utf-8")
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetBody(csv.Bytes())
+		return
+	}
+	bts, err := json.Marshal(report)
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INAVLID_JSON_WRITE, err.Error()))
+		return
+	}
+	util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("OK", string(bts)))
+}
+
+// runReportCommand executa "sms report -from AAAA-MM [-to AAAA-MM] [-bandeira N] [-format json|csv]" e grava o
+// relatório na saída padrão. Retorna o código de saída do processo.
+func runReportCommand(arguments []string) int {
+	flags := flag.NewFlagSet(reportCommand, flag.ContinueOnError)
+	from := flags.String("from", "", "mês inicial (AAAA-MM)")
+	to := flags.String("to", "", "mês final (AAAA-MM). Vazio: só o mês inicial")
+	bandeira := flags.String("bandeira", "", "bandeira. Vazio: todas")
+	format := flags.String("format", "json", "json ou csv")
+	if err := flags.Parse(arguments); err != nil {
+		return 2
+	}
+	fromMonth, toMonth, err := billing.ParseRange(*from, *to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	report, err := billing.Report(fromMonth, toMonth, *bandeira)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "A leitura do uso falhou: "+err.Error())
+		return 1
+	}
+	if *format == "csv" {
+		err = billing.WriteCSV(os.Stdout, report)
+	} else {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
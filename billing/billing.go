@@ -0,0 +1,136 @@
+package billing
+
+import (
+	"encoding/csv"
+	"fmt"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const MonthFormat = "2006-01" //Formato dos meses do relatório
+
+// ParseRange valida o intervalo de meses do relatório. to vazio: só o mês from.
+func ParseRange(from string, to string) (time.Time, time.Time, error) {
+	fromMonth, err := time.Parse(MonthFormat, from)
+	if err != nil {
+		return fromMonth, fromMonth, fmt.Errorf("Mês inicial inválido (AAAA-MM): %s", from)
+	}
+	if to == "" {
+		return fromMonth, fromMonth, nil
+	}
+	toMonth, err := time.Parse(MonthFormat, to)
+	if err != nil {
+		return fromMonth, toMonth, fmt.Errorf("Mês final inválido (AAAA-MM): %s", to)
+	}
+	if toMonth.Before(fromMonth) {
+		return fromMonth, toMonth, fmt.Errorf("O mês final é anterior ao inicial")
+	}
+	if fromMonth.AddDate(0, util.DefaultBillingMaxMonths, 0).Before(toMonth) {
+		return fromMonth, toMonth, fmt.Errorf("Máximo de %d meses por relatório", util.DefaultBillingMaxMonths)
+	}
+	return fromMonth, toMonth, nil
+}
+
+func unitPrice(provider string, countryCode string) float64 {
+	return util.AppCfg.Prices[provider].PerSegment(countryCode)
+}
+
+func roundCost(cost float64) float64 {
+	return math.Round(cost*10000) / 10000
+}
+
+// Report soma o uso por mês, bandeira, provider e país de destino. bandeira vazia: todas as que enviaram no mês.
+// O custo é estimado pelas partes cobradas e a tabela [Prices.<provider>]; envios sem provider identificado não têm custo.
+func Report(from time.Time, to time.Time, bandeira string) (util.TUsageReport, error) {
+	report := util.TUsageReport{From: from.Format(MonthFormat), To: to.Format(MonthFormat), Bandeira: bandeira,
+		Currency: util.AppCfg.BillingOptions.Currency, Rows: []util.TUsageRow{}}
+	for month := from; !month.After(to); month = month.AddDate(0, 1, 0) {
+		billingMonth := db.BillingMonth(month)
+		bandeiras := []string{bandeira}
+		if bandeira == "" {
+			var err error
+			if bandeiras, err = db.BillingBandeiras(billingMonth); err != nil {
+				return report, err
+			}
+			sort.Strings(bandeiras)
+		}
+		for _, bd := range bandeiras {
+			counts, err := db.ReadUsageCounts(billingMonth, bd)
+			if err != nil {
+				return report, err
+			}
+			report.Rows = append(report.Rows, usageRows(month.Format(MonthFormat), bd, counts)...)
+		}
+	}
+	for _, row := range report.Rows {
+		report.TotalSent += row.Sent
+		report.TotalSegments += row.Segments
+		report.TotalCost += row.Cost
+	}
+	report.TotalCost = roundCost(report.TotalCost)
+	return report, nil
+}
+
+func usageRows(month string, bandeira string, counts db.UsageCounts) []util.TUsageRow {
+	var detailed int64
+	keys := make([]db.UsageKey, 0, len(counts.Detail))
+	for key, count := range counts.Detail {
+		detailed += count.Sent
+		keys = append(keys, key)
+	}
+	//Envios contados só no total da bandeira, antes do detalhe por provider
+	undetailed := counts.Total - detailed
+	undetailedKey := db.UsageKey{}
+	if _, ok := counts.Detail[undetailedKey]; !ok && (undetailed > 0) {
+		keys = append(keys, undetailedKey)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Provider != keys[j].Provider {
+			return keys[i].Provider < keys[j].Provider
+		}
+		return keys[i].Country < keys[j].Country
+	})
+
+	rows := make([]util.TUsageRow, 0, len(keys))
+	for _, key := range keys {
+		count := counts.Detail[key]
+		row := util.TUsageRow{Month: month, Bandeira: bandeira, Provider: key.Provider, Country: key.Country, Sent: count.Sent,
+			Segments: count.Segments, Verified: count.Verified, Failed: count.Failed}
+		if (key == undetailedKey) && (undetailed > 0) {
+			row.Sent += undetailed
+		}
+		//Envios contados antes da contagem de partes: uma parte cada
+		if row.Segments < row.Sent {
+			row.Segments = row.Sent
+		}
+		if key.Provider != "" {
+			row.UnitPrice = unitPrice(key.Provider, key.Country)
+			row.Cost = roundCost(float64(row.Segments) * row.UnitPrice)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// WriteCSV grava o relatório com uma linha de cabeçalho e uma por mês, bandeira, provider e país
+func WriteCSV(w io.Writer, report util.TUsageReport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"month", "bandeira", "provider", "country", "sent", "segments", "verified", "failed", "unit_price", "cost", "currency"}); err != nil {
+		return err
+	}
+	for _, row := range report.Rows {
+		record := []string{row.Month, row.Bandeira, row.Provider, row.Country, strconv.FormatInt(row.Sent, 10),
+			strconv.FormatInt(row.Segments, 10), strconv.FormatInt(row.Verified, 10), strconv.FormatInt(row.Failed, 10),
+			strconv.FormatFloat(row.UnitPrice, 'f', -1, 64), strconv.FormatFloat(row.Cost, 'f', 4, 64), report.Currency}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,125 @@
+package billing
+
+import (
+	"bytes"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMemoryRepository troca o armazenamento e a configuração pelos do teste e os restaura no fim
+func useMemoryRepository(t *testing.T) {
+	previousRepo := db.CurrentRepository()
+	previousCfg := util.AppCfg
+	t.Cleanup(func() {
+		db.SetRepository(previousRepo)
+		util.AppCfg = previousCfg
+	})
+	db.SetRepository(db.NewMemoryRepository())
+	util.AppCfg = util.Config{}
+}
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		from, to string
+		valid    bool
+	}{
+		{"2024-05", "", true},
+		{"2024-05", "2024-07", true},
+		{"2024-05", "2024-05", true},
+		{"2024-5", "", false},
+		{"2024-05", "julho", false},
+		{"2024-07", "2024-05", false}, //Final antes do inicial
+		{"2022-01", "2024-02", false}, //Além de DefaultBillingMaxMonths
+	}
+	for _, c := range cases {
+		from, to, err := ParseRange(c.from, c.to)
+		if (err == nil) != c.valid {
+			t.Errorf("ParseRange(%q, %q) = %v, esperado válido: %v", c.from, c.to, err, c.valid)
+			continue
+		}
+		if c.valid && (c.to == "") && !from.Equal(to) {
+			t.Errorf("ParseRange(%q, \"\") = %v, %v; esperado só o mês inicial", c.from, from, to)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	useMemoryRepository(t)
+	for _, bandeira := range []string{"2", "1", "2"} {
+		db.AccountSMS(bandeira, "", "", 1)
+	}
+	month := time.Now()
+
+	report, err := Report(month, month, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(report.Rows) != 2) || (report.Rows[0].Bandeira != "1") || (report.Rows[1].Bandeira != "2") {
+		t.Fatalf("Report de todas as bandeiras = %+v, esperado uma linha por bandeira em ordem", report.Rows)
+	}
+	if (report.TotalSent != 3) || (report.Rows[1].Sent != 2) || (report.Rows[0].Month != month.Format(MonthFormat)) {
+		t.Errorf("Report = %+v, esperado 3 envios, 2 da bandeira 2", report)
+	}
+
+	if report, _ = Report(month, month, "2"); (len(report.Rows) != 1) || (report.TotalSent != 2) {
+		t.Errorf("Report da bandeira 2 = %+v, esperado 2 envios", report)
+	}
+	previous := month.AddDate(0, -1, 0)
+	if report, _ = Report(previous, previous, ""); (len(report.Rows) != 0) || (report.TotalSent != 0) {
+		t.Errorf("Report do mês anterior = %+v, esperado vazio", report)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	report := util.TUsageReport{Currency: "BRL", Rows: []util.TUsageRow{
+		{Month: "2024-05", Bandeira: "1", Provider: "zenvia", Country: "55", Sent: 10, Segments: 12, Verified: 7, Failed: 1, UnitPrice: 0.05, Cost: 0.6},
+	}}
+	var out bytes.Buffer
+	if err := WriteCSV(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := []string{
+		"month,bandeira,provider,country,sent,segments,verified,failed,unit_price,cost,currency",
+		"2024-05,1,zenvia,55,10,12,7,1,0.05,0.6000,BRL",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("WriteCSV =\n%s\nesperado\n%s", out.String(), strings.Join(expected, "\n"))
+	}
+}
+
+func TestReportByProviderAndCountry(t *testing.T) {
+	useMemoryRepository(t)
+	util.AppCfg.Prices = map[string]util.PriceOptions{"zenvia": {PerSms: 0.05, Countries: map[string]float64{"351": 0.09}}}
+	db.AccountSMS("1", "", "", 1) //Contado antes do detalhe por provider
+	db.AccountSMS("1", "zenvia", "+5511999990000", 2)
+	db.AccountSMS("1", "zenvia", "+5511999990001", 1)
+	db.AccountSMS("1", "zenvia", "+351912345678", 3)
+	db.AccountFailure("1", "zenvia", "+5511999990002")
+	month := time.Now()
+
+	report, err := Report(month, month, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []util.TUsageRow{
+		{Sent: 1, Segments: 1},
+		{Provider: "zenvia", Country: "351", Sent: 1, Segments: 3, UnitPrice: 0.09, Cost: 0.27},
+		{Provider: "zenvia", Country: "55", Sent: 2, Segments: 3, Failed: 1, UnitPrice: 0.05, Cost: 0.15},
+	}
+	if len(report.Rows) != len(expected) {
+		t.Fatalf("Report = %+v, esperado %d linhas", report.Rows, len(expected))
+	}
+	for i, row := range report.Rows {
+		expected[i].Month, expected[i].Bandeira = month.Format(MonthFormat), "1"
+		if row != expected[i] {
+			t.Errorf("linha %d = %+v, esperado %+v", i, row, expected[i])
+		}
+	}
+	if (report.TotalSent != 4) || (report.TotalSegments != 7) || (report.TotalCost != 0.42) {
+		t.Errorf("totais = %d envios, %d partes, %v; esperado 4, 7, 0.42", report.TotalSent, report.TotalSegments, report.TotalCost)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	CD_DLR_UNAUTHORIZED = 70
+	CD_DLR_INVALID      = 71
+)
+
+// registerMessage grava o SMS enviado para que o callback de entrega possa atualizar seu status
+func registerMessage(provider smsproviders.SmsProviderIntf, result smsproviders.SmsResult, phoneNumber string, bandeira string) {
+	smsId := fmt.Sprintf("%v", result.Data)
+	if smsId == "" {
+		return
+	}
+	if err := db.WriteMessage(smsId, provider.ProviderName(), phoneNumber, bandeira, smsproviders.DeliverySent); err != nil {
+		util.LogE("registerMessage: " + err.Error())
+	}
+}
+
+// deliveryReportHandler recebe o callback de entrega do provider indicado na rota. Ex: /api/sms/dlr/Sinch?key=...
+func deliveryReportHandler(ctx *fasthttp.RequestCtx) {
+	if !requireKey(ctx, util.AppCfg.DlrOptions.CallbackKey, CD_DLR_UNAUTHORIZED) {
+		return
+	}
+	providerName := fmt.Sprintf("%v", ctx.UserValue("provider"))
+	provider := smsproviders.GetProvider(providerName)
+	if provider == nil {
+		util.SendResponse(ctx, fasthttp.StatusNotFound, newErrorResponseFromValues(CD_DLR_INVALID, "Provider inválido", providerName))
+		return
+	}
+	reports, err := provider.CheckDeliveryReport(ctx.Request.Body())
+	if err != nil {
+		util.LogW("deliveryReportHandler (" + providerName + "): " + err.Error())
+		util.SendResponse(ctx, fasthttp.StatusBadRequest, newErrorResponseFromValues(CD_DLR_INVALID, util.MSG_INVALID_JSON_READ, err.Error()))
+		return
+	}
+	updated := 0
+	for _, report := range reports {
+		found, err := db.UpdateMessageStatus(report.SmsId, providerName, report.Status, report.ProviderStatus, report.Timestamp)
+		if err != nil {
+			util.LogE("deliveryReportHandler.UpdateMessageStatus: " + err.Error())
+			util.SendResponse(ctx, fasthttp.StatusInternalServerError, newErrorResponseFromValues(db.RedisWriteError, "Não foi possível gravar o status", err.Error()))
+			return
+		}
+		if found {
+			updated++
+		} else {
+			//Responde 200 mesmo assim, para que o provider não reenvie um relatório que nunca vai ser aceito
+			util.LogW("deliveryReportHandler: mensagem desconhecida: " + providerName + ":" + report.SmsId)
+		}
+	}
+	util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("OK", fmt.Sprintf("%d", updated)))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"gaudium.com.br/gaudiumsoftware/sms/fraud"
+	"gaudium.com.br/gaudiumsoftware/sms/metrics"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"strings"
+)
+
+const (
+	CD_FRAUD_BLOCKED   = 90
+	CD_FRAUD_CHALLENGE = 91
+	CD_FRAUD_INVALID   = 92
+)
+
+// checkFraud pontua o envio antes de chamar o provider. Envios suspeitos ficam retidos para revisão e os
+// bloqueados são recusados; nos dois casos responde e retorna false.
+func checkFraud(ctx *fasthttp.RequestCtx, bandeira string, phoneNumber string) bool {
+	switch assessFraud(requestLog(ctx).WithBandeira(bandeira), clientIp(ctx), bandeira, phoneNumber) {
+	case fraud.Block:
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_FRAUD_BLOCKED, "Envio não permitido para este número", ""))
+		return false
+	case fraud.Challenge:
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_FRAUD_CHALLENGE, "Envio em análise. Tente novamente mais tarde ou contate o suporte", ""))
+		return false
+	}
+	return true
+}
+
+// assessFraud pontua o envio e retorna a decisão. Os envios suspeitos (Challenge) ficam retidos para revisão.
+func assessFraud(reqLog util.Logger, ip string, bandeira string, phoneNumber string) int {
+	assessment := fraud.Assess(ip, bandeira, phoneNumber)
+	switch assessment.Decision {
+	case fraud.Block:
+		metrics.SendRejections.Inc("fraud_block", bandeira)
+		reqLog.W("assessFraud (Block): bd: " + bandeira + " pn: " + util.MaskPhone(phoneNumber) + " ip: " + ip + " " + strings.Join(assessment.Signals, "; "))
+	case fraud.Challenge:
+		metrics.SendRejections.Inc("fraud_challenge", bandeira)
+		reqLog.W("assessFraud (Challenge): bd: " + bandeira + " pn: " + util.MaskPhone(phoneNumber) + " ip: " + ip + " " + strings.Join(assessment.Signals, "; "))
+		if err := fraud.HoldForReview(assessment, ip, bandeira, phoneNumber); err != nil {
+			reqLog.E("assessFraud.HoldForReview: " + err.Error())
+		}
+	}
+	return assessment.Decision
+}
+
+// fraudHandler lista (GET) ou altera (POST, TFraudListRequest) as listas de permissão/bloqueio e os envios retidos
+func fraudHandler(ctx *fasthttp.RequestCtx) {
+	if ctx.IsPost() {
+		if !requireKey(ctx, util.AppCfg.FraudOptions.AdminKey, -1) {
+			return
+		}
+		req, err := util.NewFraudListRequest(ctx.Request.Body())
+		if err != nil {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INVALID_JSON_READ, err.Error()))
+			return
+		}
+		if err = fraud.UpdateList(req); err != nil {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_FRAUD_INVALID, "Não foi possível alterar a lista", err.Error()))
+			return
+		}
+		util.LogI("fraudHandler: " + req.Action + " " + req.Type + ":" + req.Value)
+	}
+
+	lists, err := fraud.Lists()
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_FRAUD_INVALID, "A leitura das listas falhou", err.Error()))
+		return
+	}
+	bts, err := json.Marshal(lists)
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INAVLID_JSON_WRITE, err.Error()))
+		return
+	}
+	util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("OK", string(bts)))
+}
@@ -0,0 +1,240 @@
+package fraud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/phone"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Allow = iota
+	Challenge
+	Block
+)
+
+const (
+	EntryNumber = "number"
+	EntryPrefix = "prefix"
+	EntryIp     = "ip"
+)
+
+// Assessment é a pontuação de um pedido de envio e os sinais que a compuseram
+type Assessment struct {
+	Score    int
+	Signals  []string
+	Decision int
+}
+
+func (a *Assessment) add(score int, signal string) {
+	a.Score += score
+	a.Signals = append(a.Signals, signal)
+}
+
+// Entry monta a entrada das listas de permissão/bloqueio. Ex: "prefix:55119". Os números são convertidos para
+// E.164, completados com o país da bandeira, como os telefones pontuados em Assess.
+func Entry(entryType string, value string, bandeira string) (string, error) {
+	switch entryType {
+	case EntryNumber:
+		e164, err := phone.NormalizeFor(value, bandeira)
+		if err != nil {
+			return "", err
+		}
+		return entryType + ":" + db.PhonePrefix(e164, len(e164)), nil
+	case EntryPrefix:
+		digits := db.PhonePrefix(value, len(value))
+		if digits == "" {
+			return "", errors.New("Telefone ou prefixo inválido")
+		}
+		return entryType + ":" + digits, nil
+	case EntryIp:
+		if value == "" {
+			return "", errors.New("IP inválido")
+		}
+		return entryType + ":" + value, nil
+	default:
+		return "", fmt.Errorf("Tipo inválido: %s", entryType)
+	}
+}
+
+// findEntry retorna a entrada da lista que casa com o número, um prefixo dele ou o IP
+func findEntry(list []string, phoneNumber string, ip string) string {
+	for _, entry := range list {
+		switch {
+		case entry == EntryNumber+":"+phoneNumber:
+			return entry
+		case strings.HasPrefix(entry, EntryPrefix+":") && strings.HasPrefix(phoneNumber, strings.TrimPrefix(entry, EntryPrefix+":")):
+			return entry
+		case (ip != "") && (entry == EntryIp+":"+ip):
+			return entry
+		}
+	}
+	return ""
+}
+
+// countSequential conta os números recentes do prefixo próximos do pedido (ataques costumam varrer faixas)
+func countSequential(phoneNumber string, recent []string, distance int64) int {
+	number, err := strconv.ParseInt(phoneNumber, 10, 64)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, other := range recent {
+		otherNumber, err := strconv.ParseInt(other, 10, 64)
+		if (err != nil) || (otherNumber == number) {
+			continue
+		}
+		diff := otherNumber - number
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= distance {
+			count++
+		}
+	}
+	return count
+}
+
+// Assess pontua o pedido de envio. Falhas de leitura apenas deixam de pontuar o sinal correspondente.
+// O pedido é contado nos sinais de velocidade e sequência, mesmo que seja bloqueado.
+func Assess(ip string, bandeira string, phoneNumber string) Assessment {
+	cfg := util.AppCfg.FraudOptions
+	result := Assessment{Decision: Allow}
+	if !cfg.Enabled {
+		return result
+	}
+	digits := db.PhonePrefix(phoneNumber, len(phoneNumber))
+	prefix := db.ConfiguredPhonePrefix(digits)
+
+	allowList, err := db.ReadFraudList(db.FraudAllowList)
+	if err != nil {
+		util.LogE("fraud.Assess: " + err.Error())
+	}
+	if entry := findEntry(allowList, digits, ip); entry != "" {
+		result.Signals = append(result.Signals, "liberado: "+entry)
+		return result
+	}
+	denyList, err := db.ReadFraudList(db.FraudDenyList)
+	if err != nil {
+		util.LogE("fraud.Assess: " + err.Error())
+	}
+	if entry := findEntry(denyList, digits, ip); entry != "" {
+		result.add(cfg.BlockScore, "bloqueado: "+entry)
+	}
+
+	for _, riskPrefix := range strings.Split(cfg.HighRiskPrefixes, ",") {
+		riskPrefix = strings.TrimSpace(riskPrefix)
+		if (riskPrefix != "") && strings.HasPrefix(digits, riskPrefix) {
+			result.add(cfg.HighRiskPrefixScore, "prefixo de risco: "+riskPrefix)
+			break
+		}
+	}
+
+	if requests, err := db.CountFraudNumberRequest(digits); err != nil {
+		util.LogE("fraud.Assess: " + err.Error())
+	} else if (cfg.NumberVelocityPerHour > 0) && (requests > cfg.NumberVelocityPerHour) {
+		result.add(cfg.NumberVelocityScore, fmt.Sprintf("velocidade: %d pedidos na hora", requests))
+	}
+
+	if prefix != "" {
+		if recent, err := db.PushFraudRecentNumber(prefix, digits); err != nil {
+			util.LogE("fraud.Assess: " + err.Error())
+		} else if sequential := countSequential(digits, recent, int64(cfg.SequentialDistance)); (cfg.SequentialMinCount > 0) && (sequential >= cfg.SequentialMinCount) {
+			result.add(cfg.SequentialScore, fmt.Sprintf("sequência: %d números próximos no prefixo %s", sequential, prefix))
+		}
+
+		if sent, verified, err := db.ReadFraudConversion(prefix); err != nil {
+			util.LogE("fraud.Assess: " + err.Error())
+		} else if (cfg.MinConversionSample > 0) && (sent >= cfg.MinConversionSample) && (verified*100 < sent*cfg.MinConversionRate) {
+			result.add(cfg.LowConversionScore, fmt.Sprintf("conversão: %d de %d verificados no prefixo %s", verified, sent, prefix))
+		}
+	}
+
+	if result.Score >= cfg.BlockScore {
+		result.Decision = Block
+	} else if result.Score >= cfg.ChallengeScore {
+		result.Decision = Challenge
+	}
+	return result
+}
+
+// HoldForReview grava o envio retido para que o suporte libere ou bloqueie o número
+func HoldForReview(assessment Assessment, ip string, bandeira string, phoneNumber string) error {
+	review := util.TFraudReview{PhoneNumber: phoneNumber, Bandeira: bandeira, Ip: ip, Score: assessment.Score,
+		Signals: assessment.Signals, Timestamp: time.Now().Format(time.RFC3339)}
+	bts, err := json.Marshal(review)
+	if err != nil {
+		return err
+	}
+	return db.WriteFraudReview(db.PhonePrefix(phoneNumber, len(phoneNumber)), string(bts))
+}
+
+// RecordVerificationSent conta o envio de verificação na conversão do prefixo
+func RecordVerificationSent(phoneNumber string) {
+	db.CountFraudConversion(db.PhonePrefix(phoneNumber, len(phoneNumber)), false)
+}
+
+// Lists retorna as listas de permissão/bloqueio e os envios retidos, do mais recente para o mais antigo
+func Lists() (result util.TFraudListsResponse, err error) {
+	if result.Allow, err = db.ReadFraudList(db.FraudAllowList); err != nil {
+		return result, err
+	}
+	if result.Deny, err = db.ReadFraudList(db.FraudDenyList); err != nil {
+		return result, err
+	}
+	sort.Strings(result.Allow)
+	sort.Strings(result.Deny)
+	reviews, err := db.ReadFraudReviews()
+	if err != nil {
+		return result, err
+	}
+	result.Reviews = []util.TFraudReview{}
+	for _, sReview := range reviews {
+		var review util.TFraudReview
+		if json.Unmarshal([]byte(sReview), &review) == nil {
+			result.Reviews = append(result.Reviews, review)
+		}
+	}
+	sort.Slice(result.Reviews, func(i, j int) bool {
+		return result.Reviews[i].Timestamp > result.Reviews[j].Timestamp
+	})
+	return result, nil
+}
+
+// UpdateList libera (allow), bloqueia (deny) ou retira das listas (remove) um número, prefixo ou IP.
+// Liberar ou bloquear um número encerra a revisão pendente dele.
+func UpdateList(req util.TFraudListRequest) error {
+	entry, err := Entry(req.Type, req.Value, req.Bandeira)
+	if err != nil {
+		return err
+	}
+	switch req.Action {
+	case db.FraudAllowList:
+		err = db.RemoveFraudListEntry(db.FraudDenyList, entry)
+		if err == nil {
+			err = db.AddFraudListEntry(db.FraudAllowList, entry)
+		}
+	case db.FraudDenyList:
+		err = db.RemoveFraudListEntry(db.FraudAllowList, entry)
+		if err == nil {
+			err = db.AddFraudListEntry(db.FraudDenyList, entry)
+		}
+	case "remove":
+		err = db.RemoveFraudListEntry(db.FraudAllowList, entry)
+		if err == nil {
+			err = db.RemoveFraudListEntry(db.FraudDenyList, entry)
+		}
+	default:
+		return fmt.Errorf("Ação inválida: %s", req.Action)
+	}
+	if (err == nil) && (req.Type == EntryNumber) {
+		err = db.DiscardFraudReview(strings.TrimPrefix(entry, EntryNumber+":"))
+	}
+	return err
+}
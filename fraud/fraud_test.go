@@ -0,0 +1,173 @@
+package fraud
+
+import (
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"strings"
+	"testing"
+)
+
+// useFraud troca o armazenamento e a configuração pelos do teste, com a pontuação ligada, e os restaura no fim
+func useFraud(t *testing.T, cfg util.Fraud) {
+	previousRepo := db.CurrentRepository()
+	previousCfg := util.AppCfg
+	t.Cleanup(func() {
+		db.SetRepository(previousRepo)
+		util.AppCfg = previousCfg
+	})
+	db.SetRepository(db.NewMemoryRepository())
+	cfg.Enabled = true
+	util.AppCfg = util.Config{FraudOptions: cfg}
+}
+
+// hasSignal indica se algum sinal da avaliação começa com prefix
+func hasSignal(assessment Assessment, prefix string) bool {
+	for _, signal := range assessment.Signals {
+		if strings.HasPrefix(signal, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEntry(t *testing.T) {
+	useFraud(t, util.Fraud{})
+	cases := []struct {
+		entryType, value string
+		entry            string
+	}{
+		{EntryNumber, "+55 (11) 99999-0000", "number:5511999990000"},
+		{EntryNumber, "11999990000", "number:5511999990000"}, //Completado com o país da bandeira
+		{EntryPrefix, "+55 119", "prefix:55119"},
+		{EntryIp, "10.0.0.1", "ip:10.0.0.1"},
+		{EntryPrefix, "abc", ""},
+		{EntryIp, "", ""},
+		{"email", "a@b.com", ""},
+	}
+	for _, c := range cases {
+		entry, err := Entry(c.entryType, c.value, "1")
+		if (entry != c.entry) || ((err == nil) != (c.entry != "")) {
+			t.Errorf("Entry(%q, %q) = %q, %v; esperado %q", c.entryType, c.value, entry, err, c.entry)
+		}
+	}
+}
+
+func TestCountSequential(t *testing.T) {
+	recent := []string{"5511999990000", "5511999990003", "5511999990010", "5511999990500", "inválido"}
+	if count := countSequential("5511999990001", recent, 10); count != 3 {
+		t.Errorf("countSequential = %d, esperado 3", count)
+	}
+	//O próprio número não conta
+	if count := countSequential("5511999990000", recent, 5); count != 1 {
+		t.Errorf("countSequential do número repetido = %d, esperado 1", count)
+	}
+	if count := countSequential("+55", recent, 10); count != 0 {
+		t.Errorf("countSequential de número inválido = %d, esperado 0", count)
+	}
+}
+
+func TestAssessDisabled(t *testing.T) {
+	useFraud(t, util.Fraud{})
+	util.AppCfg.FraudOptions.Enabled = false
+	if assessment := Assess("10.0.0.1", "1", "+5511999990000"); (assessment.Decision != Allow) || (len(assessment.Signals) != 0) {
+		t.Errorf("Assess desligado = %+v, esperado liberado sem sinais", assessment)
+	}
+}
+
+func TestAssessLists(t *testing.T) {
+	useFraud(t, util.Fraud{ChallengeScore: 50, BlockScore: 100, HighRiskPrefixes: "55119", HighRiskPrefixScore: 60})
+
+	if assessment := Assess("10.0.0.1", "1", "+5511999990000"); (assessment.Decision != Challenge) || !hasSignal(assessment, "prefixo de risco") {
+		t.Errorf("Assess no prefixo de risco = %+v, esperado retido", assessment)
+	}
+
+	if err := UpdateList(util.TFraudListRequest{Action: db.FraudDenyList, Type: EntryIp, Value: "10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if assessment := Assess("10.0.0.2", "1", "+5521999990000"); (assessment.Decision != Block) || !hasSignal(assessment, "bloqueado: ip:10.0.0.2") {
+		t.Errorf("Assess do IP bloqueado = %+v, esperado bloqueado", assessment)
+	}
+
+	//A lista de permissão prevalece sobre os demais sinais, e liberar retira da lista de bloqueio
+	if err := UpdateList(util.TFraudListRequest{Action: db.FraudAllowList, Type: EntryIp, Value: "10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if assessment := Assess("10.0.0.2", "1", "+5511999990000"); (assessment.Decision != Allow) || (assessment.Score != 0) {
+		t.Errorf("Assess do IP liberado = %+v, esperado liberado", assessment)
+	}
+	lists, err := Lists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(lists.Allow) != 1) || (len(lists.Deny) != 0) {
+		t.Errorf("Lists = %+v, esperado só o IP liberado", lists)
+	}
+
+	if err = UpdateList(util.TFraudListRequest{Action: "remove", Type: EntryIp, Value: "10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = UpdateList(util.TFraudListRequest{Action: "ignore", Type: EntryIp, Value: "10.0.0.2"}); err == nil {
+		t.Error("UpdateList com ação inválida sem erro")
+	}
+	if lists, _ = Lists(); (len(lists.Allow) != 0) || (len(lists.Deny) != 0) {
+		t.Errorf("Lists depois de remove = %+v, esperado vazias", lists)
+	}
+}
+
+func TestAssessVelocityAndSequence(t *testing.T) {
+	useFraud(t, util.Fraud{ChallengeScore: 50, BlockScore: 100, NumberVelocityPerHour: 2, NumberVelocityScore: 50,
+		SequentialDistance: 10, SequentialMinCount: 2, SequentialScore: 60})
+
+	for i := 1; i <= 3; i++ {
+		assessment := Assess("10.0.0.1", "1", "+5511999990000")
+		if velocity := hasSignal(assessment, "velocidade"); velocity != (i > 2) {
+			t.Errorf("%dº pedido: sinais %v, esperado velocidade: %v", i, assessment.Signals, i > 2)
+		}
+	}
+
+	Assess("10.0.0.1", "1", "+5521999990001")
+	Assess("10.0.0.1", "1", "+5521999990002")
+	assessment := Assess("10.0.0.1", "1", "+5521999990003")
+	if !hasSignal(assessment, "sequência: 2") || (assessment.Decision != Challenge) {
+		t.Errorf("Assess depois de números próximos = %+v, esperado retido por sequência", assessment)
+	}
+}
+
+func TestAssessLowConversion(t *testing.T) {
+	useFraud(t, util.Fraud{ChallengeScore: 50, BlockScore: 100, MinConversionSample: 3, MinConversionRate: 50, LowConversionScore: 50})
+	for i := 0; i < 3; i++ {
+		RecordVerificationSent("+5511999990000")
+	}
+	db.CountFraudConversion("5511999990000", true)
+
+	assessment := Assess("10.0.0.1", "1", "+5511999990001")
+	if !hasSignal(assessment, "conversão: 1 de 3") || (assessment.Decision != Challenge) {
+		t.Errorf("Assess com conversão baixa = %+v, esperado retido", assessment)
+	}
+	if assessment = Assess("10.0.0.1", "1", "+5521999990001"); hasSignal(assessment, "conversão") {
+		t.Errorf("Assess em outro prefixo = %+v, esperado sem sinal de conversão", assessment)
+	}
+}
+
+func TestHoldForReview(t *testing.T) {
+	useFraud(t, util.Fraud{})
+	assessment := Assessment{Score: 60, Signals: []string{"prefixo de risco: 55119"}, Decision: Challenge}
+	if err := HoldForReview(assessment, "10.0.0.1", "1", "+5511999990000"); err != nil {
+		t.Fatal(err)
+	}
+	lists, err := Lists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(lists.Reviews) != 1) || (lists.Reviews[0].PhoneNumber != "+5511999990000") || (lists.Reviews[0].Score != 60) {
+		t.Fatalf("Lists.Reviews = %+v, esperado o envio retido", lists.Reviews)
+	}
+
+	//Liberar o número encerra a revisão
+	if err = UpdateList(util.TFraudListRequest{Action: db.FraudAllowList, Type: EntryNumber, Value: "+5511999990000"}); err != nil {
+		t.Fatal(err)
+	}
+	if lists, _ = Lists(); len(lists.Reviews) != 0 {
+		t.Errorf("Lists.Reviews depois de liberar = %+v, esperado vazia", lists.Reviews)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"time"
+)
+
+const (
+	readinessReady       = "ready"
+	readinessDegraded    = "degraded"
+	readinessUnavailable = "unavailable"
+
+	logMachineProbeTimeout = 2 * time.Second
+)
+
+// healthHandler é a verificação de vida para o balanceador: responde 200 enquanto o processo atende
+func healthHandler(ctx *fasthttp.RequestCtx) {
+	util.SendResponse(ctx, fasthttp.StatusOK, `{"status":"ok"}`)
+}
+
+// readyHandler verifica as dependências: Redis, logmachine e os providers da cadeia geral. Responde 503 quando o
+// serviço não consegue enviar (Redis fora ou todos os providers com o circuito aberto). Sem o logmachine o serviço
+// continua enviando (os eventos esperam na outbox) e o status é degraded.
+func readyHandler(ctx *fasthttp.RequestCtx) {
+	chain := currentProviderChain()
+	readiness := util.TReadiness{Status: readinessReady, Chain: chain.String(), Redis: checkRedis(), LogMachine: checkLogMachine()}
+	for _, name := range smsproviders.RegisteredProviders() {
+		readiness.Providers = append(readiness.Providers, smsproviders.ProviderHealth(name))
+	}
+	if readiness.Redis.Ok {
+		if queued, retrying, dead, err := db.OutboxLength(); err == nil {
+			readiness.Outbox = map[string]int64{"queued": queued, "retrying": retrying, "dead": dead}
+		}
+	}
+
+	status := fasthttp.StatusOK
+	switch {
+	case !readiness.Redis.Ok || !chain.Available():
+		readiness.Status = readinessUnavailable
+		status = fasthttp.StatusServiceUnavailable
+	case !readiness.LogMachine.Ok:
+		readiness.Status = readinessDegraded
+	}
+	bts, err := json.Marshal(readiness)
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INAVLID_JSON_WRITE, err.Error()))
+		return
+	}
+	ctx.SetContentType("application/json; charset=UTF-8")
+	util.SendResponse(ctx, status, string(bts))
+}
+
+func checkRedis() util.THealthCheck {
+	start := time.Now()
+	err := db.Ping()
+	return newHealthCheck(start, err)
+}
+
+// checkLogMachine considera o logmachine acessível se ele responde, mesmo com erro do cliente (4xx)
+func checkLogMachine() util.THealthCheck {
+	start := time.Now()
+	if util.AppCfg.LogMachine == "" {
+		return newHealthCheck(start, fmt.Errorf("LogMachine não configurado"))
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(util.AppCfg.LogMachine)
+	req.Header.SetMethod("GET")
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	client := &fasthttp.Client{}
+	err := client.DoTimeout(req, resp, logMachineProbeTimeout)
+	if (err == nil) && (resp.StatusCode() >= fasthttp.StatusInternalServerError) {
+		err = fmt.Errorf("logmachine respondeu %d", resp.StatusCode())
+	}
+	return newHealthCheck(start, err)
+}
+
+func newHealthCheck(start time.Time, err error) util.THealthCheck {
+	check := util.THealthCheck{Ok: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"time"
+)
+
+var (
+	heartbeatStop = make(chan struct{}) //Fechado no encerramento, depois que os dispatchers terminam
+	heartbeatDone = make(chan struct{})
+)
+
+// startInstance registra a instância antes que os dispatchers peguem trabalho das filas, devolve à fila o trabalho
+// das instâncias paradas e mantém o registro renovado até stopInstance
+func startInstance() {
+	if err := db.RenewInstance(); err != nil {
+		util.LogE("startInstance: " + err.Error())
+	}
+	requeueOrphanedWork()
+	go runInstanceHeartbeat()
+}
+
+// runInstanceHeartbeat renova o registro da instância, inclusive durante o encerramento, enquanto os jobs e as
+// entregas em andamento terminam. No fim remove o registro.
+func runInstanceHeartbeat() {
+	defer close(heartbeatDone)
+	for {
+		select {
+		case <-heartbeatStop:
+			if err := db.ReleaseInstance(); err != nil {
+				util.LogE("runInstanceHeartbeat.release: " + err.Error())
+			}
+			return
+		case <-time.After(db.InstanceHeartbeatInterval):
+		}
+		if err := db.RenewInstance(); err != nil {
+			util.LogE("runInstanceHeartbeat: " + err.Error())
+		}
+		if !isStopping() {
+			requeueOrphanedWork()
+		}
+	}
+}
+
+// requeueOrphanedWork devolve à fila os jobs e eventos das instâncias que pararam sem concluí-los
+func requeueOrphanedWork() {
+	recovered, err := db.RequeueOrphanedWork()
+	if err != nil {
+		util.LogE("requeueOrphanedWork: " + err.Error())
+	} else if recovered > 0 {
+		util.LogI(fmt.Sprintf("requeueOrphanedWork: trabalho de %d instâncias paradas devolvido à fila", recovered))
+	}
+}
+
+// stopInstance remove o registro da instância: o trabalho que ficou em andamento é retomado pelas outras instâncias
+// ou na próxima partida
+func stopInstance() {
+	close(heartbeatStop)
+	<-heartbeatDone
+}
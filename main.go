@@ -3,6 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/fraud"
+	"gaudium.com.br/gaudiumsoftware/sms/metrics"
+	"gaudium.com.br/gaudiumsoftware/sms/otp"
+	"gaudium.com.br/gaudiumsoftware/sms/phone"
+	"gaudium.com.br/gaudiumsoftware/sms/quota"
 	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
 	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
 	"gaudium.com.br/gaudiumsoftware/sms/smsproviders/sinchprovider"
@@ -12,6 +17,9 @@ import (
 	"github.com/valyala/fasthttp"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 const (
@@ -27,13 +35,28 @@ const (
 	//Standard SMS
 	messagingEndPoint      = rootEndpoint + "/messaging"
 	RequestSendSmsEndpoint = messagingEndPoint + "/sendSms"
+	batchEndpoint          = messagingEndPoint + "/batch"
+	batchStatusEndpoint    = batchEndpoint + "/{id}"
+	batchResultsEndpoint   = batchStatusEndpoint + "/results"
+
+	//Delivery reports (callbacks dos providers)
+	deliveryReportEndpoint = rootEndpoint + "/dlr/{provider}"
 
 	//Backend service (internal only)
 	findTokenEndpoint      = rootInternalEndpoint + "/findToken"
 	changeProviderEndpoint = rootInternalEndpoint + "/provider"
+	messageStatusEndpoint  = rootInternalEndpoint + "/status"
+	fraudEndpoint          = rootInternalEndpoint + "/fraud"
+	billingEndpoint        = rootInternalEndpoint + "/billing"
+	healthEndpoint         = rootInternalEndpoint + "/health"
+	readyEndpoint          = rootInternalEndpoint + "/ready"
+	metricsEndpoint        = rootInternalEndpoint + "/metrics"
 )
 
-var defaultProvider = sinchprovider.SinchProviderName
+var defaultProviderChain = sinchprovider.SinchProviderName + "," + zenviaprovider.ZenviaProviderName
+
+var providerChain *smsproviders.ProviderChain
+var providerChainMutex sync.RWMutex
 
 /*
 - Enviar SMS de verificação
@@ -53,15 +76,36 @@ var defaultProvider = sinchprovider.SinchProviderName
 		- Envio do código recebido ao backend (ok)
 */
 
-func NewSmsProvider(ProviderName string) smsproviders.SmsProviderIntf {
-	switch ProviderName {
-	case sinchprovider.SinchProviderName:
-		return sinchprovider.NewSinchSmsVerifier()
-	case zenviaprovider.ZenviaProviderName:
-		return zenviaprovider.NewZenviaSmsVerifier()
-	default:
-		return nil
+// NewProviderChain monta a cadeia de failover a partir de uma lista de nomes separados por vírgula
+func NewProviderChain(providerNames string) (*smsproviders.ProviderChain, error) {
+	var providers []smsproviders.SmsProviderIntf
+	for _, name := range strings.Split(providerNames, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		provider := smsproviders.GetProvider(name)
+		if provider == nil {
+			return nil, fmt.Errorf("Provider inválido: %s", name)
+		}
+		providers = append(providers, provider)
+	}
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("Nenhum provider informado")
 	}
+	return smsproviders.NewProviderChain(providers...), nil
+}
+
+func currentProviderChain() *smsproviders.ProviderChain {
+	providerChainMutex.RLock()
+	defer providerChainMutex.RUnlock()
+	return providerChain
+}
+
+func setProviderChain(chain *smsproviders.ProviderChain) {
+	providerChainMutex.Lock()
+	providerChain = chain
+	providerChainMutex.Unlock()
 }
 
 func newOkResponse(result smsproviders.SmsResult) string {
@@ -69,7 +113,7 @@ func newOkResponse(result smsproviders.SmsResult) string {
 }
 
 func newOkResponseFromValues(Msg string, Data string) string {
-	response := util.TResponse{smsproviders.Success, smsproviders.SuccessCode, Msg, Data}
+	response := util.TResponse{Success: smsproviders.Success, Code: smsproviders.SuccessCode, Msg: Msg, Data: Data}
 	bts, err := json.Marshal(response)
 	if err == nil {
 		return string(bts)
@@ -96,98 +140,241 @@ func newErrorResponseFromValues(Code int, Err string, Data string) (result strin
 func requestVerificationHandler(ctx *fasthttp.RequestCtx) {
 	sendReq, err := util.NewSendRequest(ctx.Request.Body())
 	if err == nil {
+		reqLog := requestLog(ctx).WithBandeira(sendReq.Bandeira)
 		//Descomentar para teste
 		//util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("OK", ""))
 		//return
-		util.LogD("requestVerificationHandler: pn: " + sendReq.PhoneNumber)
+		if !normalizePhone(ctx, sendReq.Bandeira, &sendReq.PhoneNumber) {
+			return
+		}
+		reqLog.D("requestVerificationHandler: pn: " + util.MaskPhone(sendReq.PhoneNumber))
+		//Antes de WriteRequest: um envio recusado não pode ocupar o intervalo do telefone nem liberar um pedido bloqueado
+		if !checkQuota(ctx, sendReq.Bandeira) {
+			return
+		}
+		rateLimits, allowed := checkSendRateLimits(ctx, sendReq.Bandeira, sendReq.PhoneNumber)
+		if !allowed {
+			return
+		}
+		if !checkFraud(ctx, sendReq.Bandeira, sendReq.PhoneNumber) {
+			rateLimits.Release()
+			return
+		}
 		_ = db.MovePossibleFailedRequest(&sendReq.PhoneNumber, &sendReq.Bandeira)
-		reqData := db.NewRequestData("", "", sendReq.PhoneNumber, sendReq.Bandeira, "", "", "")
+		reqData := db.NewRequestData("", "", sendReq.PhoneNumber, sendReq.Bandeira, "", "", "", "")
 		//Grava as primeiras informações do pedido de envio
 		reqData, err = db.WriteRequest(reqData)
 		if err != nil {
+			rateLimits.Release()
 			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisWriteError, err.Error(), ""))
 			return
 		}
-		provider := NewSmsProvider(defaultProvider)
-		result := provider.SendVerificationRequest(sendReq.PhoneNumber, sendReq.Content, sendReq.AppId)
+		result, provider := chainForBandeira(sendReq.Bandeira).Do(func(provider smsproviders.SmsProviderIntf) smsproviders.SmsResult {
+			if otp.IsLocal(provider) {
+				return otp.Send(provider, sendReq.PhoneNumber, sendReq.Bandeira, reqData.IdPedidoEnvio, sendReq.AppId)
+			}
+			return provider.SendVerificationRequest(sendReq.PhoneNumber, sendReq.Content, sendReq.AppId)
+		})
+		countSend(sendKindVerification, sendReq.Bandeira, provider, result)
 		if result.IsSuccess == smsproviders.Success {
-			sq := db.AccountSMS(sendReq.Bandeira)
+			sq := quota.Account(sendReq.Bandeira, provider.ProviderName(), sendReq.PhoneNumber, result.Segments)
 			if sq > "" {
 				reqData.Sq = sq
 			} else {
 				util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues(result.Msg, ""))
 				return
 			}
-			util.LogD("requestVerificationHandler (Success): " + result.Msg + " / " + fmt.Sprintf("%v", result.Data))
+			reqLog.WithProvider(provider.ProviderName()).D("requestVerificationHandler (Success): " + result.Msg + " / " + fmt.Sprintf("%v", result.Data))
 			reqData.SmsId = fmt.Sprintf("%v", result.Data)
+			reqData.Provider = provider.ProviderName()
+			registerMessage(provider, result, sendReq.PhoneNumber, sendReq.Bandeira)
+			fraud.RecordVerificationSent(sendReq.PhoneNumber)
 			//Grava as informações restantes após o peido de envio ter tido sucesso
 			reqData, err = db.WriteRequest(reqData)
 			if err == nil {
 				util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues(result.Msg, ""))
 			} else {
-				util.LogD("requestVerificationHandler.WriteRequest (NoSuccess): " + err.Error())
+				reqLog.D("requestVerificationHandler.WriteRequest (NoSuccess): " + err.Error())
 				util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisWriteError, err.Error(), ""))
 			}
 		} else {
-			util.LogD("requestVerificationHandler (NoSuccess): " + result.Msg)
+			reqLog.D("requestVerificationHandler (NoSuccess): " + result.Msg)
+			accountFailure(provider, sendReq.Bandeira, sendReq.PhoneNumber)
+			rateLimits.Release()
 			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponse(result))
 		}
 	} else {
-		util.LogD("requestVerificationHandler (Error): " + err.Error())
+		requestLog(ctx).D("requestVerificationHandler (Error): " + err.Error())
 		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INVALID_JSON_READ, err.Error()))
 	}
 }
 
-/*func requestSmsHandler(ctx *fasthttp.RequestCtx) {
-	var smsReq util.TSendRequest
-	err := json.Unmarshal(ctx.Request.Body(), &smsReq)
+func requestSmsHandler(ctx *fasthttp.RequestCtx) {
+	if !requireKey(ctx, util.AppCfg.MessagingOptions.ApiKey, CD_MESSAGING_UNAUTHORIZED) {
+		return
+	}
+	smsReq, err := util.NewSendRequest(ctx.Request.Body())
 	if err == nil {
-		provider := NewSmsProvider(defaultProvider)
-		util.LogD(smsReq.PhoneNumber)
-		util.LogD(smsReq.Content)
-		result := provider.SendMessageRequest(smsReq.PhoneNumber, smsReq.Content, smsReq.AppId)
+		reqLog := requestLog(ctx).WithBandeira(smsReq.Bandeira)
+		if (smsReq.PhoneNumber == "") || (smsReq.Content == "") {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INVALID_JSON_READ, "phoneNumber e content são obrigatórios"))
+			return
+		}
+		if !normalizePhone(ctx, smsReq.Bandeira, &smsReq.PhoneNumber) {
+			return
+		}
+		reqLog.D("requestSmsHandler: pn: " + util.MaskPhone(smsReq.PhoneNumber))
+		if !checkQuota(ctx, smsReq.Bandeira) {
+			return
+		}
+		rateLimits, allowed := checkSendRateLimits(ctx, smsReq.Bandeira, smsReq.PhoneNumber)
+		if !allowed {
+			return
+		}
+		if !checkFraud(ctx, smsReq.Bandeira, smsReq.PhoneNumber) {
+			rateLimits.Release()
+			return
+		}
+		senderId := util.AppCfg.Bandeira(smsReq.Bandeira).SenderId
+		result, provider := chainForBandeira(smsReq.Bandeira).Do(func(provider smsproviders.SmsProviderIntf) smsproviders.SmsResult {
+			return provider.SendMessageRequest(smsReq.PhoneNumber, smsReq.Content, smsReq.AppId, senderId)
+		})
+		countSend(sendKindMessage, smsReq.Bandeira, provider, result)
 		if result.IsSuccess == smsproviders.Success {
+			reqLog.WithProvider(provider.ProviderName()).D("requestSmsHandler (Success): " + fmt.Sprintf("%v", result.Data))
+			quota.Account(smsReq.Bandeira, provider.ProviderName(), smsReq.PhoneNumber, result.Segments)
+			registerMessage(provider, result, smsReq.PhoneNumber, smsReq.Bandeira)
 			util.SendResponse(ctx, fasthttp.StatusOK, newOkResponse(result))
 		} else {
+			reqLog.D("requestSmsHandler (NoSuccess): " + result.Msg)
+			accountFailure(provider, smsReq.Bandeira, smsReq.PhoneNumber)
+			rateLimits.Release()
 			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponse(result))
 		}
 	} else {
 		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INVALID_JSON_READ, err.Error()))
 	}
-}*/
+}
+
+// normalizePhone converte o telefone do pedido para E.164, que é o formato das chaves no Redis e o enviado aos
+// providers. Se o telefone é inválido ou de um país que a bandeira não atende, responde com o erro e retorna false.
+func normalizePhone(ctx *fasthttp.RequestCtx, bandeira string, phoneNumber *string) bool {
+	e164, err := phone.NormalizeFor(*phoneNumber, bandeira)
+	if err != nil {
+		requestLog(ctx).WithBandeira(bandeira).D("normalizePhone: " + util.MaskPhone(*phoneNumber) + ": " + err.Error())
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(phone.ErrorCode(err), err.Error(), *phoneNumber))
+		return false
+	}
+	*phoneNumber = e164
+	return true
+}
+
+// providerForRequest retorna o provider que enviou o código do pedido. Pedidos gravados antes do campo pv
+// existir são verificados no primeiro provider da cadeia da bandeira.
+func providerForRequest(reqData *db.RequestData) smsproviders.SmsProviderIntf {
+	if reqData.Provider != "" {
+		if provider := smsproviders.GetProvider(reqData.Provider); provider != nil {
+			return provider
+		}
+		util.LogW("providerForRequest: provider desconhecido: " + reqData.Provider)
+	}
+	return chainForBandeira(reqData.Bandeira).First()
+}
+
+// lockVerification invalida o código pendente depois de esgotadas as tentativas
+func lockVerification(ctx *fasthttp.RequestCtx, vReq *util.TVerifyRequest, providerName string) {
+	requestLog(ctx).WithBandeira(vReq.Bandeira).W("VerifyResponse (Locked): " + vReq.Bandeira + ":" + util.MaskPhone(vReq.PhoneNumber))
+	metrics.Verifications.Inc(vReq.Bandeira, providerName, "locked")
+	err := db.LockRequest(&vReq.PhoneNumber, &vReq.Bandeira)
+	if err != nil {
+		requestLog(ctx).WithBandeira(vReq.Bandeira).E("lockVerification: " + err.Error())
+	}
+	db.DiscardOtp(&vReq.PhoneNumber, &vReq.Bandeira)
+	util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_VERIFY_LOCKED, util.MSG_VERIFY_LOCKED, ""))
+}
 
 func verifyHandler(ctx *fasthttp.RequestCtx) {
 	vReq, err := util.NewVerifyRequest(ctx.Request.Body())
 	if err == nil {
-		util.LogD("VerifyRequest: " + vReq.PhoneNumber + " / " + vReq.ValidationCode)
-		provider := NewSmsProvider(defaultProvider)
-		util.LogD(vReq.PhoneNumber)
-		util.LogD(vReq.ValidationCode)
-		result := provider.VerifyRequest(vReq.PhoneNumber, vReq.ValidationCode, vReq.ValidationCode)
+		reqLog := requestLog(ctx).WithBandeira(vReq.Bandeira)
+		if !normalizePhone(ctx, vReq.Bandeira, &vReq.PhoneNumber) {
+			return
+		}
+		reqLog.D("VerifyRequest: pn: " + util.MaskPhone(vReq.PhoneNumber))
+		var reqData *db.RequestData
+		reqData, err = db.ReadRequest(&vReq.PhoneNumber, &vReq.Bandeira)
+		if (reqData == nil) || (reqData.IdPedidoEnvio == "") {
+			metrics.Verifications.Inc(vReq.Bandeira, "", "not_found")
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisNotFoundError, "Pedido inválido ou expirou", ""))
+			return
+		}
+		if reqData.Locked {
+			metrics.Verifications.Inc(vReq.Bandeira, reqData.Provider, "locked")
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_VERIFY_LOCKED, util.MSG_VERIFY_LOCKED, ""))
+			return
+		}
+		provider := providerForRequest(reqData)
+		if provider == nil {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(smsproviders.NoProviderErrorCode, "Nenhum provider disponível", reqData.Provider))
+			return
+		}
+		maxAttempts := util.AppCfg.SmsOptions.MaxVerifyAttempts
+		if maxAttempts <= 0 {
+			maxAttempts = util.DefaultMaxVerifyAttempts
+		}
+		//A tentativa é contada antes da verificação: verificações simultâneas não passam do limite
+		var attempt db.VerifyAttempt
+		attempt, err = db.ClaimVerifyAttempt(&vReq.PhoneNumber, &vReq.Bandeira, maxAttempts)
+		if err != nil {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisWriteError, "Não foi possível registrar a tentativa", err.Error()))
+			return
+		}
+		if attempt.Attempts == 0 {
+			metrics.Verifications.Inc(vReq.Bandeira, reqData.Provider, "not_found")
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisNotFoundError, "Pedido inválido ou expirou", ""))
+			return
+		}
+		if attempt.Locked {
+			lockVerification(ctx, &vReq, provider.ProviderName())
+			return
+		}
+		reqLog = reqLog.WithProvider(provider.ProviderName())
+		reqLog.D("VerifyRequest.provider: " + provider.ProviderName())
+		db.CountMessageVerifyAttempt(reqData.SmsId)
+		result, handled := otp.Verify(vReq.PhoneNumber, vReq.Bandeira, reqData.IdPedidoEnvio, vReq.ValidationCode)
+		if !handled {
+			result = provider.VerifyRequest(vReq.PhoneNumber, vReq.ValidationCode, vReq.ValidationCode)
+		}
 		if result.IsSuccess == smsproviders.Success {
-			util.LogD("VerifyResponse (Success): " + result.Msg)
-			var reqData *db.RequestData
-			reqData, err = db.ReadRequest(&vReq.PhoneNumber, &vReq.Bandeira)
-			if reqData == nil {
-				util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisNotFoundError, "Pedido inválido ou expirou", ""))
+			reqLog.D("VerifyResponse (Success): " + result.Msg)
+			metrics.Verifications.Inc(vReq.Bandeira, provider.ProviderName(), "success")
+			respData := db.NewResponseData(reqData.Key, reqData.IdPedidoEnvio, vReq.PhoneNumber, vReq.Bandeira, reqData.Sq, reqData.SmsId, vReq.ValidationCode, reqData.TimestampSend, "", provider.ProviderName())
+			var dataResult *db.ResponseData
+			dataResult, err = db.WriteResponse(&respData)
+			if (err == nil) && (dataResult.Key != "") {
+				db.DiscardRequestFields(&vReq.PhoneNumber, &vReq.Bandeira) //si e sq passaram a estar em rs, então descarta de rq
+				util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("Validado com sucesso", dataResult.SmsId))
 			} else {
-				respData := db.NewResponseData(reqData.Key, reqData.IdPedidoEnvio, vReq.PhoneNumber, vReq.Bandeira, reqData.Sq, reqData.SmsId, vReq.ValidationCode, reqData.TimestampSend, "")
-				var dataResult *db.ResponseData
-				dataResult, err = db.WriteResponse(&respData)
-				if (err == nil) && (dataResult.Key != "") {
-					db.DiscardRequestFields(&vReq.PhoneNumber, &vReq.Bandeira) //si e sq passaram a estar em rs, então descarta de rq
-					util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("Validado com sucesso", dataResult.SmsId))
-				} else {
-					util.LogD("VerifyResponse (NoSuccess): token não encontrado")
-					util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisWriteError, "Não foi possível gerar o token", err.Error()))
-				}
+				reqLog.D("VerifyResponse (NoSuccess): token não encontrado")
+				util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisWriteError, "Não foi possível gerar o token", err.Error()))
+			}
+		} else if result.Retryable {
+			//Falha de comunicação: não é código errado e a tentativa é devolvida
+			reqLog.D("VerifyResponse (NoSuccess): " + result.Msg)
+			metrics.Verifications.Inc(vReq.Bandeira, provider.ProviderName(), "error")
+			if err = db.RefundVerifyAttempt(&vReq.PhoneNumber, &vReq.Bandeira); err != nil {
+				reqLog.E("RefundVerifyAttempt: " + err.Error())
 			}
-		} else {
-			util.LogD("VerifyResponse (NoSuccess): " + result.Msg)
 			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponse(result))
+		} else if attempt.Attempts >= maxAttempts {
+			lockVerification(ctx, &vReq, provider.ProviderName())
+		} else {
+			reqLog.D("VerifyResponse (WrongCode): " + result.Msg)
+			metrics.Verifications.Inc(vReq.Bandeira, provider.ProviderName(), "wrong_code")
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_WRONG_CODE, result.Msg, strconv.Itoa(maxAttempts-attempt.Attempts)))
 		}
 	} else {
-		util.LogD("VerifyResponse (Error): " + err.Error())
+		requestLog(ctx).D("VerifyResponse (Error): " + err.Error())
 		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INVALID_JSON_READ, err.Error()))
 	}
 }
@@ -197,14 +384,15 @@ func changeProviderHandler(ctx *fasthttp.RequestCtx) {
 	authKeyParam := string(ctx.QueryArgs().Peek("key"))
 	if providerParam != "" {
 		if providerParam == "?" {
-			util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("Provider: " +defaultProvider, ""))
+			util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("Provider: "+currentProviderChain().String(), strings.Join(smsproviders.RegisteredProviders(), ",")))
 		} else if authKeyParam == util.AppCfg.ChangeProviderKey {
-			newProv := NewSmsProvider(providerParam)
-			if newProv != nil {
-				defaultProvider = newProv.ProviderName()
-				util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("Novo provider ativado: " +defaultProvider, ""))
+			//provider aceita um único nome ou a cadeia completa de failover. Ex: ?provider=Zenvia,Sinch
+			newChain, err := NewProviderChain(providerParam)
+			if err == nil {
+				setProviderChain(newChain)
+				util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("Novo provider ativado: "+newChain.String(), ""))
 			} else {
-				util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(-1, "Provider inválido", ""))
+				util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(-1, "Provider inválido", err.Error()))
 			}
 		} else {
 			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(-1, "Não autorizado", ""))
@@ -217,7 +405,8 @@ func changeProviderHandler(ctx *fasthttp.RequestCtx) {
 func findTempTokenHandler(ctx *fasthttp.RequestCtx) {
 	fReq, err := util.NewFindTokenRequestFromJson(ctx.Request.Body())
 	if err == nil {
-		util.LogD("FindToken: " + fReq.Token)
+		reqLog := requestLog(ctx)
+		reqLog.D("FindToken: " + fReq.Token)
 		phoneNumber, validationCode, err := db.FindTempToken(fReq.Token)
 		if err == nil {
 			if (phoneNumber == "") || (validationCode == "") {
@@ -237,17 +426,17 @@ func findTempTokenHandler(ctx *fasthttp.RequestCtx) {
 				}
 			}
 		} else {
-			util.LogD("findToken (NoSuccess): " + err.Error())
+			reqLog.D("findToken (NoSuccess): " + err.Error())
 			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisNotFoundError, "A leitura do token falhou.", err.Error()))
 		}
 	} else {
-		util.LogD("FindToken (Error): " + err.Error())
+		requestLog(ctx).D("FindToken (Error): " + err.Error())
 		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INVALID_JSON_READ, err.Error()))
 	}
 }
 
 func main() {
-	var f *os.File
+	var f *util.LogFile
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -259,6 +448,7 @@ func main() {
 	}()
 
 	f = util.InitLog(util.AppCfg.LogFileName)
+	go reopenLogOnHangup()
 	util.LogD("Using config: " + util.DefaultConfigPath + util.DefaultConfigFile)
 	util.LogD("Using log: " + util.AppCfg.LogFileName)
 
@@ -271,34 +461,67 @@ func main() {
 		log.Fatal("Conexão com o Redis falhou.")
 	}
 
+	if (len(os.Args) > 1) && (os.Args[1] == reportCommand) {
+		exitCode := runReportCommand(os.Args[2:])
+		f.Close()
+		os.Exit(exitCode)
+	}
+
+	startInstance()
+	backgroundWorkers.Add(2)
+	go runBatchDispatcher()
+	go runOutboxDispatcher()
+
 	util.LogD("---endpoints---")
 	fastHTTPRouter := router.New()
-	fastHTTPRouter.POST(requestEndpoint, requestVerificationHandler)
+	fastHTTPRouter.POST(requestEndpoint, instrumented(requestEndpoint, requestVerificationHandler))
 	util.LogD(requestEndpoint)
-	/*fastHTTPRouter.POST(requestSendSmsEndpoint, requestSmsHandler)
-	util.LogD(requestSendSmsEndpoint)*/
-	fastHTTPRouter.POST(verifyEndpoint, verifyHandler)
+	fastHTTPRouter.POST(RequestSendSmsEndpoint, instrumented(RequestSendSmsEndpoint, requestSmsHandler))
+	util.LogD(RequestSendSmsEndpoint)
+	fastHTTPRouter.POST(batchEndpoint, instrumented(batchEndpoint, requestBatchHandler))
+	util.LogD(batchEndpoint)
+	fastHTTPRouter.GET(batchStatusEndpoint, instrumented(batchStatusEndpoint, batchStatusHandler))
+	util.LogD(batchStatusEndpoint)
+	fastHTTPRouter.GET(batchResultsEndpoint, instrumented(batchResultsEndpoint, batchResultsHandler))
+	util.LogD(batchResultsEndpoint)
+	fastHTTPRouter.POST(verifyEndpoint, instrumented(verifyEndpoint, verifyHandler))
 	util.LogD(verifyEndpoint)
-	fastHTTPRouter.POST(findTokenEndpoint, findTempTokenHandler)
+	fastHTTPRouter.POST(deliveryReportEndpoint, instrumented(deliveryReportEndpoint, deliveryReportHandler))
+	util.LogD(deliveryReportEndpoint)
+	fastHTTPRouter.POST(findTokenEndpoint, instrumented(findTokenEndpoint, findTempTokenHandler))
 	util.LogD(findTokenEndpoint)
-	fastHTTPRouter.GET(changeProviderEndpoint, changeProviderHandler)
+	fastHTTPRouter.GET(changeProviderEndpoint, instrumented(changeProviderEndpoint, changeProviderHandler))
 	util.LogD(changeProviderEndpoint)
+	fastHTTPRouter.POST(messageStatusEndpoint, instrumented(messageStatusEndpoint, messageStatusHandler))
+	util.LogD(messageStatusEndpoint)
+	fastHTTPRouter.GET(fraudEndpoint, instrumented(fraudEndpoint, fraudHandler))
+	fastHTTPRouter.POST(fraudEndpoint, instrumented(fraudEndpoint, fraudHandler))
+	util.LogD(fraudEndpoint)
+	fastHTTPRouter.GET(billingEndpoint, instrumented(billingEndpoint, billingHandler))
+	util.LogD(billingEndpoint)
+	fastHTTPRouter.GET(healthEndpoint, instrumented(healthEndpoint, healthHandler))
+	util.LogD(healthEndpoint)
+	fastHTTPRouter.GET(readyEndpoint, instrumented(readyEndpoint, readyHandler))
+	util.LogD(readyEndpoint)
+	fastHTTPRouter.GET(metricsEndpoint, metricsHandler)
+	util.LogD(metricsEndpoint)
 	util.LogD("---endpoints---")
 	serverAddr := fmt.Sprint(":", util.AppCfg.NetworkOptions.ListeningPort)
 	util.LogD(serverAddr)
-	requestHandler := fasthttp.CompressHandlerLevel(fastHTTPRouter.Handler, fasthttp.CompressBestCompression)
-	errorHandlingRootRequest := fasthttp.ListenAndServe(serverAddr, requestHandler)
+	requestHandler := fasthttp.CompressHandlerLevel(withRequestLog(fastHTTPRouter.Handler), fasthttp.CompressBestCompression)
+	server := &fasthttp.Server{Handler: requestHandler}
+	errorHandlingRootRequest := serveUntilSignal(server, serverAddr)
 	if errorHandlingRootRequest != nil {
 		util.LogD(errorHandlingRootRequest.Error())
+		log.Fatal(errorHandlingRootRequest.Error())
 	}
 	util.LogD("End")
-	log.Fatal(errorHandlingRootRequest.Error())
 }
 
 func init() {
 	util.AppCfg = util.NewConfig(db.DefRedisConnectionString, db.DefRedisPoolSize, db.DefRedisDialTimeout,
 		util.DefaultHttpPort,
-		util.DefaultMaxSmsRequestsPerPhone, util.DefaultResendWaitSecondsAfterTriesLimitReached)
+		util.DefaultMaxSmsRequestsPerPhone, util.DefaultResendWaitSecondsAfterTriesLimitReached, defaultProviderChain)
 	util.LoadConfig(util.DefaultConfigPath + util.DefaultConfigFile, &util.AppCfg)
 
 	//util.DefaultMaxSmsRequestsPerPhone = util.AppCfg.SmsOptions.MaxSmsRequestsPerPhone
@@ -308,4 +531,16 @@ func init() {
 	util.SetLogOptions(util.AppCfg.LogOptions)
 	util.PrintConfig(util.AppCfg, false)
 	util.PrintConfig(util.AppCfg, true)
+
+	for _, err := range smsproviders.InitProviders(util.AppCfg.Providers) {
+		util.LogE("InitProviders: " + err.Error())
+	}
+	chain, err := NewProviderChain(util.AppCfg.SmsOptions.ProviderChain)
+	if err != nil {
+		util.LogE("ProviderChain: " + err.Error() + ". Usando os providers disponíveis")
+		chain = availableProviderChain(util.AppCfg.SmsOptions.ProviderChain)
+	}
+	util.LogI("ProviderChain: " + chain.String())
+	setProviderChain(chain)
+	initBandeiraChains()
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"gaudium.com.br/gaudiumsoftware/sms/metrics"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"time"
+)
+
+const (
+	sendKindVerification = "verification"
+	sendKindMessage      = "message"
+	sendKindBatch        = "batch"
+)
+
+// Lidos na coleta: o tamanho da outbox no Redis e o circuito de cada provider
+var (
+	outboxEventsGauge = metrics.NewGaugeFunc("sms_outbox_events",
+		"Eventos do histórico na outbox por estado (queued, retrying, dead)", "state", func() map[string]float64 {
+			queued, retrying, dead, err := db.OutboxLength()
+			if err != nil {
+				return nil
+			}
+			return map[string]float64{"queued": float64(queued), "retrying": float64(retrying), "dead": float64(dead)}
+		})
+
+	circuitOpenGauge = metrics.NewGaugeFunc("sms_provider_circuit_open",
+		"1 se o circuito do provider está aberto", "provider", func() map[string]float64 {
+			values := map[string]float64{}
+			for _, name := range smsproviders.RegisteredProviders() {
+				values[name] = 0
+				if smsproviders.CircuitState(name) == smsproviders.CircuitOpen {
+					values[name] = 1
+				}
+			}
+			return values
+		})
+)
+
+// instrumented registra a duração das requisições da rota (o padrão registrado no router, não o caminho recebido)
+func instrumented(route string, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		handler(ctx)
+		metrics.HttpRequestDuration.ObserveSince(start, route)
+	}
+}
+
+// countSend conta o envio ao provider pelo código do resultado. provider nil: nenhum provider disponível.
+func countSend(kind string, bandeira string, provider smsproviders.SmsProviderIntf, result smsproviders.SmsResult) {
+	providerName := ""
+	if provider != nil {
+		providerName = provider.ProviderName()
+	}
+	metrics.Sends.Inc(kind, bandeira, providerName, strconv.Itoa(result.Code))
+}
+
+// metricsHandler expõe as métricas no formato texto do Prometheus
+func metricsHandler(ctx *fasthttp.RequestCtx) {
+	var body bytes.Buffer
+	if err := metrics.WriteText(&body); err != nil {
+		util.LogE("metricsHandler: " + err.Error())
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType(metrics.ContentType)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(body.Bytes())
+}
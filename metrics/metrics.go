@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType é o tipo da resposta de /metrics (formato texto do Prometheus)
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}     //Segundos: HTTP e providers
+	RedisBuckets   = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1} //Segundos: comandos do Redis
+)
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var registryMutex sync.Mutex
+var registry []collector
+
+func register(c collector) {
+	registryMutex.Lock()
+	registry = append(registry, c)
+	registryMutex.Unlock()
+}
+
+// WriteText grava todas as métricas registradas no formato texto do Prometheus
+func WriteText(w io.Writer) error {
+	registryMutex.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMutex.Unlock()
+	writer := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(writer)
+	}
+	return writer.Flush()
+}
+
+// Result converte o erro de uma operação no valor do label result
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64 //Histograma: contagem por faixa (não cumulativa)
+	count       uint64
+}
+
+// vec guarda as séries de uma métrica pelos valores dos labels
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name string, help string, kind string, labels []string) vec {
+	return vec{name: name, help: help, kind: kind, labels: labels, series: map[string]*series{}}
+}
+
+// get supõe o mutex já adquirido. Valores de labels que faltam ficam vazios; os que sobram são ignorados.
+func (v *vec) get(labelValues []string, buckets int) *series {
+	values := make([]string, len(v.labels))
+	copy(values, labelValues)
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: values, buckets: make([]uint64, buckets)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted supõe o mutex já adquirido
+func (v *vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, v.series[key])
+	}
+	return result
+}
+
+func (v *vec) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + v.name + " " + escapeHelp(v.help) + "\n")
+	w.WriteString("# TYPE " + v.name + " " + v.kind + "\n")
+}
+
+func (v *vec) labelPairs(values []string, extra ...string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, v.labels[i]+"=\""+escapeLabel(value)+"\"")
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"=\""+escapeLabel(extra[i+1])+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec é um contador por valores dos labels
+type CounterVec struct {
+	vec
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels)}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mutex.Lock()
+	c.get(labelValues, 0).value += delta
+	c.mutex.Unlock()
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeHeader(w)
+	for _, s := range c.sorted() {
+		w.WriteString(c.name + c.labelPairs(s.labelValues) + " " + formatValue(s.value) + "\n")
+	}
+}
+
+// HistogramVec é um histograma por valores dos labels
+type HistogramVec struct {
+	vec
+	upperBounds []float64
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	upperBounds := append([]float64(nil), buckets...)
+	sort.Float64s(upperBounds)
+	h := &HistogramVec{newVec(name, help, "histogram", labels), upperBounds}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	bucket := sort.SearchFloat64s(h.upperBounds, value)
+	h.mutex.Lock()
+	s := h.get(labelValues, len(h.upperBounds))
+	if bucket < len(s.buckets) {
+		s.buckets[bucket]++
+	}
+	s.count++
+	s.value += value
+	h.mutex.Unlock()
+}
+
+// ObserveSince registra o tempo decorrido desde start, em segundos
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.writeHeader(w)
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, upperBound := range h.upperBounds {
+			cumulative += s.buckets[i]
+			w.WriteString(h.name + "_bucket" + h.labelPairs(s.labelValues, "le", formatValue(upperBound)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		w.WriteString(h.name + "_bucket" + h.labelPairs(s.labelValues, "le", "+Inf") + " " + strconv.FormatUint(s.count, 10) + "\n")
+		w.WriteString(h.name + "_sum" + h.labelPairs(s.labelValues) + " " + formatValue(s.value) + "\n")
+		w.WriteString(h.name + "_count" + h.labelPairs(s.labelValues) + " " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+}
+
+// GaugeFunc é um valor lido no momento da coleta: collect retorna o valor por valor do label
+type GaugeFunc struct {
+	vec
+	collect func() map[string]float64
+}
+
+func NewGaugeFunc(name string, help string, label string, collect func() map[string]float64) *GaugeFunc {
+	g := &GaugeFunc{newVec(name, help, "gauge", []string{label}), collect}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	values := g.collect()
+	labelValues := make([]string, 0, len(values))
+	for labelValue := range values {
+		labelValues = append(labelValues, labelValue)
+	}
+	sort.Strings(labelValues)
+	g.writeHeader(w)
+	for _, labelValue := range labelValues {
+		w.WriteString(g.name + g.labelPairs([]string{labelValue}) + " " + formatValue(values[labelValue]) + "\n")
+	}
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(value string) string {
+	return helpEscaper.Replace(value)
+}
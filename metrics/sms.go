@@ -0,0 +1,29 @@
+package metrics
+
+// Métricas do serviço. O tamanho da outbox é registrado no main (NewGaugeFunc), que lê o Redis na coleta.
+var (
+	HttpRequestDuration = NewHistogramVec("sms_http_request_duration_seconds",
+		"Duração das requisições HTTP por rota", DefaultBuckets, "route")
+
+	//kind: verification, message ou batch. code: código do resultado do provider (0: sucesso)
+	Sends = NewCounterVec("sms_send_total",
+		"SMS enviados aos providers por tipo, bandeira, provider e código do resultado", "kind", "bandeira", "provider", "code")
+
+	//reason: quota, rate_limit, fraud_block ou fraud_challenge
+	SendRejections = NewCounterVec("sms_send_rejected_total",
+		"Envios recusados antes do provider, por motivo e bandeira", "reason", "bandeira")
+
+	RateLimitRejections = NewCounterVec("sms_rate_limit_rejections_total",
+		"Envios recusados por limite de taxa, por regra", "rule")
+
+	//result: success, wrong_code, locked, not_found ou error (falha de comunicação com o provider)
+	Verifications = NewCounterVec("sms_verify_total",
+		"Verificações de código por bandeira, provider e resultado", "bandeira", "provider", "result")
+
+	//result: success, failure (recusa definitiva) ou retryable (falha transitória)
+	ProviderRequestDuration = NewHistogramVec("sms_provider_request_duration_seconds",
+		"Duração das chamadas à API dos providers", DefaultBuckets, "provider", "operation", "result")
+
+	RedisDuration = NewHistogramVec("sms_redis_duration_seconds",
+		"Duração das operações no Redis", RedisBuckets, "operation", "result")
+)
@@ -0,0 +1,114 @@
+package otp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"math/big"
+	"strings"
+)
+
+const (
+	OtpGenerateErrorCode    = 40 //Falha ao gerar ou gravar o código
+	OtpExpiredErrorCode     = 41 //Não há código pendente para o pedido
+	OtpInvalidCodeErrorCode = 42 //Código incorreto
+
+	saltSize = 16
+)
+
+// compareHash compara os hashes em tempo constante, sem revelar pelo tempo de resposta quantos caracteres conferem
+var compareHash = subtle.ConstantTimeCompare
+
+func GenerateCode(length int) (string, error) {
+	if length <= 0 {
+		length = util.DefaultOtpCodeLength
+	}
+	var code strings.Builder
+	for i := 0; i < length; i++ {
+		digit, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code.WriteString(digit.String())
+	}
+	return code.String(), nil
+}
+
+func NewSalt() (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
+
+func HashCode(code string, salt string) string {
+	sum := sha256.Sum256([]byte(salt + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches compara em tempo constante o código recebido com o hash armazenado
+func Matches(code string, salt string, hash string) bool {
+	return compareHash([]byte(HashCode(code, salt)), []byte(hash)) == 1
+}
+
+// IsLocal indica se o pedido enviado por provider deve usar o OTP local em vez da API de verificação do fornecedor
+func IsLocal(provider smsproviders.SmsProviderIntf) bool {
+	return !provider.HasVerificationApi() || util.AppCfg.OtpOptions.Enabled
+}
+
+// Send gera o código do pedido, grava o hash e envia o SMS em texto livre pelo provider, com o modelo e o
+// remetente da bandeira
+func Send(provider smsproviders.SmsProviderIntf, phoneNumber string, bandeira string, idPedidoEnvio string, hashCode string) smsproviders.SmsResult {
+	code, err := GenerateCode(util.AppCfg.OtpOptions.CodeLength)
+	if err != nil {
+		return *smsproviders.NewSmsResult(smsproviders.NoSuccess, OtpGenerateErrorCode, "Não foi possível gerar o código", err.Error())
+	}
+	salt, err := NewSalt()
+	if err != nil {
+		return *smsproviders.NewSmsResult(smsproviders.NoSuccess, OtpGenerateErrorCode, "Não foi possível gerar o código", err.Error())
+	}
+	ttl := util.AppCfg.OtpOptions.TtlSeconds
+	if ttl <= 0 {
+		ttl = util.DefaultOtpTtlSeconds
+	}
+	//O hash é gravado antes do envio para que o código já seja válido quando o SMS chegar
+	err = db.WriteOtp(&phoneNumber, &bandeira, idPedidoEnvio, HashCode(code, salt), salt, ttl)
+	if err != nil {
+		return *smsproviders.NewSmsResult(smsproviders.NoSuccess, OtpGenerateErrorCode, "Não foi possível gravar o código", err.Error())
+	}
+	bandeiraCfg := util.AppCfg.Bandeira(bandeira)
+	template := bandeiraCfg.OtpMessageTemplate
+	if template == "" {
+		template = util.AppCfg.OtpOptions.MessageTemplate
+	}
+	if template == "" {
+		template = util.DefaultOtpMessageTemplate
+	}
+	result := provider.SendMessageRequest(phoneNumber, strings.Replace(template, "{code}", code, -1), hashCode, bandeiraCfg.SenderId)
+	if !result.IsSuccess {
+		db.DiscardOtp(&phoneNumber, &bandeira)
+	}
+	return result
+}
+
+// Verify valida o código de um pedido enviado com OTP local. handled é false quando o pedido
+// não tem código local pendente e deve ser verificado pela API do provider.
+func Verify(phoneNumber string, bandeira string, idPedidoEnvio string, code string) (result smsproviders.SmsResult, handled bool) {
+	otpData, err := db.ReadOtp(&phoneNumber, &bandeira)
+	if err != nil {
+		return *smsproviders.NewRetryableSmsResult(db.RedisNotFoundError, "Não foi possível ler o código", err.Error()), true
+	}
+	if (otpData == nil) || (otpData.IdPedidoEnvio != idPedidoEnvio) {
+		return result, false
+	}
+	if !Matches(code, otpData.Salt, otpData.Hash) {
+		return *smsproviders.NewSmsResult(smsproviders.NoSuccess, OtpInvalidCodeErrorCode, "Código inválido", ""), true
+	}
+	db.DiscardOtp(&phoneNumber, &bandeira)
+	return *smsproviders.NewSmsResult(smsproviders.Success, smsproviders.SuccessCode, "Validado com sucesso", ""), true
+}
@@ -0,0 +1,149 @@
+package otp
+
+import (
+	"crypto/subtle"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"strings"
+	"testing"
+)
+
+// useMemoryRepository troca o armazenamento e a configuração pelos do teste e os restaura no fim
+func useMemoryRepository(t *testing.T) {
+	previousRepo := db.CurrentRepository()
+	previousCfg := util.AppCfg
+	t.Cleanup(func() {
+		db.SetRepository(previousRepo)
+		util.AppCfg = previousCfg
+	})
+	db.SetRepository(db.NewMemoryRepository())
+	util.AppCfg = util.Config{}
+}
+
+// writeCode grava o código do pedido como Send, válido por ttlSeconds
+func writeCode(t *testing.T, phoneNumber string, bandeira string, idPedidoEnvio string, code string, ttlSeconds int) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.WriteOtp(&phoneNumber, &bandeira, idPedidoEnvio, HashCode(code, salt), salt, ttlSeconds); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeProvider guarda o último SMS enviado e responde com result
+type fakeProvider struct {
+	smsproviders.SmsProviderIntf
+	result   smsproviders.SmsResult
+	content  string
+	senderId string
+}
+
+func (p *fakeProvider) SendMessageRequest(phoneNumber string, content string, hashCode string, senderId string) smsproviders.SmsResult {
+	p.content = content
+	p.senderId = senderId
+	return p.result
+}
+
+func TestGenerateCode(t *testing.T) {
+	for _, length := range []int{4, 6, 8} {
+		code, err := GenerateCode(length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(code) != length) || (strings.Trim(code, "0123456789") != "") {
+			t.Errorf("GenerateCode(%d) = %q, esperado %d dígitos", length, code, length)
+		}
+	}
+	if code, _ := GenerateCode(0); len(code) != util.DefaultOtpCodeLength {
+		t.Errorf("GenerateCode(0) = %q, esperado %d dígitos", code, util.DefaultOtpCodeLength)
+	}
+}
+
+func TestHashCodeMatches(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSalt, _ := NewSalt()
+	if salt == otherSalt {
+		t.Errorf("NewSalt repetiu %q", salt)
+	}
+	hash := HashCode("123456", salt)
+	if hash == HashCode("123456", otherSalt) {
+		t.Error("HashCode com salts diferentes gerou o mesmo hash")
+	}
+	if !Matches("123456", salt, hash) {
+		t.Error("Matches com o código certo = false")
+	}
+	if Matches("123457", salt, hash) || Matches("123456", otherSalt, hash) {
+		t.Error("Matches com código ou salt errado = true")
+	}
+}
+
+func TestSend(t *testing.T) {
+	useMemoryRepository(t)
+	util.AppCfg.Bandeiras = map[string]util.BandeiraOptions{"1": {SenderId: "Gaudium", OtpMessageTemplate: "Código {code}"}}
+	phoneNumber, bandeira := "+5511999990000", "1"
+	provider := &fakeProvider{result: *smsproviders.NewSmsResult(smsproviders.Success, smsproviders.SuccessCode, "", nil)}
+
+	if result := Send(provider, phoneNumber, bandeira, "7", ""); !result.IsSuccess {
+		t.Fatalf("Send = %+v, esperado sucesso", result)
+	}
+	if provider.senderId != "Gaudium" {
+		t.Errorf("remetente %q, esperado o da bandeira", provider.senderId)
+	}
+	code := strings.TrimPrefix(provider.content, "Código ")
+	if (code == provider.content) || (len(code) != util.DefaultOtpCodeLength) {
+		t.Fatalf("SMS %q fora do modelo da bandeira", provider.content)
+	}
+	if result, handled := Verify(phoneNumber, bandeira, "7", code); !handled || !result.IsSuccess {
+		t.Errorf("Verify com o código enviado = %+v, %v; esperado sucesso", result, handled)
+	}
+
+	//Se o envio falha, o código gravado é descartado
+	provider.result = *smsproviders.NewRetryableSmsResult(500, "Falha", nil)
+	if result := Send(provider, phoneNumber, bandeira, "8", ""); result.IsSuccess {
+		t.Fatalf("Send = %+v, esperado falha", result)
+	}
+	code = strings.TrimPrefix(provider.content, "Código ")
+	if _, handled := Verify(phoneNumber, bandeira, "8", code); handled {
+		t.Error("Verify depois de um envio com falha tratou o código, esperado descartado")
+	}
+}
+
+func TestVerifyConstantTimeCompare(t *testing.T) {
+	useMemoryRepository(t)
+	compares := 0
+	t.Cleanup(func() { compareHash = subtle.ConstantTimeCompare })
+	compareHash = func(x, y []byte) int {
+		compares++
+		return subtle.ConstantTimeCompare(x, y)
+	}
+	phoneNumber, bandeira := "+5511999990000", "1"
+	writeCode(t, phoneNumber, bandeira, "7", "123456", 300)
+
+	result, handled := Verify(phoneNumber, bandeira, "7", "123457")
+	if !handled || result.IsSuccess || (result.Code != OtpInvalidCodeErrorCode) {
+		t.Errorf("Verify com código errado = %+v, %v; esperado código %d", result, handled, OtpInvalidCodeErrorCode)
+	}
+	result, handled = Verify(phoneNumber, bandeira, "7", "123456")
+	if !handled || (result.IsSuccess != smsproviders.Success) {
+		t.Errorf("Verify com código certo = %+v, %v; esperado sucesso", result, handled)
+	}
+	if compares != 2 {
+		t.Errorf("%d comparações em tempo constante, esperado 2", compares)
+	}
+}
+
+func TestVerifyExpiredCode(t *testing.T) {
+	useMemoryRepository(t)
+	phoneNumber, bandeira := "+5511999990000", "1"
+	writeCode(t, phoneNumber, bandeira, "7", "123456", 0)
+
+	//Sem código local pendente, a verificação fica com o provider
+	if result, handled := Verify(phoneNumber, bandeira, "7", "123456"); handled || result.IsSuccess {
+		t.Errorf("Verify com código expirado = %+v, %v; esperado não tratado", result, handled)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"sync"
+	"time"
+)
+
+const (
+	outboxPopTimeoutSeconds   = 5
+	outboxDrainTimeoutSeconds = 1 //No encerramento: espera por eventos que as últimas requisições ainda gravam
+	outboxPromoteInterval     = time.Second
+)
+
+// runOutboxDispatcher entrega os eventos do histórico ao logmachine até o encerramento do serviço. Na partida devolve
+// à fila os registros que falharam antes da outbox existir (sms:logrq:, sms:logrs:).
+func runOutboxDispatcher() {
+	defer backgroundWorkers.Done()
+	if drained, err := db.DrainFailedLogs(); err != nil {
+		util.LogE("runOutboxDispatcher.drain: " + err.Error())
+	} else if drained > 0 {
+		util.LogI(fmt.Sprintf("runOutboxDispatcher: %d registros antigos do logmachine reenfileirados", drained))
+	}
+
+	workers := util.AppCfg.OutboxOptions.Workers
+	if workers <= 0 {
+		workers = util.DefaultOutboxWorkers
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runOutboxWorker()
+		}()
+	}
+	for {
+		if _, err := db.PromoteOutboxRetries(); err != nil {
+			util.LogE("runOutboxDispatcher.promote: " + err.Error())
+		}
+		if !sleepUnlessStopping(outboxPromoteInterval) {
+			break
+		}
+	}
+	wg.Wait()
+}
+
+// runOutboxWorker entrega os eventos da fila. No encerramento continua até a fila esvaziar; as novas tentativas
+// agendadas ficam para a próxima partida.
+func runOutboxWorker() {
+	for {
+		timeout := outboxPopTimeoutSeconds
+		if isStopping() {
+			timeout = outboxDrainTimeoutSeconds
+		}
+		event, err := db.PopOutboxEvent(timeout)
+		if err != nil {
+			util.LogE("runOutboxWorker: " + err.Error())
+			if !sleepUnlessStopping(outboxPopTimeoutSeconds * time.Second) {
+				return
+			}
+			continue
+		}
+		if event != "" {
+			db.ProcessOutboxEvent(event)
+		} else if isStopping() {
+			return
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package phone
+
+import (
+	"errors"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"strings"
+)
+
+const (
+	InvalidPhoneErrorCode      = 50 //Telefone inválido ou que não recebe SMS
+	CountryNotAllowedErrorCode = 51 //País fora de AllowedCountries da bandeira
+)
+
+var ErrInvalid = errors.New("Número de telefone inválido")
+var ErrNotMobile = errors.New("O número informado não é de celular")
+var ErrCountryNotAllowed = errors.New("A bandeira não envia SMS para o país do número")
+
+// country descreve o plano de numeração do país: tamanhos válidos do número nacional (sem o código do país)
+// e, quando dá para saber pelo número, se ele é de celular
+type country struct {
+	nationalLengths []int
+	isMobile        func(national string) bool
+}
+
+func startsWithAny(prefixes ...string) func(string) bool {
+	return func(national string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(national, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// countries são os planos de numeração conhecidos. Números de outros países (countryCodes) são aceitos apenas pelo
+// tamanho do E.164.
+var countries = map[string]country{
+	"1":   {[]int{10}, nil}, //EUA/Canadá: celular e fixo não se distinguem pelo número
+	"34":  {[]int{9}, startsWithAny("6", "7")},
+	"51":  {[]int{9}, startsWithAny("9")},
+	"52":  {[]int{10}, nil},
+	"54":  {[]int{11}, startsWithAny("9")}, //Argentina: celular em formato internacional tem o 9 antes do código de área
+	"55":  {[]int{10, 11}, isBrazilianMobile},
+	"56":  {[]int{9}, startsWithAny("9")},
+	"57":  {[]int{10}, startsWithAny("3")},
+	"351": {[]int{9}, startsWithAny("9")},
+	"591": {[]int{8}, startsWithAny("6", "7")},
+	"595": {[]int{9}, startsWithAny("9")},
+	"598": {[]int{8}, startsWithAny("9")},
+}
+
+// countryCodes são os códigos de país do E.164 (UIT), inclusive as redes internacionais 870 e 881 a 883.
+// Nenhum código é prefixo de outro, então o número internacional tem um único código possível.
+var countryCodes = toSet(strings.Fields(`
+	1 7 20 27 30 31 32 33 34 36 39 40 41 43 44 45 46 47 48 49 51 52 53 54 55 56 57 58 60 61 62 63 64 65 66
+	81 82 84 86 90 91 92 93 94 95 98
+	211 212 213 216 218 220 221 222 223 224 225 226 227 228 229 230 231 232 233 234 235 236 237 238 239
+	240 241 242 243 244 245 246 247 248 249 250 251 252 253 254 255 256 257 258 260 261 262 263 264 265 266
+	267 268 269 290 291 297 298 299 350 351 352 353 354 355 356 357 358 359 370 371 372 373 374 375 376 377
+	378 380 381 382 383 385 386 387 389 420 421 423 500 501 502 503 504 505 506 507 508 509 590 591 592 593
+	594 595 596 597 598 599 670 672 673 674 675 676 677 678 679 680 681 682 683 685 686 687 688 689 690 691
+	692 850 852 853 855 856 870 880 881 882 883 886 960 961 962 963 964 965 966 967 968 970 971 972 973 974
+	975 976 977 992 993 994 995 996 998`))
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// isBrazilianMobile: DDD válido (11 a 99, sem zero) seguido de 9 e mais 8 dígitos
+func isBrazilianMobile(national string) bool {
+	return (len(national) == 11) && (national[0] != '0') && (national[1] != '0') && (national[2] == '9')
+}
+
+// Number é o telefone normalizado
+type Number struct {
+	E164        string //Ex: +5511999990000
+	CountryCode string //Ex: 55
+	National    string //Ex: 11999990000
+}
+
+func digitsOf(raw string) string {
+	var digits strings.Builder
+	for _, c := range raw {
+		if (c >= '0') && (c <= '9') {
+			digits.WriteRune(c)
+		}
+	}
+	return digits.String()
+}
+
+func hasLength(national string, lengths []int) bool {
+	for _, length := range lengths {
+		if len(national) == length {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCountryCode separa o código do país de um número internacional (códigos têm de 1 a 3 dígitos).
+// Retorna o código vazio se o número não começa com um código de país.
+func splitCountryCode(digits string) (string, string) {
+	for length := 1; length <= 3; length++ {
+		if len(digits) <= length {
+			break
+		}
+		if countryCodes[digits[:length]] {
+			return digits[:length], digits[length:]
+		}
+	}
+	return "", digits
+}
+
+// Parse normaliza o telefone para E.164 e valida se é um celular. Aceita "+55 (11) 99999-0000", "5511999990000",
+// "00 55 11 ...", "011 99999-0000" e "(11) 99999-0000"; sem código do país, usa defaultCountryCode.
+func Parse(raw string, defaultCountryCode string) (Number, error) {
+	raw = strings.TrimSpace(raw)
+	digits := digitsOf(raw)
+	if digits == "" {
+		return Number{}, ErrInvalid
+	}
+	international := strings.HasPrefix(raw, "+")
+	if !international && strings.HasPrefix(digits, "00") {
+		digits = digits[2:]
+		international = true
+	}
+
+	var countryCode, national string
+	if international {
+		if countryCode, national = splitCountryCode(digits); countryCode == "" {
+			return Number{}, ErrInvalid
+		}
+	} else {
+		defaultCountry, known := countries[defaultCountryCode]
+		national = strings.TrimLeft(digits, "0") //Prefixo de discagem interurbana
+		if known && strings.HasPrefix(digits, defaultCountryCode) && hasLength(digits[len(defaultCountryCode):], defaultCountry.nationalLengths) &&
+			!hasLength(national, defaultCountry.nationalLengths) {
+			//Já veio com o código do país, sem o "+"
+			national = digits[len(defaultCountryCode):]
+		} else if known && (defaultCountryCode == "55") && (len(national) == 13) {
+			//Interurbano com código de operadora: 0 + operadora + DDD + número
+			national = national[2:]
+		}
+		countryCode = defaultCountryCode
+	}
+
+	e164 := countryCode + national
+	if (len(e164) < 8) || (len(e164) > 15) {
+		return Number{}, ErrInvalid
+	}
+	if plan, known := countries[countryCode]; known {
+		if !hasLength(national, plan.nationalLengths) {
+			return Number{}, ErrInvalid
+		}
+		if (plan.isMobile != nil) && !plan.isMobile(national) {
+			return Number{}, ErrNotMobile
+		}
+	}
+	return Number{E164: "+" + e164, CountryCode: countryCode, National: national}, nil
+}
+
+// CountryOf retorna o código do país de um telefone em E.164, ou vazio se ele não começa com um código de país
+func CountryOf(e164 string) string {
+	countryCode, _ := splitCountryCode(digitsOf(e164))
+	return countryCode
+}
+
+// Normalize retorna o telefone em E.164 (ver Parse)
+func Normalize(raw string, defaultCountryCode string) (string, error) {
+	number, err := Parse(raw, defaultCountryCode)
+	return number.E164, err
+}
+
+// NormalizeFor retorna o telefone em E.164 completado com o país da bandeira. Recusa os países que a bandeira não atende.
+func NormalizeFor(raw string, bandeira string) (string, error) {
+	number, err := Parse(raw, DefaultCountryFor(bandeira))
+	if err != nil {
+		return "", err
+	}
+	if !util.AppCfg.Bandeira(bandeira).AllowsCountry(number.CountryCode) {
+		return "", ErrCountryNotAllowed
+	}
+	return number.E164, nil
+}
+
+// ErrorCode é o código de erro da resposta para uma falha de Parse/NormalizeFor
+func ErrorCode(err error) int {
+	if err == ErrCountryNotAllowed {
+		return CountryNotAllowedErrorCode
+	}
+	return InvalidPhoneErrorCode
+}
+
+// DefaultCountryFor retorna o código do país usado para os telefones da bandeira informados sem ele
+func DefaultCountryFor(bandeira string) string {
+	if countryCode := util.AppCfg.Bandeira(bandeira).DefaultCountryCode; countryCode != "" {
+		return countryCode
+	}
+	if util.AppCfg.PhoneOptions.DefaultCountryCode != "" {
+		return util.AppCfg.PhoneOptions.DefaultCountryCode
+	}
+	return util.DefaultCountryCode
+}
@@ -0,0 +1,93 @@
+package phone
+
+import (
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw                string
+		defaultCountryCode string
+		expected           Number
+	}{
+		{"+55 (11) 99999-0000", "55", Number{"+5511999990000", "55", "11999990000"}},
+		{"5511999990000", "55", Number{"+5511999990000", "55", "11999990000"}},
+		{"00 55 11 99999-0000", "351", Number{"+5511999990000", "55", "11999990000"}},
+		{"011 99999-0000", "55", Number{"+5511999990000", "55", "11999990000"}},
+		{"(11) 99999-0000", "55", Number{"+5511999990000", "55", "11999990000"}},
+		{"0 21 11 99999-0000", "55", Number{"+5511999990000", "55", "11999990000"}}, //Código de operadora
+		{"912 345 678", "351", Number{"+351912345678", "351", "912345678"}},
+		{"+351 912 345 678", "55", Number{"+351912345678", "351", "912345678"}},
+		{"+1 202 555 0100", "55", Number{"+12025550100", "1", "2025550100"}},
+		{"+447911123456", "55", Number{"+447911123456", "44", "7911123456"}}, //Sem plano conhecido: só o tamanho
+		{"+7 912 345 6789", "55", Number{"+79123456789", "7", "9123456789"}},
+		{"+299 123456", "55", Number{"+299123456", "299", "123456"}},
+	}
+	for _, test := range tests {
+		number, err := Parse(test.raw, test.defaultCountryCode)
+		if err != nil {
+			t.Errorf("Parse(%q, %q): %v", test.raw, test.defaultCountryCode, err)
+			continue
+		}
+		if number != test.expected {
+			t.Errorf("Parse(%q, %q) = %+v, esperado %+v", test.raw, test.defaultCountryCode, number, test.expected)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected error
+	}{
+		{"", ErrInvalid},
+		{"abc", ErrInvalid},
+		{"+55 11 9999", ErrInvalid},        //Curto para o Brasil
+		{"+351 912 345 6789", ErrInvalid},  //Longo para Portugal
+		{"+2801234567", ErrInvalid},        //280 não é código de país
+		{"+1234567890123456", ErrInvalid},  //Mais de 15 dígitos
+		{"(11) 3333-4444", ErrNotMobile},   //Fixo
+		{"+55 11 8999 0000", ErrNotMobile}, //Sem o 9
+		{"+351 212 345 678", ErrNotMobile}, //Fixo em Portugal
+		{"+54 11 1234 5678", ErrInvalid},   //Argentina sem o 9: tamanho errado
+	}
+	for _, test := range tests {
+		if _, err := Parse(test.raw, "55"); err != test.expected {
+			t.Errorf("Parse(%q) = %v, esperado %v", test.raw, err, test.expected)
+		}
+	}
+}
+
+func TestCountryOf(t *testing.T) {
+	for e164, expected := range map[string]string{"+5511999990000": "55", "+351912345678": "351", "+12025550100": "1", "+2801234567": ""} {
+		if countryCode := CountryOf(e164); countryCode != expected {
+			t.Errorf("CountryOf(%q) = %q, esperado %q", e164, countryCode, expected)
+		}
+	}
+}
+
+func TestNormalizeFor(t *testing.T) {
+	previousCfg := util.AppCfg
+	defer func() { util.AppCfg = previousCfg }()
+	util.AppCfg = util.Config{Bandeiras: map[string]util.BandeiraOptions{
+		"12": {DefaultCountryCode: "351", AllowedCountries: "351, 55"},
+	}}
+
+	if e164, err := NormalizeFor("912 345 678", "12"); (err != nil) || (e164 != "+351912345678") {
+		t.Errorf("NormalizeFor com o país da bandeira = %q, %v", e164, err)
+	}
+	if e164, err := NormalizeFor("(11) 99999-0000", "1"); (err != nil) || (e164 != "+5511999990000") {
+		t.Errorf("NormalizeFor com o país padrão = %q, %v", e164, err)
+	}
+	_, err := NormalizeFor("+1 202 555 0100", "12")
+	if err != ErrCountryNotAllowed {
+		t.Errorf("NormalizeFor fora de AllowedCountries = %v, esperado %v", err, ErrCountryNotAllowed)
+	}
+	if code := ErrorCode(err); code != CountryNotAllowedErrorCode {
+		t.Errorf("ErrorCode(%v) = %d, esperado %d", err, code, CountryNotAllowedErrorCode)
+	}
+	if code := ErrorCode(ErrNotMobile); code != InvalidPhoneErrorCode {
+		t.Errorf("ErrorCode(%v) = %d, esperado %d", ErrNotMobile, code, InvalidPhoneErrorCode)
+	}
+}
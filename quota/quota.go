@@ -0,0 +1,104 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"time"
+)
+
+const (
+	QuotaExceededErrorCode = 100 //Cota mensal da bandeira esgotada
+
+	LevelWarn  = "warn"
+	LevelBlock = "block"
+
+	alertTimeout = 5 * time.Second
+)
+
+// Limits retorna o aviso (soft) e a cota (hard) mensais da bandeira. hard 0: sem cota.
+func Limits(bandeira string) (soft int, hard int) {
+	cfg := util.AppCfg.Bandeira(bandeira)
+	hard = cfg.MonthlyQuota
+	if hard <= 0 {
+		return 0, 0
+	}
+	soft = cfg.MonthlyQuotaWarn
+	if soft <= 0 {
+		warnPercent := util.AppCfg.QuotaOptions.WarnPercent
+		if warnPercent <= 0 {
+			warnPercent = util.DefaultQuotaWarnPercent
+		}
+		soft = hard * warnPercent / 100
+	}
+	return soft, hard
+}
+
+// Exceeded indica se a bandeira já usou a cota do mês. Falhas de leitura não bloqueiam o envio.
+// Envios simultâneos podem ultrapassar a cota em poucos SMS: a contagem só acontece depois do envio.
+func Exceeded(bandeira string) (bool, int64, int) {
+	_, hard := Limits(bandeira)
+	if hard <= 0 {
+		return false, 0, 0
+	}
+	used, err := db.MonthlyUsage(bandeira)
+	if err != nil {
+		util.LogE("quota.Exceeded: " + err.Error())
+		return false, 0, hard
+	}
+	return used >= int64(hard), used, hard
+}
+
+// Account conta o SMS enviado na cobrança da bandeira (db.AccountSMS) e emite o alerta quando o envio atinge o
+// aviso ou a cota. O contador é incrementado atomicamente, então cada limite alerta uma única vez no mês.
+func Account(bandeira string, provider string, phoneNumber string, segments int) string {
+	sq := db.AccountSMS(bandeira, provider, phoneNumber, segments)
+	used, err := strconv.ParseInt(sq, 10, 64)
+	if err != nil {
+		return sq
+	}
+	soft, hard := Limits(bandeira)
+	switch {
+	case hard <= 0:
+	case used == int64(hard):
+		go emitAlert(util.TQuotaAlert{Bandeira: bandeira, Level: LevelBlock, Used: used, Limit: hard, Quota: hard})
+	case (soft > 0) && (soft < hard) && (used == int64(soft)):
+		go emitAlert(util.TQuotaAlert{Bandeira: bandeira, Level: LevelWarn, Used: used, Limit: soft, Quota: hard})
+	}
+	return sq
+}
+
+// emitAlert registra o alerta no log e, se configurado, envia para Quota.AlertUrl
+func emitAlert(alert util.TQuotaAlert) {
+	now := time.Now()
+	alert.Month = now.Format("2006-01")
+	alert.Timestamp = now.Format(time.RFC3339)
+	util.LogW(fmt.Sprintf("quota: bandeira %s atingiu %d de %d SMS no mês (%s)", alert.Bandeira, alert.Used, alert.Quota, alert.Level))
+
+	alertUrl := util.AppCfg.QuotaOptions.AlertUrl
+	if alertUrl == "" {
+		return
+	}
+	bts, err := json.Marshal(alert)
+	if err != nil {
+		util.LogE("quota.emitAlert: " + err.Error())
+		return
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(alertUrl)
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType("application/json")
+	req.SetBody(bts)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	client := &fasthttp.Client{}
+	if err = client.DoTimeout(req, resp, alertTimeout); err != nil {
+		util.LogE("quota.emitAlert: " + err.Error())
+	} else if resp.StatusCode() >= fasthttp.StatusBadRequest {
+		util.LogE("quota.emitAlert: " + alertUrl + " respondeu " + strconv.Itoa(resp.StatusCode()))
+	}
+}
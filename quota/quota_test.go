@@ -0,0 +1,94 @@
+package quota
+
+import (
+	"encoding/json"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useQuota troca o armazenamento e a configuração pelos do teste, com a cota da bandeira "1", e os restaura no fim
+func useQuota(t *testing.T, quota int, warn int) {
+	previousRepo := db.CurrentRepository()
+	previousCfg := util.AppCfg
+	t.Cleanup(func() {
+		db.SetRepository(previousRepo)
+		util.AppCfg = previousCfg
+	})
+	db.SetRepository(db.NewMemoryRepository())
+	util.AppCfg = util.Config{Bandeiras: map[string]util.BandeiraOptions{"1": {MonthlyQuota: quota, MonthlyQuotaWarn: warn}}}
+}
+
+// alertServer recebe os alertas enviados para Quota.AlertUrl
+func alertServer(t *testing.T) chan util.TQuotaAlert {
+	alerts := make(chan util.TQuotaAlert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert util.TQuotaAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		alerts <- alert
+	}))
+	t.Cleanup(server.Close)
+	util.AppCfg.QuotaOptions.AlertUrl = server.URL
+	return alerts
+}
+
+func TestLimits(t *testing.T) {
+	cases := []struct {
+		quota, warn, warnPercent int
+		soft, hard               int
+	}{
+		{0, 50, 0, 0, 0},     //Sem cota, sem aviso
+		{100, 0, 0, 80, 100}, //Percentual padrão
+		{100, 0, 50, 50, 100},
+		{100, 90, 50, 90, 100}, //O aviso da bandeira tem prioridade
+	}
+	for _, c := range cases {
+		useQuota(t, c.quota, c.warn)
+		util.AppCfg.QuotaOptions.WarnPercent = c.warnPercent
+		if soft, hard := Limits("1"); (soft != c.soft) || (hard != c.hard) {
+			t.Errorf("Limits com cota %d, aviso %d e %d%% = %d, %d; esperado %d, %d", c.quota, c.warn, c.warnPercent, soft, hard, c.soft, c.hard)
+		}
+	}
+}
+
+func TestExceeded(t *testing.T) {
+	useQuota(t, 2, 0)
+	for i := 0; i < 2; i++ {
+		if exceeded, used, _ := Exceeded("1"); exceeded {
+			t.Fatalf("Exceeded com %d SMS = true, esperado false", used)
+		}
+		db.AccountSMS("1", "zenvia", "+5511999990000", 1)
+	}
+	if exceeded, used, hard := Exceeded("1"); !exceeded || (used != 2) || (hard != 2) {
+		t.Errorf("Exceeded = %v, %d, %d; esperado true, 2, 2", exceeded, used, hard)
+	}
+	if exceeded, _, _ := Exceeded("2"); exceeded {
+		t.Error("Exceeded na bandeira sem cota = true")
+	}
+}
+
+func TestAccountAlerts(t *testing.T) {
+	useQuota(t, 3, 2)
+	alerts := alertServer(t)
+
+	expected := map[int]string{2: LevelWarn, 3: LevelBlock}
+	for used := 1; used <= 4; used++ {
+		Account("1", "zenvia", "+5511999990000", 1)
+		level, alerted := expected[used]
+		select {
+		case alert := <-alerts:
+			if !alerted || (alert.Level != level) || (alert.Used != int64(used)) || (alert.Bandeira != "1") || (alert.Quota != 3) {
+				t.Errorf("alerta no %dº SMS = %+v, esperado nível %q", used, alert, level)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if alerted {
+				t.Errorf("sem alerta no %dº SMS, esperado %q", used, level)
+			}
+		}
+	}
+}
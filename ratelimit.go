@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/metrics"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"strings"
+)
+
+const (
+	CD_RATE_LIMITED = 80
+)
+
+// clientIp retorna o IP de quem chamou o serviço. Atrás do load balancer, o primeiro endereço do X-Forwarded-For.
+func clientIp(ctx *fasthttp.RequestCtx) string {
+	if util.AppCfg.RateLimitOptions.TrustForwardedFor {
+		forwardedFor := string(ctx.Request.Header.Peek(fasthttp.HeaderXForwardedFor))
+		if ip := strings.TrimSpace(strings.Split(forwardedFor, ",")[0]); ip != "" {
+			return ip
+		}
+	}
+	return ctx.RemoteIP().String()
+}
+
+// checkSendRateLimits aplica os limites de RateLimitOptions antes de chamar o provider e retorna a reserva do envio
+// nas janelas, a ser liberada se o envio não acontecer. Se algum limite estiver esgotado, responde com
+// CD_RATE_LIMITED e os segundos de espera em Data e retorna false.
+func checkSendRateLimits(ctx *fasthttp.RequestCtx, bandeira string, phoneNumber string) (*db.RateLimitReservation, bool) {
+	rule, waitSeconds, reservation := sendRateLimited(requestLog(ctx).WithBandeira(bandeira), clientIp(ctx), bandeira, phoneNumber)
+	if rule == nil {
+		return reservation, true
+	}
+	msg := "Limite de envios atingido. Tente novamente mais tarde"
+	util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(CD_RATE_LIMITED, msg, strconv.Itoa(waitSeconds)))
+	return nil, false
+}
+
+// sendRateLimited registra o envio nas janelas de RateLimitOptions. Retorna a regra esgotada e os segundos até ela
+// liberar, ou nil e a reserva do envio (ver db.RateLimitReservation) se ele pode seguir.
+func sendRateLimited(reqLog util.Logger, ip string, bandeira string, phoneNumber string) (*db.RateLimitRule, int, *db.RateLimitReservation) {
+	reached, waitSeconds, err := db.SpendCeilingReached()
+	if err != nil {
+		reqLog.E("sendRateLimited: " + err.Error())
+	}
+	var rule *db.RateLimitRule
+	var reservation *db.RateLimitReservation
+	if reached {
+		rule = &db.SpendRateLimitRule
+	} else {
+		rule, waitSeconds, reservation, err = db.CheckRateLimits(db.SendRateLimitRules(ip, bandeira, phoneNumber))
+	}
+	if err != nil {
+		//Falha no armazenamento não deve derrubar os envios: o limite por telefone continua valendo
+		reqLog.E("sendRateLimited: " + err.Error())
+		return nil, 0, nil
+	}
+	if rule == nil {
+		return nil, 0, reservation
+	}
+	metrics.SendRejections.Inc("rate_limit", bandeira)
+	metrics.RateLimitRejections.Inc(rule.Name)
+	reqLog.W(fmt.Sprintf("sendRateLimited: limite %s atingido (%s) ip: %s bd: %s pn: %s", rule.Name, rule.Key, ip, bandeira, util.MaskPhone(phoneNumber)))
+	return rule, waitSeconds, nil
+}
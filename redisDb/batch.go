@@ -0,0 +1,82 @@
+package redisDb
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	BatchQueued  = "queued"
+	BatchRunning = "running"
+	BatchDone    = "done"
+
+	batchQueueKey = "sms:jobs"
+)
+
+var batchJobTTL int64 = 30 * 24 * 60 * 60 //30 dias - Tempo que o job e os resultados ficam disponíveis para consulta
+
+type BatchJob struct {
+	Id       string
+	Bandeira string
+	Template string
+	ClientIp string //IP de quem criou o job, para os limites por IP e o antifraude
+	Status   string
+	Total    int
+	Sent     int
+	Failed   int
+	Created  string
+	Finished string
+}
+
+func getBatchJobKey(id string) string {
+	return "sms:job:" + id
+}
+
+func getBatchRecipientsKey(id string) string {
+	return "sms:job:" + id + ":rcpt"
+}
+
+func getBatchResultsKey(id string) string {
+	return "sms:job:" + id + ":res"
+}
+
+// CreateBatchJob grava o job com seus destinatários (já serializados) e o coloca na fila
+func CreateBatchJob(bandeira string, template string, clientIp string, recipients []string) (string, error) {
+	id, err := NextSQKey("sms:sq:job")
+	if err != nil {
+		return "", err
+	}
+	job := BatchJob{Id: id, Bandeira: bandeira, Template: template, ClientIp: clientIp, Status: BatchQueued, Total: len(recipients), Created: time.Now().Format(time.RFC3339)}
+	return id, repo.CreateBatchJob(job, recipients, batchJobTTL)
+}
+
+// PopBatchJob aguarda até timeoutSeconds pelo próximo job da fila. Retorna "" se não houver job.
+// O job fica na lista em execução da instância até FinishBatchJob (ver RequeueOrphanedWork).
+func PopBatchJob(timeoutSeconds int) (string, error) {
+	return repo.PopBatchJob(timeoutSeconds)
+}
+
+func FinishBatchJob(id string) error {
+	return repo.FinishBatchJob(id, time.Now().Format(time.RFC3339))
+}
+
+// ReadBatchJob retorna nil se o job não existe ou expirou
+func ReadBatchJob(id string) (*BatchJob, error) {
+	return repo.ReadBatchJob(id)
+}
+
+func ReadBatchRecipients(id string) ([]string, error) {
+	return repo.ReadBatchRecipients(id)
+}
+
+// WriteBatchResult grava o resultado do destinatário na posição index da lista do job. Destinatários que já têm
+// resultado não são recontados. A posição, e não o telefone, identifica o destinatário: o mesmo número pode
+// aparecer mais de uma vez com Params diferentes.
+func WriteBatchResult(id string, index int, result string, success bool) error {
+	return repo.SaveBatchResult(id, strconv.Itoa(index), result, success, batchJobTTL)
+}
+
+// ReadBatchResults retorna o resultado serializado de cada destinatário, pela posição na lista do job
+func ReadBatchResults(id string) (map[string]string, error) {
+	return repo.ReadBatchResults(id)
+}
@@ -0,0 +1,137 @@
+package redisDb
+
+import (
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/phone"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"strings"
+	"time"
+)
+
+const (
+	billingMonthFormat = "06:01" //Mês nas chaves sms:bil:, sms:bilpv: e sms:rs:
+
+	//Campos do detalhe de cobrança: <contador>:<provider>:<país>
+	usageSent     = "snt"
+	usageSegments = "seg"
+	usageFailed   = "fail"
+)
+
+// UsageKey identifica o provider e o país de destino (vazio se não identificado) no detalhe de cobrança
+type UsageKey struct {
+	Provider string
+	Country  string
+}
+
+type UsageCount struct {
+	Sent     int64
+	Segments int64 //Partes cobradas. Envios contados antes da contagem de partes não têm este valor
+	Failed   int64
+	Verified int64
+}
+
+// UsageCounts é o uso de uma bandeira no mês
+type UsageCounts struct {
+	Total  int64 //sms:bil:, inclusive os envios contados antes do detalhe por provider
+	Detail map[UsageKey]UsageCount
+}
+
+func getBillingUsageKey(month string, bandeira string) string {
+	return fmt.Sprintf("sms:bilpv:%s:%s", month, bandeira)
+}
+
+func getBillingUsageField(counter string, provider string, phoneNumber string) string {
+	return counter + ":" + provider + ":" + phone.CountryOf(phoneNumber)
+}
+
+// BillingMonth é o mês usado nas chaves de cobrança. Ex: 2024-05 -> 24:05
+func BillingMonth(month time.Time) string {
+	return month.Format(billingMonthFormat)
+}
+
+func accountUsage(bandeira string, increments map[string]int64) {
+	err := repo.IncrementUsage(getBillingUsageKey(time.Now().Format(billingMonthFormat), bandeira), increments)
+	if err != nil {
+		util.LogE(fmt.Sprintf("accountUsage:%s:%s", bandeira, err.Error()))
+	}
+}
+
+// accountSent conta o envio e as partes cobradas no provider e no país do telefone
+func accountSent(bandeira string, provider string, phoneNumber string, segments int) {
+	if provider == "" {
+		return
+	}
+	if segments <= 0 {
+		segments = 1
+	}
+	accountUsage(bandeira, map[string]int64{
+		getBillingUsageField(usageSent, provider, phoneNumber):     1,
+		getBillingUsageField(usageSegments, provider, phoneNumber): int64(segments),
+	})
+}
+
+// AccountFailure conta um envio que o provider recusou ou não conseguiu fazer
+func AccountFailure(bandeira string, provider string, phoneNumber string) {
+	if provider == "" {
+		return
+	}
+	accountUsage(bandeira, map[string]int64{getBillingUsageField(usageFailed, provider, phoneNumber): 1})
+}
+
+// BillingBandeiras retorna as bandeiras com envios contados no mês (BillingMonth)
+func BillingBandeiras(month string) ([]string, error) {
+	prefix := getBillingMonthKey(month, "")
+	keys, err := repo.ScanKeys(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	bandeiras := make([]string, 0, len(keys))
+	for _, key := range keys {
+		bandeiras = append(bandeiras, strings.TrimPrefix(key, prefix))
+	}
+	return bandeiras, nil
+}
+
+// ReadUsageCounts lê o uso da bandeira no mês (BillingMonth). As verificações vêm das respostas (sms:rs:).
+// Campos gravados antes do país (<contador>:<provider>) ficam com o país vazio.
+func ReadUsageCounts(month string, bandeira string) (result UsageCounts, err error) {
+	result.Detail = map[UsageKey]UsageCount{}
+	if result.Total, err = repo.ReadBilling(getBillingMonthKey(month, bandeira)); err != nil {
+		return result, err
+	}
+	usage, err := repo.ReadUsage(getBillingUsageKey(month, bandeira))
+	if err != nil {
+		return result, err
+	}
+	for field, count := range usage {
+		parts := strings.SplitN(field, ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		key := UsageKey{Provider: parts[1]}
+		if len(parts) == 3 {
+			key.Country = parts[2]
+		}
+		detail := result.Detail[key]
+		switch parts[0] {
+		case usageSent:
+			detail.Sent += count
+		case usageSegments:
+			detail.Segments += count
+		case usageFailed:
+			detail.Failed += count
+		}
+		result.Detail[key] = detail
+	}
+	verified, err := repo.CountVerifiedResponses(fmt.Sprintf("sms:rs:%s:%s:*", month, bandeira), func(provider string, phoneNumber string) string {
+		return provider + ":" + phone.CountryOf(phoneNumber)
+	})
+	for group, count := range verified {
+		parts := strings.SplitN(group, ":", 2)
+		key := UsageKey{Provider: parts[0], Country: parts[1]}
+		detail := result.Detail[key]
+		detail.Verified += count
+		result.Detail[key] = detail
+	}
+	return result, err
+}
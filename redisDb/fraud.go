@@ -0,0 +1,117 @@
+package redisDb
+
+import (
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/mediocregopher/radix/v3"
+	"time"
+)
+
+const (
+	FraudAllowList = "allow"
+	FraudDenyList  = "deny"
+
+	fraudRecentNumbersSize = 20
+	fraudReviewKey         = "sms:fr:review"
+)
+
+var fraudVelocityTTL int64 = 60 * 60            //1 hora - Janela fixa de pedidos ao mesmo número, aberta pelo primeiro
+var fraudRecentNumbersTTL int64 = 60 * 60       //1 hora - Números recentes por prefixo, para detectar sequências
+var fraudConversionTTL int64 = 2 * 24 * 60 * 60 //2 dias - Contadores diários de envio/verificação por prefixo
+
+func getFraudVelocityKey(phoneNumber string) string {
+	return "sms:fr:vel:" + phoneNumber
+}
+
+func getFraudRecentNumbersKey(prefix string) string {
+	return "sms:fr:seq:" + prefix
+}
+
+// getFraudConversionKey é o contador diário de conversão do prefixo. As respostas (sms:rs:) têm as verificações,
+// mas são indexadas por mês e bandeira: contar por prefixo exigiria um SCAN em cada envio.
+func getFraudConversionKey(prefix string) string {
+	return "sms:fr:cv:" + time.Now().Format("06:01:02") + ":" + prefix
+}
+
+func getFraudListKey(list string) string {
+	return "sms:fr:" + list
+}
+
+// ConfiguredPhonePrefix é o prefixo do telefone com o tamanho de RateLimit.PrefixLength
+func ConfiguredPhonePrefix(phoneNumber string) string {
+	prefixLength := util.AppCfg.RateLimitOptions.PrefixLength
+	if prefixLength <= 0 {
+		prefixLength = util.DefaultRateLimitPrefixLength
+	}
+	return PhonePrefix(phoneNumber, prefixLength)
+}
+
+// fraudCounterScript incrementa o contador e, no primeiro incremento, define a validade, numa única chamada: o
+// contador não fica sem validade se a conexão cair entre os dois comandos.
+// KEYS[1]: contador; ARGV[1]: validade (s). Retorna o valor do contador.
+var fraudCounterScript = radix.NewEvalScript(1, `
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// CountFraudNumberRequest conta um pedido ao número e retorna quantos houve na janela de uma hora, que começa no
+// primeiro pedido (janela fixa)
+func CountFraudNumberRequest(phoneNumber string) (int, error) {
+	return repo.IncrementFraudCounter(getFraudVelocityKey(phoneNumber), fraudVelocityTTL)
+}
+
+// PushFraudRecentNumber registra o número entre os recentes do prefixo e retorna os que já estavam lá
+func PushFraudRecentNumber(prefix string, phoneNumber string) ([]string, error) {
+	return repo.PushRecentFraudNumber(getFraudRecentNumbersKey(prefix), phoneNumber, fraudRecentNumbersSize, fraudRecentNumbersTTL)
+}
+
+// CountFraudConversion conta um envio de verificação ou um código verificado no prefixo do telefone
+func CountFraudConversion(phoneNumber string, verified bool) {
+	if !util.AppCfg.FraudOptions.Enabled {
+		return
+	}
+	prefix := ConfiguredPhonePrefix(phoneNumber)
+	if prefix == "" {
+		return
+	}
+	field := "snt"
+	if verified {
+		field = "vrf"
+	}
+	err := repo.IncrementFraudConversion(getFraudConversionKey(prefix), field, fraudConversionTTL)
+	if err != nil {
+		util.LogE("CountFraudConversion: " + err.Error())
+	}
+}
+
+// ReadFraudConversion retorna os envios de verificação e os códigos verificados no prefixo, no dia
+func ReadFraudConversion(prefix string) (sent int, verified int, err error) {
+	return repo.ReadFraudConversion(getFraudConversionKey(prefix))
+}
+
+func AddFraudListEntry(list string, entry string) error {
+	return repo.AddFraudListEntry(getFraudListKey(list), entry)
+}
+
+func RemoveFraudListEntry(list string, entry string) error {
+	return repo.RemoveFraudListEntry(getFraudListKey(list), entry)
+}
+
+func ReadFraudList(list string) ([]string, error) {
+	return repo.ReadFraudList(getFraudListKey(list))
+}
+
+// WriteFraudReview grava o envio retido (serializado), um por telefone
+func WriteFraudReview(phoneNumber string, review string) error {
+	return repo.SaveFraudReview(phoneNumber, review)
+}
+
+func DiscardFraudReview(phoneNumber string) error {
+	return repo.DeleteFraudReview(phoneNumber)
+}
+
+func ReadFraudReviews() (map[string]string, error) {
+	return repo.ReadFraudReviews()
+}
@@ -0,0 +1,77 @@
+package redisDb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	instancesKey = "sms:inst" //Set: instâncias que podem ter jobs e eventos em andamento
+
+	InstanceHeartbeatInterval       = 10 * time.Second
+	instanceTTL               int64 = 30 //Sem renovação por esse tempo, a instância é dada como parada
+)
+
+// InstanceId identifica o processo nas listas de trabalho em andamento (sms:jobs:running:, sms:outbox:run:).
+// Cada partida tem um id novo.
+var InstanceId = newInstanceId()
+
+func newInstanceId() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+func getInstanceKey(id string) string {
+	return "sms:inst:" + id
+}
+
+func getBatchRunningKey(id string) string {
+	return "sms:jobs:running:" + id
+}
+
+func getOutboxRunningKey(id string) string {
+	return "sms:outbox:run:" + id
+}
+
+// RenewInstance registra a instância como ativa por instanceTTL segundos. Deve ser chamada antes de pegar trabalho
+// das filas e renovada a cada InstanceHeartbeatInterval.
+func RenewInstance() error {
+	return repo.RenewInstance(InstanceId, instanceTTL)
+}
+
+// ReleaseInstance remove o registro da instância no encerramento: o trabalho que ficou em andamento volta para a
+// fila na próxima RequeueOrphanedWork de qualquer instância
+func ReleaseInstance() error {
+	return repo.ExpireInstance(InstanceId)
+}
+
+// RequeueOrphanedWork devolve à fila os jobs e os eventos em andamento das instâncias que pararam de renovar o
+// registro (ex: processo interrompido). Os das instâncias ativas não são tocados. Retorna quantas foram recuperadas.
+func RequeueOrphanedWork() (int, error) {
+	instances, err := repo.ReadInstances()
+	if err != nil {
+		return 0, err
+	}
+	recovered := 0
+	for id, alive := range instances {
+		if alive || (id == InstanceId) {
+			continue
+		}
+		if err = repo.RequeueRunningBatchJobs(id); err != nil {
+			return recovered, err
+		}
+		if err = repo.RequeueRunningOutboxEvents(id); err != nil {
+			return recovered, err
+		}
+		if err = repo.RemoveInstance(id); err != nil {
+			return recovered, err
+		}
+		recovered++
+	}
+	return recovered, nil
+}
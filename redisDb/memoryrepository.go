@@ -0,0 +1,886 @@
+package redisDb
+
+import (
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryRepository guarda os dados no processo, com as mesmas chaves e validades do Redis.
+// Serve para testes e para rodar o serviço localmente sem Redis; os dados se perdem ao reiniciar.
+type memoryRepository struct {
+	mutex    sync.Mutex
+	hashes   map[string]map[string]string
+	lists    map[string][]string
+	sets     map[string]map[string]bool
+	counters map[string]int64
+	windows  map[string][]windowSend
+	zsets    map[string]map[string]int64
+	expires  map[string]time.Time
+}
+
+// windowSend é um envio numa janela de CheckRateLimits: o horário (ms) e o membro que o identifica
+type windowSend struct {
+	at     int64
+	member string
+}
+
+func NewMemoryRepository() Repository {
+	return &memoryRepository{
+		hashes:   map[string]map[string]string{},
+		lists:    map[string][]string{},
+		sets:     map[string]map[string]bool{},
+		counters: map[string]int64{},
+		windows:  map[string][]windowSend{},
+		zsets:    map[string]map[string]int64{},
+		expires:  map[string]time.Time{},
+	}
+}
+
+//As funções abaixo supõem o mutex já adquirido
+
+func (m *memoryRepository) del(key string) {
+	delete(m.hashes, key)
+	delete(m.lists, key)
+	delete(m.sets, key)
+	delete(m.counters, key)
+	delete(m.windows, key)
+	delete(m.zsets, key)
+	delete(m.expires, key)
+}
+
+func (m *memoryRepository) expireIfNeeded(key string) {
+	if exp, ok := m.expires[key]; ok && !time.Now().Before(exp) {
+		m.del(key)
+	}
+}
+
+func (m *memoryRepository) expire(key string, ttlSeconds int64) {
+	m.expires[key] = time.Now().Add(time.Duration(ttlSeconds) * time.Second)
+}
+
+func (m *memoryRepository) hash(key string) map[string]string {
+	m.expireIfNeeded(key)
+	h, ok := m.hashes[key]
+	if !ok {
+		h = map[string]string{}
+		m.hashes[key] = h
+	}
+	return h
+}
+
+func (m *memoryRepository) exists(key string) bool {
+	m.expireIfNeeded(key)
+	_, ok := m.hashes[key]
+	return ok
+}
+
+func (m *memoryRepository) hget(key string, fields ...string) []string {
+	m.expireIfNeeded(key)
+	h := m.hashes[key]
+	result := make([]string, len(fields))
+	for i, field := range fields {
+		result[i] = h[field]
+	}
+	return result
+}
+
+func (m *memoryRepository) hset(key string, fieldValues ...string) {
+	h := m.hash(key)
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		h[fieldValues[i]] = fieldValues[i+1]
+	}
+}
+
+func (m *memoryRepository) hdel(key string, fields ...string) {
+	m.expireIfNeeded(key)
+	h, ok := m.hashes[key]
+	if !ok {
+		return
+	}
+	for _, field := range fields {
+		delete(h, field)
+	}
+	if len(h) == 0 {
+		m.del(key)
+	}
+}
+
+func (m *memoryRepository) hincr(key string, field string) int {
+	h := m.hash(key)
+	value, _ := strconv.Atoi(h[field])
+	value++
+	h[field] = strconv.Itoa(value)
+	return value
+}
+
+func (m *memoryRepository) incr(key string) int64 {
+	m.expireIfNeeded(key)
+	m.counters[key]++
+	return m.counters[key]
+}
+
+func (m *memoryRepository) list(key string) []string {
+	m.expireIfNeeded(key)
+	return m.lists[key]
+}
+
+func (m *memoryRepository) ReadRequest(key string) (*RequestData, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := m.hget(key, "idp", "sq", "si", "tsnd", "pv", "fa", "lk")
+	reqData := RequestData{Key: key, IdPedidoEnvio: result[0], Sq: result[1], SmsId: result[2], TimestampSend: result[3], Provider: result[4]}
+	reqData.FailedAttempts, _ = strconv.Atoi(result[5])
+	reqData.Locked = result[6] == "1"
+	return &reqData, nil
+}
+
+func (m *memoryRepository) SaveRequest(reqData RequestData, ttlSeconds int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hset(reqData.Key, "idp", reqData.IdPedidoEnvio, "sq", reqData.Sq, "si", reqData.SmsId, "tsnd", reqData.TimestampSend, "pv", reqData.Provider)
+	m.expire(reqData.Key, ttlSeconds)
+	return nil
+}
+
+func (m *memoryRepository) DiscardRequest(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hdel(key, "idp", "si", "sq", "tsnd", "pv")
+	return nil
+}
+
+func (m *memoryRepository) IncrementRequestField(key string, field string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.hincr(key, field), nil
+}
+
+func (m *memoryRepository) LockRequest(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hset(key, "lk", "1")
+	return nil
+}
+
+func (m *memoryRepository) ResetVerifyAttempts(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hdel(key, "fa", "lk")
+	return nil
+}
+
+// ClaimVerifyAttempt segue o claimVerifyAttemptScript
+func (m *memoryRepository) ClaimVerifyAttempt(key string, maxAttempts int) (VerifyAttempt, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.exists(key) {
+		return VerifyAttempt{}, nil
+	}
+	values := m.hget(key, "fa", "lk")
+	if values[1] == "1" {
+		attempts, _ := strconv.Atoi(values[0])
+		return VerifyAttempt{Attempts: attempts, Locked: true}, nil
+	}
+	attempts := m.hincr(key, "fa")
+	if attempts > maxAttempts {
+		m.hset(key, "lk", "1")
+		return VerifyAttempt{Attempts: attempts, Locked: true}, nil
+	}
+	return VerifyAttempt{Attempts: attempts}, nil
+}
+
+func (m *memoryRepository) RefundVerifyAttempt(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if attempts, _ := strconv.Atoi(m.hget(key, "fa")[0]); attempts > 0 {
+		m.hset(key, "fa", strconv.Itoa(attempts-1))
+	}
+	return nil
+}
+
+// ThrottleRequest segue o throttleScript
+func (m *memoryRepository) ThrottleRequest(key string, now int64, cooldownSeconds int, maxTries int, windowSeconds int) (ThrottleResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	elapsed := int(now - parseLastTry(m.hget(key, "tcts")[0]))
+	if elapsed < cooldownSeconds {
+		return ThrottleResult{WaitSeconds: cooldownSeconds - elapsed}, nil
+	}
+	if m.hincr(key, "tc") >= maxTries {
+		if elapsed <= windowSeconds {
+			return ThrottleResult{WaitSeconds: windowSeconds - elapsed, LimitReached: true}, nil
+		}
+		m.hdel(key, "tc")
+	}
+	m.hset(key, "tcts", strconv.FormatInt(now, 10))
+	return ThrottleResult{Allowed: true}, nil
+}
+
+func (m *memoryRepository) ResetRequestTries(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hdel(key, "tc", "tcts")
+	return nil
+}
+
+// CheckRateLimits segue o slidingWindowScript
+func (m *memoryRepository) CheckRateLimits(rules []RateLimitRule, nowMillis int64, member string) (int, int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, rule := range rules {
+		windowMillis := int64(rule.WindowSeconds) * 1000
+		m.expireIfNeeded(rule.Key)
+		sends := m.windows[rule.Key]
+		for (len(sends) > 0) && (sends[0].at <= nowMillis-windowMillis) {
+			sends = sends[1:]
+		}
+		m.windows[rule.Key] = sends
+		if len(sends) >= rule.Limit {
+			return i, int(sends[0].at + windowMillis - nowMillis), nil
+		}
+	}
+	for _, rule := range rules {
+		m.windows[rule.Key] = append(m.windows[rule.Key], windowSend{nowMillis, member})
+		m.expire(rule.Key, int64(rule.WindowSeconds))
+	}
+	return -1, 0, nil
+}
+
+func (m *memoryRepository) ReleaseRateLimits(rules []RateLimitRule, member string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, rule := range rules {
+		m.expireIfNeeded(rule.Key)
+		sends := m.windows[rule.Key]
+		for i, send := range sends {
+			if send.member == member {
+				m.windows[rule.Key] = append(sends[:i:i], sends[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepository) IncrementSpend(key string, amount int64, ttlSeconds int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expireIfNeeded(key)
+	m.counters[key] += amount
+	m.expire(key, ttlSeconds)
+	return nil
+}
+
+func (m *memoryRepository) ReadSpend(key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expireIfNeeded(key)
+	return m.counters[key], nil
+}
+
+func (m *memoryRepository) IncrementFraudCounter(key string, ttlSeconds int64) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := m.incr(key)
+	if value == 1 {
+		m.expire(key, ttlSeconds)
+	}
+	return int(value), nil
+}
+
+func (m *memoryRepository) PushRecentFraudNumber(key string, phoneNumber string, size int, ttlSeconds int64) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	recent := append([]string{}, m.list(key)...)
+	updated := append([]string{phoneNumber}, recent...)
+	if len(updated) > size {
+		updated = updated[:size]
+	}
+	m.lists[key] = updated
+	m.expire(key, ttlSeconds)
+	return recent, nil
+}
+
+func (m *memoryRepository) IncrementFraudConversion(key string, field string, ttlSeconds int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hincr(key, field)
+	m.expire(key, ttlSeconds)
+	return nil
+}
+
+func (m *memoryRepository) ReadFraudConversion(key string) (sent int, verified int, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := m.hget(key, "snt", "vrf")
+	sent, _ = strconv.Atoi(result[0])
+	verified, _ = strconv.Atoi(result[1])
+	return sent, verified, nil
+}
+
+func (m *memoryRepository) AddFraudListEntry(key string, entry string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.sets[key] == nil {
+		m.sets[key] = map[string]bool{}
+	}
+	m.sets[key][entry] = true
+	return nil
+}
+
+func (m *memoryRepository) RemoveFraudListEntry(key string, entry string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sets[key], entry)
+	return nil
+}
+
+func (m *memoryRepository) ReadFraudList(key string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var result []string
+	for entry := range m.sets[key] {
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+func (m *memoryRepository) SaveFraudReview(phoneNumber string, review string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hset(fraudReviewKey, phoneNumber, review)
+	return nil
+}
+
+func (m *memoryRepository) DeleteFraudReview(phoneNumber string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hdel(fraudReviewKey, phoneNumber)
+	return nil
+}
+
+func (m *memoryRepository) ReadFraudReviews() (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := map[string]string{}
+	for phoneNumber, review := range m.hashes[fraudReviewKey] {
+		result[phoneNumber] = review
+	}
+	return result, nil
+}
+
+func (m *memoryRepository) SaveResponse(respData ResponseData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hset(respData.Key, "idp", respData.IdPedidoEnvio, "pn", respData.PhoneNumber, "si", respData.SmsId, "tsnd", respData.TimestampSend, "pv", respData.Provider)
+	if respData.TimestampReceive != "" {
+		m.hset(respData.Key, "vc", respData.ValidationCode, "trcv", respData.TimestampReceive)
+	}
+	return nil
+}
+
+func (m *memoryRepository) SaveTempToken(smsId string, phoneNumber string, validationCode string, ttlSeconds int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hset(smsId, "pn", phoneNumber, "vc", validationCode)
+	m.expire(smsId, ttlSeconds)
+	return nil
+}
+
+func (m *memoryRepository) FindTempToken(smsId string) (phoneNumber string, validationCode string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := m.hget(smsId, "pn", "vc")
+	return result[0], result[1], nil
+}
+
+// TempTokenTTL segue o TTL do Redis: -2 se o token não existe, -1 se não tem validade
+func (m *memoryRepository) TempTokenTTL(smsId string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.exists(smsId) {
+		return -2, nil
+	}
+	exp, ok := m.expires[smsId]
+	if !ok {
+		return -1, nil
+	}
+	return int(time.Until(exp).Seconds()), nil
+}
+
+func (m *memoryRepository) NextSequence(name string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.incr(name), nil
+}
+
+func (m *memoryRepository) IncrementBilling(key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.incr(key), nil
+}
+
+func (m *memoryRepository) ReadBilling(key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expireIfNeeded(key)
+	return m.counters[key], nil
+}
+
+func (m *memoryRepository) IncrementUsage(key string, increments map[string]int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h := m.hash(key)
+	for field, increment := range increments {
+		value, _ := strconv.ParseInt(h[field], 10, 64)
+		h[field] = strconv.FormatInt(value+increment, 10)
+	}
+	return nil
+}
+
+func (m *memoryRepository) ReadUsage(key string) (map[string]int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expireIfNeeded(key)
+	result := map[string]int64{}
+	for field, value := range m.hashes[key] {
+		result[field], _ = strconv.ParseInt(value, 10, 64)
+	}
+	return result, nil
+}
+
+// keys supõe o mutex já adquirido
+func (m *memoryRepository) keys(pattern string) []string {
+	var all []string
+	for key := range m.hashes {
+		all = append(all, key)
+	}
+	for key := range m.lists {
+		all = append(all, key)
+	}
+	for key := range m.sets {
+		all = append(all, key)
+	}
+	for key := range m.counters {
+		all = append(all, key)
+	}
+	for key := range m.windows {
+		all = append(all, key)
+	}
+	for key := range m.zsets {
+		all = append(all, key)
+	}
+	var result []string
+	for _, key := range all {
+		m.expireIfNeeded(key)
+		if matched, _ := path.Match(pattern, key); matched && m.has(key) {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+func (m *memoryRepository) has(key string) bool {
+	_, isHash := m.hashes[key]
+	_, isList := m.lists[key]
+	_, isSet := m.sets[key]
+	_, isCounter := m.counters[key]
+	_, isWindow := m.windows[key]
+	_, isZset := m.zsets[key]
+	return isHash || isList || isSet || isCounter || isWindow || isZset
+}
+
+func (m *memoryRepository) ScanKeys(pattern string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.keys(pattern), nil
+}
+
+func (m *memoryRepository) CountVerifiedResponses(pattern string, groupBy func(provider string, phoneNumber string) string) (map[string]int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := map[string]int64{}
+	for _, key := range m.keys(pattern) {
+		if values := m.hget(key, "pv", "pn", "trcv"); values[2] != "" {
+			result[groupBy(values[0], values[1])]++
+		}
+	}
+	return result, nil
+}
+
+func (m *memoryRepository) lpush(key string, value string) {
+	m.lists[key] = append([]string{value}, m.lists[key]...)
+}
+
+func (m *memoryRepository) lrem(key string, value string) {
+	list := m.lists[key]
+	for i, item := range list {
+		if item == value {
+			m.lists[key] = append(list[:i:i], list[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *memoryRepository) PushOutboxEvent(event string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lpush(outboxQueueKey, event)
+	return nil
+}
+
+// popOutboxQueue retira o evento mais antigo da fila para a lista em entrega, como o BRPOPLPUSH do Redis
+func (m *memoryRepository) popOutboxQueue() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	queue := m.lists[outboxQueueKey]
+	if len(queue) == 0 {
+		return ""
+	}
+	event := queue[len(queue)-1]
+	m.lists[outboxQueueKey] = queue[:len(queue)-1]
+	m.lpush(getOutboxRunningKey(InstanceId), event)
+	return event
+}
+
+func (m *memoryRepository) PopOutboxEvent(timeoutSeconds int) (string, error) {
+	deadline := time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+	for {
+		if event := m.popOutboxQueue(); event != "" {
+			return event, nil
+		}
+		if !time.Now().Before(deadline) {
+			return "", nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (m *memoryRepository) AckOutboxEvent(event string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lrem(getOutboxRunningKey(InstanceId), event)
+	return nil
+}
+
+func (m *memoryRepository) ScheduleOutboxRetry(event string, retried string, atMillis int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.zsets[outboxRetryKey] == nil {
+		m.zsets[outboxRetryKey] = map[string]int64{}
+	}
+	m.zsets[outboxRetryKey][retried] = atMillis
+	m.lrem(getOutboxRunningKey(InstanceId), event)
+	return nil
+}
+
+func (m *memoryRepository) DeadLetterOutboxEvent(event string, dead string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lpush(outboxDeadKey, dead)
+	m.lrem(getOutboxRunningKey(InstanceId), event)
+	return nil
+}
+
+func (m *memoryRepository) PromoteOutboxRetries(nowMillis int64, limit int) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	promoted := 0
+	for event, atMillis := range m.zsets[outboxRetryKey] {
+		if promoted >= limit {
+			break
+		}
+		if atMillis <= nowMillis {
+			delete(m.zsets[outboxRetryKey], event)
+			m.lpush(outboxQueueKey, event)
+			promoted++
+		}
+	}
+	return promoted, nil
+}
+
+func (m *memoryRepository) RequeueRunningOutboxEvents(instanceId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	runningKey := getOutboxRunningKey(instanceId)
+	running := m.lists[runningKey]
+	for i := len(running) - 1; i >= 0; i-- {
+		m.lpush(outboxQueueKey, running[i])
+	}
+	delete(m.lists, runningKey)
+	return nil
+}
+
+func (m *memoryRepository) OutboxLength() (int64, int64, int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return int64(len(m.lists[outboxQueueKey])), int64(len(m.zsets[outboxRetryKey])), int64(len(m.lists[outboxDeadKey])), nil
+}
+
+func (m *memoryRepository) ReadFailedLog(key string) (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expireIfNeeded(key)
+	result := map[string]string{}
+	for field, value := range m.hashes[key] {
+		result[field] = value
+	}
+	return result, nil
+}
+
+func (m *memoryRepository) DrainFailedLog(key string, event string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lpush(outboxQueueKey, event)
+	m.del(key)
+	return nil
+}
+
+func (m *memoryRepository) SaveOtp(key string, otpData OtpData, ttlSeconds int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.del(key)
+	m.hset(key, "idp", otpData.IdPedidoEnvio, "h", otpData.Hash, "s", otpData.Salt, "exp", otpData.ExpiresAt.Format(time.RFC3339))
+	m.expire(key, int64(ttlSeconds))
+	return nil
+}
+
+func (m *memoryRepository) ReadOtp(key string) (*OtpData, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := m.hget(key, "idp", "h", "s", "exp")
+	if result[1] == "" {
+		return nil, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, result[3])
+	if err != nil {
+		return nil, err
+	}
+	return &OtpData{IdPedidoEnvio: result[0], Hash: result[1], Salt: result[2], ExpiresAt: expiresAt}, nil
+}
+
+func (m *memoryRepository) DeleteOtp(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.del(key)
+	return nil
+}
+
+func (m *memoryRepository) SaveMessage(msgData MessageData, ttlSeconds int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := getMessageKey(msgData.SmsId)
+	histKey := getMessageHistoryKey(&msgData.PhoneNumber, &msgData.Bandeira)
+	m.hset(key, "pv", msgData.Provider, "pn", msgData.PhoneNumber, "bd", msgData.Bandeira, "tsnd", msgData.TimestampSend, "st", msgData.Status, "stts", msgData.TimestampStatus)
+	m.expire(key, ttlSeconds)
+	history := append([]string{msgData.SmsId}, m.list(histKey)...)
+	if len(history) > messageHistorySize {
+		history = history[:messageHistorySize]
+	}
+	m.lists[histKey] = history
+	m.expire(histKey, ttlSeconds)
+	return nil
+}
+
+func (m *memoryRepository) ReadMessage(smsId string) (*MessageData, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := m.hget(getMessageKey(smsId), "pv", "pn", "bd", "tsnd", "st", "pst", "stts", "va", "tvrf")
+	if result[0] == "" {
+		return nil, nil
+	}
+	msgData := MessageData{SmsId: smsId, Provider: result[0], PhoneNumber: result[1], Bandeira: result[2], TimestampSend: result[3],
+		Status: result[4], ProviderStatus: result[5], TimestampStatus: result[6], TimestampVerify: result[8]}
+	msgData.VerifyAttempts, _ = strconv.Atoi(result[7])
+	return &msgData, nil
+}
+
+func (m *memoryRepository) ReadMessageHistory(phoneNumber string, bandeira string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string{}, m.list(getMessageHistoryKey(&phoneNumber, &bandeira))...), nil
+}
+
+func (m *memoryRepository) UpdateMessageStatus(smsId string, status string, providerStatus string, tsStatus string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hset(getMessageKey(smsId), "st", status, "pst", providerStatus, "stts", tsStatus)
+	return nil
+}
+
+func (m *memoryRepository) IncrementMessageVerifyAttempts(smsId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := getMessageKey(smsId)
+	if m.exists(key) {
+		m.hincr(key, "va")
+	}
+	return nil
+}
+
+func (m *memoryRepository) SetMessageVerified(smsId string, tsVerify string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := getMessageKey(smsId)
+	if m.exists(key) {
+		m.hset(key, "tvrf", tsVerify)
+	}
+	return nil
+}
+
+func (m *memoryRepository) CreateBatchJob(job BatchJob, recipients []string, ttlSeconds int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := getBatchJobKey(job.Id)
+	rcptKey := getBatchRecipientsKey(job.Id)
+	m.hset(key, "bd", job.Bandeira, "tpl", job.Template, "ip", job.ClientIp, "st", job.Status, "tot", strconv.Itoa(job.Total), "snt", "0", "fl", "0", "crt", job.Created)
+	m.lists[rcptKey] = append([]string{}, recipients...)
+	m.expire(key, ttlSeconds)
+	m.expire(rcptKey, ttlSeconds)
+	m.lists[batchQueueKey] = append([]string{job.Id}, m.lists[batchQueueKey]...)
+	return nil
+}
+
+// popBatchQueue retira o job mais antigo da fila para a lista em execução, como o BRPOPLPUSH do Redis
+func (m *memoryRepository) popBatchQueue() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	queue := m.lists[batchQueueKey]
+	if len(queue) == 0 {
+		return ""
+	}
+	id := queue[len(queue)-1]
+	m.lists[batchQueueKey] = queue[:len(queue)-1]
+	m.lpush(getBatchRunningKey(InstanceId), id)
+	m.hset(getBatchJobKey(id), "st", BatchRunning)
+	return id
+}
+
+func (m *memoryRepository) PopBatchJob(timeoutSeconds int) (string, error) {
+	deadline := time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+	for {
+		if id := m.popBatchQueue(); id != "" {
+			return id, nil
+		}
+		if !time.Now().Before(deadline) {
+			return "", nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (m *memoryRepository) RequeueRunningBatchJobs(instanceId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	runningKey := getBatchRunningKey(instanceId)
+	running := m.lists[runningKey]
+	for i := len(running) - 1; i >= 0; i-- {
+		m.hset(getBatchJobKey(running[i]), "st", BatchQueued)
+		m.lpush(batchQueueKey, running[i])
+	}
+	delete(m.lists, runningKey)
+	return nil
+}
+
+func (m *memoryRepository) FinishBatchJob(id string, tsFinish string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hset(getBatchJobKey(id), "st", BatchDone, "fin", tsFinish)
+	m.lrem(getBatchRunningKey(InstanceId), id)
+	return nil
+}
+
+func (m *memoryRepository) ReadBatchJob(id string) (*BatchJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := m.hget(getBatchJobKey(id), "bd", "tpl", "st", "tot", "snt", "fl", "crt", "fin", "ip")
+	if result[2] == "" {
+		return nil, nil
+	}
+	job := BatchJob{Id: id, Bandeira: result[0], Template: result[1], Status: result[2], Created: result[6], Finished: result[7], ClientIp: result[8]}
+	job.Total, _ = strconv.Atoi(result[3])
+	job.Sent, _ = strconv.Atoi(result[4])
+	job.Failed, _ = strconv.Atoi(result[5])
+	return &job, nil
+}
+
+func (m *memoryRepository) ReadBatchRecipients(id string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string{}, m.list(getBatchRecipientsKey(id))...), nil
+}
+
+func (m *memoryRepository) SaveBatchResult(id string, recipient string, result string, success bool, ttlSeconds int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	resKey := getBatchResultsKey(id)
+	results := m.hash(resKey)
+	if _, done := results[recipient]; done {
+		return nil
+	}
+	results[recipient] = result
+	counter := "fl"
+	if success {
+		counter = "snt"
+	}
+	m.hincr(getBatchJobKey(id), counter)
+	m.expire(resKey, ttlSeconds)
+	return nil
+}
+
+func (m *memoryRepository) ReadBatchResults(id string) (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expireIfNeeded(getBatchResultsKey(id))
+	result := map[string]string{}
+	for recipient, value := range m.hashes[getBatchResultsKey(id)] {
+		result[recipient] = value
+	}
+	return result, nil
+}
+
+func (m *memoryRepository) RenewInstance(id string, ttlSeconds int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hset(getInstanceKey(id), "id", id)
+	m.expire(getInstanceKey(id), ttlSeconds)
+	if m.sets[instancesKey] == nil {
+		m.sets[instancesKey] = map[string]bool{}
+	}
+	m.sets[instancesKey][id] = true
+	return nil
+}
+
+func (m *memoryRepository) ExpireInstance(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.del(getInstanceKey(id))
+	return nil
+}
+
+func (m *memoryRepository) ReadInstances() (map[string]bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := map[string]bool{}
+	for id := range m.sets[instancesKey] {
+		result[id] = m.exists(getInstanceKey(id))
+	}
+	return result, nil
+}
+
+func (m *memoryRepository) RemoveInstance(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sets[instancesKey], id)
+	return nil
+}
+
+func (m *memoryRepository) Ping() error {
+	return nil
+}
+
+func (m *memoryRepository) Close() error {
+	return nil
+}
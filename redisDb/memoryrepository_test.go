@@ -0,0 +1,453 @@
+package redisDb
+
+import (
+	"encoding/json"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useMemoryRepository troca o armazenamento e a configuração pelos do teste e os restaura no fim
+func useMemoryRepository(t *testing.T) *memoryRepository {
+	previousRepo := repo
+	previousCfg := util.AppCfg
+	t.Cleanup(func() {
+		repo = previousRepo
+		util.AppCfg = previousCfg
+	})
+	memory := NewMemoryRepository().(*memoryRepository)
+	SetRepository(memory)
+	util.AppCfg = util.Config{}
+	return memory
+}
+
+func TestThrottleRequest(t *testing.T) {
+	memory := useMemoryRepository(t)
+	key := "sms:rq:1:+5511999990000"
+	cooldown, maxTries, window := 45, 2, 120
+
+	steps := []struct {
+		now    int64
+		result ThrottleResult
+	}{
+		{1000, ThrottleResult{Allowed: true}},
+		{1010, ThrottleResult{WaitSeconds: 35}},                     //Dentro do intervalo mínimo
+		{1050, ThrottleResult{WaitSeconds: 70, LimitReached: true}}, //2ª tentativa: atingiu o máximo
+		{1100, ThrottleResult{WaitSeconds: 20, LimitReached: true}},
+		{1200, ThrottleResult{Allowed: true}}, //Passou a espera: novo ciclo
+		{1250, ThrottleResult{Allowed: true}},
+		{1300, ThrottleResult{WaitSeconds: 70, LimitReached: true}},
+	}
+	for _, step := range steps {
+		result, err := memory.ThrottleRequest(key, step.now, cooldown, maxTries, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != step.result {
+			t.Errorf("ThrottleRequest em %d = %+v, esperado %+v", step.now, result, step.result)
+		}
+	}
+
+	if err := memory.ResetRequestTries(key); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := memory.ThrottleRequest(key, 1310, cooldown, maxTries, window); !result.Allowed {
+		t.Errorf("ThrottleRequest depois de ResetRequestTries = %+v, esperado permitido", result)
+	}
+}
+
+func TestThrottleRequestLegacyTimestamp(t *testing.T) {
+	memory := useMemoryRepository(t)
+	key := "sms:rq:1:+5511999990000"
+	last := time.Date(2024, 5, 1, 10, 0, 0, 0, time.FixedZone("BRT", -3*3600))
+
+	//Pedido gravado pela versão anterior: tcts em RFC3339
+	memory.hset(key, "tcts", last.Format(time.RFC3339))
+	result, err := memory.ThrottleRequest(key, last.Unix()+10, 45, 3, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (ThrottleResult{WaitSeconds: 35}); result != expected {
+		t.Errorf("ThrottleRequest com tcts antigo = %+v, esperado %+v", result, expected)
+	}
+	if result, _ = memory.ThrottleRequest(key, last.Unix()+45, 45, 3, 120); !result.Allowed {
+		t.Errorf("ThrottleRequest depois do intervalo = %+v, esperado permitido", result)
+	}
+}
+
+func TestParseLastTry(t *testing.T) {
+	cases := map[string]int64{
+		"":                          0,
+		"1714568400":                1714568400,
+		"2024-05-01T10:00:00-03:00": 1714568400,
+		"2024-05-01T13:00:00Z":      1714568400,
+		"2024-02-29T23:59:59+05:30": 1709231399,
+		"ontem":                     0,
+	}
+	for tcts, expected := range cases {
+		if last := parseLastTry(tcts); last != expected {
+			t.Errorf("parseLastTry(%q) = %d, esperado %d", tcts, last, expected)
+		}
+	}
+}
+
+func TestCanRequestCooldown(t *testing.T) {
+	useMemoryRepository(t)
+	util.AppCfg.SmsOptions.MaxSmsRequestsPerPhone = 2
+	key := "sms:rq:1:+5511999990000"
+
+	if allowed, err := canRequest(&key, "1"); !allowed {
+		t.Fatalf("1º pedido recusado: %v", err)
+	}
+	allowed, err := canRequest(&key, "1")
+	if allowed || (err == nil) || !strings.Contains(err.Error(), "1 minuto") {
+		t.Errorf("2º pedido imediato = %v, %v; esperado recusado pelo intervalo mínimo", allowed, err)
+	}
+}
+
+func TestCheckRateLimitsSlidingWindow(t *testing.T) {
+	memory := useMemoryRepository(t)
+	rules := []RateLimitRule{
+		{"ip", "sms:rl:ip:10.0.0.1", 60, 3},
+		{"bandeira/hora", "sms:rl:bd:h:1", 60, 2},
+	}
+	now := int64(1000000)
+
+	for i := 0; i < 2; i++ {
+		exceeded, _, err := memory.CheckRateLimits(rules, now+int64(i)*1000, nextRateLimitMember(time.Now()))
+		if (err != nil) || (exceeded != -1) {
+			t.Fatalf("envio %d: regra esgotada %d, %v", i+1, exceeded, err)
+		}
+	}
+	exceeded, waitMillis, _ := memory.CheckRateLimits(rules, now+10000, nextRateLimitMember(time.Now()))
+	if exceeded != 1 {
+		t.Fatalf("3º envio: regra esgotada %d, esperado 1 (bandeira/hora)", exceeded)
+	}
+	if waitMillis != 50000 {
+		t.Errorf("3º envio: espera %dms, esperado 50000ms (o envio mais antigo sai da janela)", waitMillis)
+	}
+	//O envio recusado não é registrado em nenhuma janela
+	if sends := len(memory.windows["sms:rl:ip:10.0.0.1"]); sends != 2 {
+		t.Errorf("janela do ip com %d envios, esperado 2", sends)
+	}
+
+	//O 1º envio saiu da janela
+	if exceeded, _, _ = memory.CheckRateLimits(rules, now+60000, nextRateLimitMember(time.Now())); exceeded != -1 {
+		t.Errorf("envio após a janela: regra esgotada %d, esperado permitido", exceeded)
+	}
+	if exceeded, _, _ = memory.CheckRateLimits(rules, now+60500, nextRateLimitMember(time.Now())); exceeded != 1 {
+		t.Errorf("3º envio na janela da bandeira: regra esgotada %d, esperado 1 (bandeira/hora)", exceeded)
+	}
+}
+
+func TestCheckRateLimitsWaitSeconds(t *testing.T) {
+	useMemoryRepository(t)
+	rules := []RateLimitRule{{"global/dia", "sms:rl:global", 24 * 60 * 60, 1}}
+
+	if rule, _, reservation, err := CheckRateLimits(rules); (rule != nil) || (reservation == nil) || (err != nil) {
+		t.Fatalf("1º envio recusado por %v, %v", rule, err)
+	}
+	rule, waitSeconds, reservation, _ := CheckRateLimits(rules)
+	if (rule == nil) || (rule.Name != "global/dia") || (reservation != nil) {
+		t.Fatalf("2º envio: regra %v, reserva %v; esperado global/dia sem reserva", rule, reservation)
+	}
+	if (waitSeconds <= 0) || (waitSeconds > 24*60*60) {
+		t.Errorf("2º envio: espera de %ds fora da janela", waitSeconds)
+	}
+}
+
+func TestReleaseRateLimits(t *testing.T) {
+	memory := useMemoryRepository(t)
+	rules := []RateLimitRule{
+		{"ip", "sms:rl:ip:10.0.0.1", 60, 2},
+		{"global/dia", "sms:rl:global", 24 * 60 * 60, 2},
+	}
+
+	_, _, first, _ := CheckRateLimits(rules)
+	_, _, second, _ := CheckRateLimits(rules)
+	if rule, _, _, _ := CheckRateLimits(rules); rule == nil {
+		t.Fatal("3º envio permitido, esperado recusado pelo ip")
+	}
+	//O envio recusado depois (antifraude) ou que falhou no provider libera as janelas
+	first.Release()
+	for _, rule := range rules {
+		if sends := len(memory.windows[rule.Key]); sends != 1 {
+			t.Errorf("janela %s com %d envios depois de liberar, esperado 1", rule.Name, sends)
+		}
+	}
+	rule, _, third, _ := CheckRateLimits(rules)
+	if (rule != nil) || (third == nil) {
+		t.Fatalf("envio depois de liberar recusado por %v", rule)
+	}
+	//Só o envio liberado sai da janela
+	second.Release()
+	second.Release()
+	if sends := len(memory.windows["sms:rl:ip:10.0.0.1"]); sends != 1 {
+		t.Errorf("janela do ip com %d envios, esperado 1", sends)
+	}
+	var none *RateLimitReservation
+	none.Release()
+}
+
+func TestVerifyAttemptLock(t *testing.T) {
+	useMemoryRepository(t)
+	util.AppCfg.SmsOptions.MaxSmsRequestsPerPhone = 2
+	phoneNumber, bandeira := "+5511999990000", "1"
+	maxAttempts := 3
+
+	if attempt, _ := ClaimVerifyAttempt(&phoneNumber, &bandeira, maxAttempts); attempt.Attempts != 0 {
+		t.Errorf("ClaimVerifyAttempt sem pedido = %+v, esperado 0 tentativas", attempt)
+	}
+	if _, err := WriteRequest(RequestData{PhoneNumber: phoneNumber, Bandeira: bandeira}); err != nil {
+		t.Fatal(err)
+	}
+	for expected := 1; expected <= maxAttempts; expected++ {
+		attempt, err := ClaimVerifyAttempt(&phoneNumber, &bandeira, maxAttempts)
+		if (err != nil) || (attempt != VerifyAttempt{Attempts: expected}) {
+			t.Fatalf("ClaimVerifyAttempt = %+v, %v; esperado %d tentativas", attempt, err, expected)
+		}
+	}
+	//A tentativa além do limite bloqueia o pedido sem verificar o código
+	attempt, _ := ClaimVerifyAttempt(&phoneNumber, &bandeira, maxAttempts)
+	if (attempt != VerifyAttempt{Attempts: maxAttempts + 1, Locked: true}) {
+		t.Errorf("ClaimVerifyAttempt além do limite = %+v, esperado bloqueado", attempt)
+	}
+	if attempt, _ = ClaimVerifyAttempt(&phoneNumber, &bandeira, maxAttempts); (attempt != VerifyAttempt{Attempts: maxAttempts + 1, Locked: true}) {
+		t.Errorf("ClaimVerifyAttempt no pedido bloqueado = %+v, esperado bloqueado sem contar", attempt)
+	}
+	reqData, err := ReadRequest(&phoneNumber, &bandeira)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reqData.Locked {
+		t.Errorf("pedido não bloqueado depois de %d tentativas", maxAttempts+1)
+	}
+
+	//Um novo pedido libera a verificação e zera as recusas
+	resetTryCount(&reqData.Key)
+	if _, err = WriteRequest(RequestData{PhoneNumber: phoneNumber, Bandeira: bandeira}); err != nil {
+		t.Fatal(err)
+	}
+	reqData, _ = ReadRequest(&phoneNumber, &bandeira)
+	if reqData.Locked || (reqData.FailedAttempts != 0) {
+		t.Errorf("novo pedido: Locked %v, FailedAttempts %d; esperado false, 0", reqData.Locked, reqData.FailedAttempts)
+	}
+}
+
+func TestVerifyAttemptRefund(t *testing.T) {
+	useMemoryRepository(t)
+	phoneNumber, bandeira := "+5511999990000", "1"
+	if _, err := WriteRequest(RequestData{PhoneNumber: phoneNumber, Bandeira: bandeira}); err != nil {
+		t.Fatal(err)
+	}
+
+	//Sem contador, a devolução não deixa o contador negativo
+	if err := RefundVerifyAttempt(&phoneNumber, &bandeira); err != nil {
+		t.Fatal(err)
+	}
+	ClaimVerifyAttempt(&phoneNumber, &bandeira, 1)
+	if err := RefundVerifyAttempt(&phoneNumber, &bandeira); err != nil {
+		t.Fatal(err)
+	}
+	//Com a tentativa devolvida, a única tentativa permitida continua disponível
+	if attempt, _ := ClaimVerifyAttempt(&phoneNumber, &bandeira, 1); (attempt != VerifyAttempt{Attempts: 1}) {
+		t.Errorf("ClaimVerifyAttempt depois da devolução = %+v, esperado 1 tentativa", attempt)
+	}
+}
+
+func TestVerifyAttemptConcurrent(t *testing.T) {
+	useMemoryRepository(t)
+	phoneNumber, bandeira := "+5511999990000", "1"
+	maxAttempts := 3
+	if _, err := WriteRequest(RequestData{PhoneNumber: phoneNumber, Bandeira: bandeira}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, err := ClaimVerifyAttempt(&phoneNumber, &bandeira, maxAttempts)
+			if (err == nil) && !attempt.Locked {
+				mutex.Lock()
+				allowed++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != maxAttempts {
+		t.Errorf("%d verificações simultâneas permitidas, esperado %d", allowed, maxAttempts)
+	}
+}
+
+// logMachineStub responde aos POST do /save com o status atual e conta as chamadas
+type logMachineStub struct {
+	status int
+	calls  int
+}
+
+func startLogMachine(t *testing.T, status int) *logMachineStub {
+	stub := &logMachineStub{status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.calls++
+		w.WriteHeader(stub.status)
+	}))
+	t.Cleanup(server.Close)
+	util.AppCfg.LogMachine = server.URL
+	return stub
+}
+
+func popOutboxEvent(t *testing.T) (string, OutboxEvent) {
+	raw, err := PopOutboxEvent(0)
+	if (err != nil) || (raw == "") {
+		t.Fatalf("PopOutboxEvent = %q, %v", raw, err)
+	}
+	var event OutboxEvent
+	if err = json.Unmarshal([]byte(raw), &event); err != nil {
+		t.Fatal(err)
+	}
+	return raw, event
+}
+
+func assertOutboxLength(t *testing.T, memory *memoryRepository, queued int64, retrying int64, dead int64) {
+	t.Helper()
+	q, r, d, _ := OutboxLength()
+	if (q != queued) || (r != retrying) || (d != dead) {
+		t.Errorf("outbox com %d na fila, %d aguardando, %d descartados; esperado %d, %d, %d", q, r, d, queued, retrying, dead)
+	}
+	if running := len(memory.lists[getOutboxRunningKey(InstanceId)]); running != 0 {
+		t.Errorf("%d eventos presos em %s", running, getOutboxRunningKey(InstanceId))
+	}
+}
+
+func TestOutboxDelivery(t *testing.T) {
+	memory := useMemoryRepository(t)
+	logMachine := startLogMachine(t, http.StatusOK)
+
+	enqueueOutboxEvent(newRequestLogEvent(RequestData{IdPedidoEnvio: "7", Bandeira: "1", PhoneNumber: "+5511999990000"}))
+	raw, _ := popOutboxEvent(t)
+	ProcessOutboxEvent(raw)
+
+	if logMachine.calls != 1 {
+		t.Errorf("logmachine chamado %d vezes, esperado 1", logMachine.calls)
+	}
+	assertOutboxLength(t, memory, 0, 0, 0)
+}
+
+func TestOutboxRetryAndDeadLetter(t *testing.T) {
+	memory := useMemoryRepository(t)
+	util.AppCfg.OutboxOptions.MaxAttempts = 2
+	logMachine := startLogMachine(t, http.StatusInternalServerError)
+
+	enqueueOutboxEvent(newRequestLogEvent(RequestData{IdPedidoEnvio: "7", Bandeira: "1", PhoneNumber: "+5511999990000"}))
+	raw, _ := popOutboxEvent(t)
+	ProcessOutboxEvent(raw)
+	assertOutboxLength(t, memory, 0, 1, 0)
+
+	//A nova tentativa só volta à fila depois do backoff
+	if promoted, _ := PromoteOutboxRetries(); promoted != 0 {
+		t.Errorf("%d eventos promovidos antes do backoff, esperado 0", promoted)
+	}
+	backoff := time.Duration(util.DefaultOutboxRetryBaseSeconds) * time.Second
+	if promoted, _ := memory.PromoteOutboxRetries(time.Now().Add(backoff).UnixNano()/int64(time.Millisecond), outboxPromoteBatch); promoted != 1 {
+		t.Fatalf("%d eventos promovidos depois do backoff, esperado 1", promoted)
+	}
+	raw, event := popOutboxEvent(t)
+	if event.Attempts != 1 {
+		t.Errorf("evento com %d tentativas, esperado 1", event.Attempts)
+	}
+
+	//Esgotou Outbox.MaxAttempts
+	ProcessOutboxEvent(raw)
+	assertOutboxLength(t, memory, 0, 0, 1)
+	if err := json.Unmarshal([]byte(memory.lists[outboxDeadKey][0]), &event); (err != nil) || (event.Attempts != 2) {
+		t.Errorf("evento descartado com %d tentativas (%v), esperado 2", event.Attempts, err)
+	}
+	if logMachine.calls != 2 {
+		t.Errorf("logmachine chamado %d vezes, esperado 2", logMachine.calls)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	useMemoryRepository(t)
+	util.AppCfg.OutboxOptions.RetryBaseSeconds = 5
+	util.AppCfg.OutboxOptions.RetryMaxSeconds = 30
+	for attempts, expected := range map[int]int{1: 5, 2: 10, 3: 20, 4: 30, 10: 30} {
+		if delay := outboxBackoff(attempts); delay != time.Duration(expected)*time.Second {
+			t.Errorf("outboxBackoff(%d) = %s, esperado %ds", attempts, delay, expected)
+		}
+	}
+}
+
+// useInstance faz o teste rodar como a instância id, restaurando o InstanceId no fim
+func useInstance(t *testing.T, id string) {
+	previousId := InstanceId
+	t.Cleanup(func() { InstanceId = previousId })
+	InstanceId = id
+	if err := RenewInstance(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequeueOrphanedWork(t *testing.T) {
+	memory := useMemoryRepository(t)
+
+	//Cada instância pega um job e um evento
+	for _, id := range []string{"parada", "ativa"} {
+		useInstance(t, id)
+		jobId, err := CreateBatchJob("1", "oi", "10.0.0.1", []string{`{"phoneNumber":"+5511999990000"}`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if popped, _ := PopBatchJob(0); popped != jobId {
+			t.Fatalf("PopBatchJob = %q, esperado %q", popped, jobId)
+		}
+		enqueueOutboxEvent(newRequestLogEvent(RequestData{IdPedidoEnvio: id, Bandeira: "1", PhoneNumber: "+5511999990000"}))
+		popOutboxEvent(t)
+	}
+	memory.del(getInstanceKey("parada")) //O registro expirou: a instância parou sem concluir
+
+	//Na instância ativa, só o trabalho da parada volta para a fila
+	if recovered, err := RequeueOrphanedWork(); (err != nil) || (recovered != 1) {
+		t.Fatalf("RequeueOrphanedWork = %d, %v; esperado 1 instância", recovered, err)
+	}
+	if queued := len(memory.lists[batchQueueKey]); queued != 1 {
+		t.Errorf("%d jobs na fila, esperado 1", queued)
+	}
+	if job, _ := ReadBatchJob(memory.lists[batchQueueKey][0]); (job == nil) || (job.Status != BatchQueued) {
+		t.Errorf("job devolvido à fila com status %v, esperado %s", job, BatchQueued)
+	}
+	if queued := len(memory.lists[outboxQueueKey]); queued != 1 {
+		t.Errorf("%d eventos na fila, esperado 1", queued)
+	}
+	for _, key := range []string{getBatchRunningKey("ativa"), getOutboxRunningKey("ativa")} {
+		if running := len(memory.lists[key]); running != 1 {
+			t.Errorf("%s com %d itens, esperado 1 (a instância ativa continua com o seu)", key, running)
+		}
+	}
+	for _, key := range []string{getBatchRunningKey("parada"), getOutboxRunningKey("parada")} {
+		if running := len(memory.lists[key]); running != 0 {
+			t.Errorf("%s com %d itens, esperado 0", key, running)
+		}
+	}
+	if recovered, _ := RequeueOrphanedWork(); recovered != 0 {
+		t.Errorf("RequeueOrphanedWork de novo = %d, esperado 0", recovered)
+	}
+
+	//No encerramento a instância remove o registro e o trabalho que ficou é retomado pelas outras
+	if err := ReleaseInstance(); err != nil {
+		t.Fatal(err)
+	}
+	useInstance(t, "nova")
+	if recovered, _ := RequeueOrphanedWork(); recovered != 1 {
+		t.Errorf("RequeueOrphanedWork depois de ReleaseInstance = %d, esperado 1", recovered)
+	}
+}
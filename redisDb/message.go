@@ -0,0 +1,75 @@
+package redisDb
+
+import (
+	"fmt"
+	"time"
+)
+
+// MessageData é o registro de um SMS enviado, indexado pelo id do provider (o mesmo si de sms:rq:/sms:rs:)
+type MessageData struct {
+	SmsId           string
+	Provider        string
+	PhoneNumber     string
+	Bandeira        string
+	TimestampSend   string
+	Status          string
+	ProviderStatus  string
+	TimestampStatus string
+	VerifyAttempts  int
+	TimestampVerify string
+}
+
+const messageHistorySize = 50
+
+func getMessageKey(smsId string) string {
+	return "sms:msg:" + smsId
+}
+
+func getMessageHistoryKey(phoneNumber *string, bandeira *string) string {
+	return fmt.Sprintf("sms:hist:%s:%s", *bandeira, *phoneNumber)
+}
+
+// WriteMessage grava o SMS e o inclui no histórico do telefone (últimos messageHistorySize envios)
+func WriteMessage(smsId string, provider string, phoneNumber string, bandeira string, status string) error {
+	ts := time.Now().Format(time.RFC3339)
+	msgData := MessageData{SmsId: smsId, Provider: provider, PhoneNumber: phoneNumber, Bandeira: bandeira, TimestampSend: ts, Status: status, TimestampStatus: ts}
+	return repo.SaveMessage(msgData, requestTTL)
+}
+
+// ReadMessageHistory retorna os smsIds enviados ao telefone, do mais recente para o mais antigo
+func ReadMessageHistory(phoneNumber *string, bandeira *string) ([]string, error) {
+	return repo.ReadMessageHistory(*phoneNumber, *bandeira)
+}
+
+// CountMessageVerifyAttempt conta uma verificação do código no status da mensagem
+func CountMessageVerifyAttempt(smsId string) {
+	if smsId == "" {
+		return
+	}
+	repo.IncrementMessageVerifyAttempts(smsId)
+}
+
+func markMessageVerified(smsId string, tsVerify string) {
+	if smsId == "" {
+		return
+	}
+	repo.SetMessageVerified(smsId, tsVerify)
+}
+
+// UpdateMessageStatus grava o status de entrega. found é false se a mensagem não existe ou é de outro provider.
+func UpdateMessageStatus(smsId string, provider string, status string, providerStatus string, tsStatus string) (found bool, err error) {
+	msgData, err := ReadMessage(smsId)
+	if (err != nil) || (msgData == nil) || (msgData.Provider != provider) {
+		return false, err
+	}
+	if tsStatus == "" {
+		tsStatus = time.Now().Format(time.RFC3339)
+	}
+	err = repo.UpdateMessageStatus(smsId, status, providerStatus, tsStatus)
+	return err == nil, err
+}
+
+// ReadMessage retorna nil se a mensagem não existe ou expirou
+func ReadMessage(smsId string) (*MessageData, error) {
+	return repo.ReadMessage(smsId)
+}
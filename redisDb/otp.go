@@ -0,0 +1,38 @@
+package redisDb
+
+import (
+	"fmt"
+	"time"
+)
+
+// OtpData é o código gerado localmente para um pedido. Só o hash com salt é armazenado, nunca o código.
+type OtpData struct {
+	IdPedidoEnvio string
+	Hash          string
+	Salt          string
+	ExpiresAt     time.Time
+}
+
+func getOtpKey(phoneNumber *string, bandeira *string) string {
+	return fmt.Sprintf("sms:otp:%s:%s", *bandeira, *phoneNumber)
+}
+
+func WriteOtp(phoneNumber *string, bandeira *string, idPedidoEnvio string, hash string, salt string, ttlSeconds int) error {
+	key := getOtpKey(phoneNumber, bandeira)
+	exp := time.Now().Add(time.Duration(ttlSeconds) * time.Second)
+	return repo.SaveOtp(key, OtpData{IdPedidoEnvio: idPedidoEnvio, Hash: hash, Salt: salt, ExpiresAt: exp}, ttlSeconds)
+}
+
+// ReadOtp retorna nil quando não há código pendente ou ele expirou
+func ReadOtp(phoneNumber *string, bandeira *string) (*OtpData, error) {
+	otpData, err := repo.ReadOtp(getOtpKey(phoneNumber, bandeira))
+	if (err != nil) || (otpData == nil) || time.Now().After(otpData.ExpiresAt) {
+		return nil, err
+	}
+	return otpData, nil
+}
+
+func DiscardOtp(phoneNumber *string, bandeira *string) {
+	key := getOtpKey(phoneNumber, bandeira)
+	repo.DeleteOtp(key)
+}
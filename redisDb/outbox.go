@@ -0,0 +1,220 @@
+package redisDb
+
+import (
+	"encoding/json"
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/mediocregopher/radix/v3"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	outboxQueueKey = "sms:outbox"       //Eventos a entregar. Os em entrega ficam em sms:outbox:run:<instância>
+	outboxRetryKey = "sms:outbox:retry" //Sorted set: eventos aguardando nova tentativa, pelo horário (ms)
+	outboxDeadKey  = "sms:outbox:dead"  //Eventos que esgotaram as tentativas
+
+	outboxPromoteBatch = 100
+	outboxPostTimeout  = 15 * time.Second
+
+	entityRequestLog  = "historico_envio_sms"
+	entityResponseLog = "historico_confirmacao_sms"
+
+	failedRequestLogPrefix  = "sms:logrq:"
+	failedResponseLogPrefix = "sms:logrs:"
+)
+
+// promoteOutboxScript devolve à fila os eventos cuja nova tentativa já venceu.
+// KEYS[1]: retry; KEYS[2]: fila. ARGV[1]: agora (ms); ARGV[2]: máximo de eventos.
+var promoteOutboxScript = radix.NewEvalScript(2, `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, event in ipairs(due) do
+	redis.call('ZREM', KEYS[1], event)
+	redis.call('LPUSH', KEYS[2], event)
+end
+return #due
+`)
+
+// drainFailedLogScript troca o registro de sms:logrq:/sms:logrs: pelo evento na fila, numa única operação.
+// KEYS[1]: registro; KEYS[2]: fila. ARGV[1]: evento.
+var drainFailedLogScript = radix.NewEvalScript(2, `
+redis.call('LPUSH', KEYS[2], ARGV[1])
+return redis.call('DEL', KEYS[1])
+`)
+
+// OutboxEvent é um registro do histórico a entregar ao logmachine
+type OutboxEvent struct {
+	Id       string                 `json:"id"`
+	Entity   string                 `json:"entity"`
+	Content  map[string]interface{} `json:"content"`
+	Attempts int                    `json:"attempts"`
+	Created  string                 `json:"created"`
+}
+
+func newOutboxEvent(entity string, idPedidoEnvio string, content map[string]interface{}) OutboxEvent {
+	return OutboxEvent{Id: entity + ":" + idPedidoEnvio, Entity: entity, Content: content, Created: time.Now().Format(time.RFC3339)}
+}
+
+func newRequestLogEvent(requestData RequestData) OutboxEvent {
+	return newOutboxEvent(entityRequestLog, requestData.IdPedidoEnvio, map[string]interface{}{
+		"id":                   requestData.IdPedidoEnvio,
+		"bandeira_id":          requestData.Bandeira,
+		"telefone":             requestData.PhoneNumber,
+		"data_hora_requisicao": requestData.TimestampSend,
+		"identificador_sms":    requestData.SmsId,
+	})
+}
+
+func newResponseLogEvent(responseData ResponseData) OutboxEvent {
+	codigoValidacao, _ := strconv.Atoi(responseData.ValidationCode)
+	return newOutboxEvent(entityResponseLog, responseData.IdPedidoEnvio, map[string]interface{}{
+		"id":                    responseData.IdPedidoEnvio,
+		"codigo_validacao":      codigoValidacao,
+		"data_hora_confirmacao": responseData.TimestampReceive,
+	})
+}
+
+// enqueueOutboxEvent grava o evento na fila. Sem o armazenamento, o evento só fica no log.
+func enqueueOutboxEvent(event OutboxEvent) {
+	bts, err := json.Marshal(event)
+	if err == nil {
+		err = repo.PushOutboxEvent(string(bts))
+	}
+	if err != nil {
+		util.LogE(fmt.Sprintf("enqueueOutboxEvent: %s: %s %v", err.Error(), event.Id, event.Content))
+	}
+}
+
+// PromoteOutboxRetries devolve à fila os eventos cuja nova tentativa venceu e retorna quantos foram
+func PromoteOutboxRetries() (int, error) {
+	return repo.PromoteOutboxRetries(time.Now().UnixNano()/int64(time.Millisecond), outboxPromoteBatch)
+}
+
+// PopOutboxEvent retira o próximo evento da fila, aguardando até timeoutSeconds. Vazio se não houver.
+func PopOutboxEvent(timeoutSeconds int) (string, error) {
+	return repo.PopOutboxEvent(timeoutSeconds)
+}
+
+// OutboxLength retorna os eventos na fila, aguardando nova tentativa e descartados
+func OutboxLength() (queued int64, retrying int64, dead int64, err error) {
+	return repo.OutboxLength()
+}
+
+// outboxBackoff é a espera antes da tentativa seguinte: dobra a cada falha, até RetryMaxSeconds
+func outboxBackoff(attempts int) time.Duration {
+	cfg := util.AppCfg.OutboxOptions
+	base := cfg.RetryBaseSeconds
+	if base <= 0 {
+		base = util.DefaultOutboxRetryBaseSeconds
+	}
+	maxSeconds := cfg.RetryMaxSeconds
+	if maxSeconds <= 0 {
+		maxSeconds = util.DefaultOutboxRetryMaxSeconds
+	}
+	delay := base
+	for i := 1; (i < attempts) && (delay < maxSeconds); i++ {
+		delay *= 2
+	}
+	if delay > maxSeconds {
+		delay = maxSeconds
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// ProcessOutboxEvent entrega o evento retirado da fila. Na falha, agenda nova tentativa com backoff exponencial
+// ou, esgotadas as tentativas (Outbox.MaxAttempts), move o evento para sms:outbox:dead.
+func ProcessOutboxEvent(raw string) {
+	var event OutboxEvent
+	if err := json.Unmarshal([]byte(raw), &event); err != nil {
+		util.LogE("ProcessOutboxEvent: evento inválido: " + raw)
+		if err = repo.DeadLetterOutboxEvent(raw, raw); err != nil {
+			util.LogE("ProcessOutboxEvent: " + err.Error())
+		}
+		return
+	}
+	err := deliverOutboxEvent(event)
+	if err == nil {
+		if err = repo.AckOutboxEvent(raw); err != nil {
+			util.LogE("ProcessOutboxEvent.ack: " + err.Error())
+		}
+		return
+	}
+	event.Attempts++
+	bts, _ := json.Marshal(event)
+	maxAttempts := util.AppCfg.OutboxOptions.MaxAttempts
+	if (maxAttempts > 0) && (event.Attempts >= maxAttempts) {
+		util.LogE(fmt.Sprintf("ProcessOutboxEvent: %s descartado após %d tentativas: %s", event.Id, event.Attempts, err.Error()))
+		err = repo.DeadLetterOutboxEvent(raw, string(bts))
+	} else {
+		delay := outboxBackoff(event.Attempts)
+		util.LogW(fmt.Sprintf("ProcessOutboxEvent: %s falhou (tentativa %d), nova tentativa em %s: %s", event.Id, event.Attempts, delay, err.Error()))
+		err = repo.ScheduleOutboxRetry(raw, string(bts), time.Now().Add(delay).UnixNano()/int64(time.Millisecond))
+	}
+	if err != nil {
+		util.LogE("ProcessOutboxEvent: " + err.Error())
+	}
+}
+
+// deliverOutboxEvent grava o evento no logmachine (LogMachine + /save)
+func deliverOutboxEvent(event OutboxEvent) error {
+	jsonBytes, err := json.Marshal([]map[string]interface{}{{"entity": event.Entity, "content": event.Content}})
+	if err != nil {
+		return err
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(util.AppCfg.LogMachine + logServiceSaveMethod)
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType("application/json")
+	req.SetBody(jsonBytes)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	client := &fasthttp.Client{}
+	if err = client.DoTimeout(req, resp, outboxPostTimeout); err != nil {
+		return err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return fmt.Errorf("logmachine respondeu %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// DrainFailedLogs move para a fila os registros que não foram entregues antes da outbox existir
+// (sms:logrq:<aa:mm>:<bandeira>:<sq> e sms:logrs:...) e retorna quantos foram movidos
+func DrainFailedLogs() (int, error) {
+	drained := 0
+	for _, prefix := range []string{failedRequestLogPrefix, failedResponseLogPrefix} {
+		keys, err := repo.ScanKeys(prefix + "*")
+		if err != nil {
+			return drained, err
+		}
+		for _, key := range keys {
+			fields, err := repo.ReadFailedLog(key)
+			if err != nil {
+				return drained, err
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			var event OutboxEvent
+			if prefix == failedRequestLogPrefix {
+				reqData := RequestData{IdPedidoEnvio: fields["idp"], PhoneNumber: fields["pn"], SmsId: fields["si"], TimestampSend: fields["tsnd"]}
+				//A bandeira só está na chave
+				if parts := strings.Split(strings.TrimPrefix(key, prefix), ":"); len(parts) == 4 {
+					reqData.Bandeira = parts[2]
+				}
+				event = newRequestLogEvent(reqData)
+			} else {
+				event = newResponseLogEvent(ResponseData{IdPedidoEnvio: fields["idp"], ValidationCode: fields["cv"], TimestampReceive: fields["trcv"]})
+			}
+			bts, _ := json.Marshal(event)
+			if err = repo.DrainFailedLog(key, string(bts)); err != nil {
+				return drained, err
+			}
+			drained++
+		}
+	}
+	return drained, nil
+}
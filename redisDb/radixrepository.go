@@ -0,0 +1,576 @@
+package redisDb
+
+import (
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/metrics"
+	"github.com/mediocregopher/radix/v3"
+	"strconv"
+	"time"
+)
+
+const scanCount = 500 //Chaves por iteração do SCAN e por pipeline nos relatórios
+
+type radixRepository struct {
+	client *radix.Pool
+}
+
+// NewRadixRepository cria o armazenamento no Redis sobre um pool já conectado (ver ConnectToRedis)
+func NewRadixRepository(client *radix.Pool) Repository {
+	return &radixRepository{client}
+}
+
+// do executa a ação e registra a duração por operação do repositório (metrics.RedisDuration). Os comandos
+// bloqueantes (BRPOP, BRPOPLPUSH) usam o client diretamente para não distorcer a latência.
+func (r *radixRepository) do(operation string, action radix.Action) error {
+	start := time.Now()
+	err := r.client.Do(action)
+	metrics.RedisDuration.ObserveSince(start, operation, metrics.Result(err))
+	return err
+}
+
+func (r *radixRepository) ReadRequest(key string) (*RequestData, error) {
+	var result []string
+	err := r.do("ReadRequest", radix.Cmd(&result, "HMGET", key, "idp", "sq", "si", "tsnd", "pv", "fa", "lk"))
+	if err != nil {
+		return nil, err
+	}
+	reqData := RequestData{Key: key, IdPedidoEnvio: result[0], Sq: result[1], SmsId: result[2], TimestampSend: result[3], Provider: result[4]}
+	reqData.FailedAttempts, _ = strconv.Atoi(result[5])
+	reqData.Locked = result[6] == "1"
+	return &reqData, nil
+}
+
+func (r *radixRepository) SaveRequest(reqData RequestData, ttlSeconds int64) error {
+	err := r.do("SaveRequest", radix.Cmd(nil, "HMSET", reqData.Key, "idp", reqData.IdPedidoEnvio, "sq", reqData.Sq, "si", reqData.SmsId, "tsnd", reqData.TimestampSend, "pv", reqData.Provider))
+	if err != nil {
+		return err
+	}
+	return r.do("SaveRequest", radix.Cmd(nil, "EXPIRE", reqData.Key, fmt.Sprintf("%d", ttlSeconds)))
+}
+
+func (r *radixRepository) DiscardRequest(key string) error {
+	return r.do("DiscardRequest", radix.Cmd(nil, "HDEL", key, "idp", "si", "sq", "tsnd", "pv"))
+}
+
+func (r *radixRepository) IncrementRequestField(key string, field string) (result int, err error) {
+	err = r.do("IncrementRequestField", radix.Cmd(&result, "HINCRBY", key, field, "1"))
+	return result, err
+}
+
+func (r *radixRepository) LockRequest(key string) error {
+	return r.do("LockRequest", radix.Cmd(nil, "HSET", key, "lk", "1"))
+}
+
+func (r *radixRepository) ResetVerifyAttempts(key string) error {
+	return r.do("ResetVerifyAttempts", radix.Cmd(nil, "HDEL", key, "fa", "lk"))
+}
+
+func (r *radixRepository) ClaimVerifyAttempt(key string, maxAttempts int) (VerifyAttempt, error) {
+	var result []int
+	err := r.do("ClaimVerifyAttempt", claimVerifyAttemptScript.Cmd(&result, key, strconv.Itoa(maxAttempts)))
+	if err != nil {
+		return VerifyAttempt{}, err
+	}
+	if len(result) < 2 {
+		return VerifyAttempt{}, fmt.Errorf("ClaimVerifyAttempt: resposta inesperada do script: %v", result)
+	}
+	return VerifyAttempt{Attempts: result[0], Locked: result[1] == 1}, nil
+}
+
+func (r *radixRepository) RefundVerifyAttempt(key string) error {
+	return r.do("RefundVerifyAttempt", refundVerifyAttemptScript.Cmd(nil, key))
+}
+
+func (r *radixRepository) ThrottleRequest(key string, now int64, cooldownSeconds int, maxTries int, windowSeconds int) (ThrottleResult, error) {
+	var result []int
+	err := r.do("ThrottleRequest", throttleScript.Cmd(&result, key, strconv.FormatInt(now, 10), strconv.Itoa(cooldownSeconds), strconv.Itoa(maxTries), strconv.Itoa(windowSeconds)))
+	if err != nil {
+		return ThrottleResult{}, err
+	}
+	if len(result) < 3 {
+		return ThrottleResult{}, fmt.Errorf("ThrottleRequest: resposta inesperada do script: %v", result)
+	}
+	return ThrottleResult{Allowed: result[0] == 1, WaitSeconds: result[1], LimitReached: result[2] == 1}, nil
+}
+
+func (r *radixRepository) ResetRequestTries(key string) error {
+	return r.do("ResetRequestTries", radix.Cmd(nil, "HDEL", key, "tc", "tcts"))
+}
+
+func (r *radixRepository) CheckRateLimits(rules []RateLimitRule, nowMillis int64, member string) (int, int, error) {
+	keysAndArgs := make([]string, 0, 3*len(rules)+2)
+	for _, rule := range rules {
+		keysAndArgs = append(keysAndArgs, rule.Key)
+	}
+	keysAndArgs = append(keysAndArgs, strconv.FormatInt(nowMillis, 10), member)
+	for _, rule := range rules {
+		keysAndArgs = append(keysAndArgs, strconv.Itoa(rule.WindowSeconds*1000), strconv.Itoa(rule.Limit))
+	}
+	var result []int
+	err := r.do("CheckRateLimits", radix.NewEvalScript(len(rules), slidingWindowScript).Cmd(&result, keysAndArgs...))
+	if (err != nil) || (len(result) < 2) {
+		return -1, 0, err
+	}
+	return result[0] - 1, result[1], nil
+}
+
+func (r *radixRepository) ReleaseRateLimits(rules []RateLimitRule, member string) error {
+	cmds := make([]radix.CmdAction, 0, len(rules))
+	for _, rule := range rules {
+		cmds = append(cmds, radix.Cmd(nil, "ZREM", rule.Key, member))
+	}
+	return r.do("ReleaseRateLimits", radix.Pipeline(cmds...))
+}
+
+func (r *radixRepository) IncrementSpend(key string, amount int64, ttlSeconds int64) error {
+	pipe := radix.Pipeline(
+		radix.Cmd(nil, "INCRBY", key, strconv.FormatInt(amount, 10)),
+		radix.Cmd(nil, "EXPIRE", key, fmt.Sprintf("%d", ttlSeconds)),
+	)
+	return r.do("IncrementSpend", pipe)
+}
+
+func (r *radixRepository) ReadSpend(key string) (result int64, err error) {
+	err = r.do("ReadSpend", radix.Cmd(&result, "GET", key))
+	return result, err
+}
+
+func (r *radixRepository) IncrementFraudCounter(key string, ttlSeconds int64) (int, error) {
+	var result int
+	err := r.do("IncrementFraudCounter", fraudCounterScript.Cmd(&result, key, strconv.FormatInt(ttlSeconds, 10)))
+	return result, err
+}
+
+func (r *radixRepository) PushRecentFraudNumber(key string, phoneNumber string, size int, ttlSeconds int64) ([]string, error) {
+	var recent []string
+	pipe := radix.Pipeline(
+		radix.Cmd(&recent, "LRANGE", key, "0", "-1"),
+		radix.Cmd(nil, "LPUSH", key, phoneNumber),
+		radix.Cmd(nil, "LTRIM", key, "0", strconv.Itoa(size-1)),
+		radix.Cmd(nil, "EXPIRE", key, fmt.Sprintf("%d", ttlSeconds)),
+	)
+	return recent, r.do("PushRecentFraudNumber", pipe)
+}
+
+func (r *radixRepository) IncrementFraudConversion(key string, field string, ttlSeconds int64) error {
+	pipe := radix.Pipeline(
+		radix.Cmd(nil, "HINCRBY", key, field, "1"),
+		radix.Cmd(nil, "EXPIRE", key, fmt.Sprintf("%d", ttlSeconds)),
+	)
+	return r.do("IncrementFraudConversion", pipe)
+}
+
+func (r *radixRepository) ReadFraudConversion(key string) (sent int, verified int, err error) {
+	var result []string
+	err = r.do("ReadFraudConversion", radix.Cmd(&result, "HMGET", key, "snt", "vrf"))
+	if err != nil {
+		return 0, 0, err
+	}
+	sent, _ = strconv.Atoi(result[0])
+	verified, _ = strconv.Atoi(result[1])
+	return sent, verified, nil
+}
+
+func (r *radixRepository) AddFraudListEntry(key string, entry string) error {
+	return r.do("AddFraudListEntry", radix.Cmd(nil, "SADD", key, entry))
+}
+
+func (r *radixRepository) RemoveFraudListEntry(key string, entry string) error {
+	return r.do("RemoveFraudListEntry", radix.Cmd(nil, "SREM", key, entry))
+}
+
+func (r *radixRepository) ReadFraudList(key string) (result []string, err error) {
+	err = r.do("ReadFraudList", radix.Cmd(&result, "SMEMBERS", key))
+	return result, err
+}
+
+func (r *radixRepository) SaveFraudReview(phoneNumber string, review string) error {
+	return r.do("SaveFraudReview", radix.Cmd(nil, "HSET", fraudReviewKey, phoneNumber, review))
+}
+
+func (r *radixRepository) DeleteFraudReview(phoneNumber string) error {
+	return r.do("DeleteFraudReview", radix.Cmd(nil, "HDEL", fraudReviewKey, phoneNumber))
+}
+
+func (r *radixRepository) ReadFraudReviews() (result map[string]string, err error) {
+	err = r.do("ReadFraudReviews", radix.Cmd(&result, "HGETALL", fraudReviewKey))
+	return result, err
+}
+
+func (r *radixRepository) SaveResponse(respData ResponseData) error {
+	args := []string{respData.Key, "idp", respData.IdPedidoEnvio, "pn", respData.PhoneNumber, "si", respData.SmsId, "tsnd", respData.TimestampSend, "pv", respData.Provider}
+	if respData.TimestampReceive != "" {
+		args = append(args, "vc", respData.ValidationCode, "trcv", respData.TimestampReceive)
+	}
+	return r.do("SaveResponse", radix.Cmd(nil, "HMSET", args...))
+}
+
+func (r *radixRepository) SaveTempToken(smsId string, phoneNumber string, validationCode string, ttlSeconds int64) error {
+	pipe := radix.Pipeline(
+		radix.Cmd(nil, "HMSET", smsId, "pn", phoneNumber, "vc", validationCode),
+		radix.Cmd(nil, "EXPIRE", smsId, fmt.Sprintf("%d", ttlSeconds)),
+	)
+	return r.do("SaveTempToken", pipe)
+}
+
+func (r *radixRepository) FindTempToken(smsId string) (phoneNumber string, validationCode string, err error) {
+	var result []string
+	err = r.do("FindTempToken", radix.Cmd(&result, "HMGET", smsId, "pn", "vc"))
+	if err != nil {
+		return "", "", err
+	}
+	return result[0], result[1], nil
+}
+
+func (r *radixRepository) TempTokenTTL(smsId string) (ttl int, err error) {
+	err = r.do("TempTokenTTL", radix.Cmd(&ttl, "TTL", smsId))
+	return ttl, err
+}
+
+func (r *radixRepository) NextSequence(name string) (result int64, err error) {
+	err = r.do("NextSequence", radix.Cmd(&result, "INCR", name))
+	return result, err
+}
+
+func (r *radixRepository) IncrementBilling(key string) (result int64, err error) {
+	err = r.do("IncrementBilling", radix.Cmd(&result, "INCR", key))
+	return result, err
+}
+
+func (r *radixRepository) ReadBilling(key string) (result int64, err error) {
+	err = r.do("ReadBilling", radix.Cmd(&result, "GET", key))
+	return result, err
+}
+
+func (r *radixRepository) IncrementUsage(key string, increments map[string]int64) error {
+	cmds := make([]radix.CmdAction, 0, len(increments))
+	for field, increment := range increments {
+		cmds = append(cmds, radix.Cmd(nil, "HINCRBY", key, field, strconv.FormatInt(increment, 10)))
+	}
+	return r.do("IncrementUsage", radix.Pipeline(cmds...))
+}
+
+func (r *radixRepository) ReadUsage(key string) (map[string]int64, error) {
+	var values map[string]string
+	if err := r.do("ReadUsage", radix.Cmd(&values, "HGETALL", key)); err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(values))
+	for field, value := range values {
+		result[field], _ = strconv.ParseInt(value, 10, 64)
+	}
+	return result, nil
+}
+
+func (r *radixRepository) ScanKeys(pattern string) ([]string, error) {
+	var keys []string
+	var key string
+	scanner := radix.NewScanner(r.client, radix.ScanOpts{Command: "SCAN", Pattern: pattern, Count: scanCount})
+	for scanner.Next(&key) {
+		keys = append(keys, key)
+	}
+	return keys, scanner.Close()
+}
+
+func (r *radixRepository) CountVerifiedResponses(pattern string, groupBy func(provider string, phoneNumber string) string) (map[string]int64, error) {
+	keys, err := r.ScanKeys(pattern)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]int64{}
+	for start := 0; start < len(keys); start += scanCount {
+		end := start + scanCount
+		if end > len(keys) {
+			end = len(keys)
+		}
+		fields := make([][]string, end-start)
+		cmds := make([]radix.CmdAction, end-start)
+		for i, key := range keys[start:end] {
+			cmds[i] = radix.Cmd(&fields[i], "HMGET", key, "pv", "pn", "trcv")
+		}
+		if err = r.do("CountVerifiedResponses", radix.Pipeline(cmds...)); err != nil {
+			return nil, err
+		}
+		for _, values := range fields {
+			if (len(values) == 3) && (values[2] != "") {
+				result[groupBy(values[0], values[1])]++
+			}
+		}
+	}
+	return result, nil
+}
+
+func (r *radixRepository) PushOutboxEvent(event string) error {
+	return r.do("PushOutboxEvent", radix.Cmd(nil, "LPUSH", outboxQueueKey, event))
+}
+
+func (r *radixRepository) PopOutboxEvent(timeoutSeconds int) (string, error) {
+	var event string
+	mn := radix.MaybeNil{Rcv: &event}
+	err := r.client.Do(radix.Cmd(&mn, "BRPOPLPUSH", outboxQueueKey, getOutboxRunningKey(InstanceId), strconv.Itoa(timeoutSeconds)))
+	return event, err
+}
+
+func (r *radixRepository) AckOutboxEvent(event string) error {
+	return r.do("AckOutboxEvent", radix.Cmd(nil, "LREM", getOutboxRunningKey(InstanceId), "1", event))
+}
+
+func (r *radixRepository) ScheduleOutboxRetry(event string, retried string, atMillis int64) error {
+	return r.do("ScheduleOutboxRetry", radix.Pipeline(
+		radix.Cmd(nil, "ZADD", outboxRetryKey, strconv.FormatInt(atMillis, 10), retried),
+		radix.Cmd(nil, "LREM", getOutboxRunningKey(InstanceId), "1", event),
+	))
+}
+
+func (r *radixRepository) DeadLetterOutboxEvent(event string, dead string) error {
+	return r.do("DeadLetterOutboxEvent", radix.Pipeline(
+		radix.Cmd(nil, "LPUSH", outboxDeadKey, dead),
+		radix.Cmd(nil, "LREM", getOutboxRunningKey(InstanceId), "1", event),
+	))
+}
+
+func (r *radixRepository) PromoteOutboxRetries(nowMillis int64, limit int) (promoted int, err error) {
+	err = r.do("PromoteOutboxRetries", promoteOutboxScript.Cmd(&promoted, outboxRetryKey, outboxQueueKey, strconv.FormatInt(nowMillis, 10), strconv.Itoa(limit)))
+	return promoted, err
+}
+
+func (r *radixRepository) RequeueRunningOutboxEvents(instanceId string) error {
+	for {
+		var event string
+		mn := radix.MaybeNil{Rcv: &event}
+		if err := r.do("RequeueRunningOutboxEvents", radix.Cmd(&mn, "RPOPLPUSH", getOutboxRunningKey(instanceId), outboxQueueKey)); (err != nil) || mn.Nil {
+			return err
+		}
+	}
+}
+
+func (r *radixRepository) OutboxLength() (queued int64, retrying int64, dead int64, err error) {
+	err = r.do("OutboxLength", radix.Pipeline(
+		radix.Cmd(&queued, "LLEN", outboxQueueKey),
+		radix.Cmd(&retrying, "ZCARD", outboxRetryKey),
+		radix.Cmd(&dead, "LLEN", outboxDeadKey),
+	))
+	return queued, retrying, dead, err
+}
+
+func (r *radixRepository) ReadFailedLog(key string) (result map[string]string, err error) {
+	err = r.do("ReadFailedLog", radix.Cmd(&result, "HGETALL", key))
+	return result, err
+}
+
+func (r *radixRepository) DrainFailedLog(key string, event string) error {
+	return r.do("DrainFailedLog", drainFailedLogScript.Cmd(nil, key, outboxQueueKey, event))
+}
+
+func (r *radixRepository) SaveOtp(key string, otpData OtpData, ttlSeconds int) error {
+	pipe := radix.Pipeline(
+		radix.Cmd(nil, "DEL", key),
+		radix.Cmd(nil, "HMSET", key, "idp", otpData.IdPedidoEnvio, "h", otpData.Hash, "s", otpData.Salt, "exp", otpData.ExpiresAt.Format(time.RFC3339)),
+		radix.Cmd(nil, "EXPIRE", key, strconv.Itoa(ttlSeconds)),
+	)
+	return r.do("SaveOtp", pipe)
+}
+
+func (r *radixRepository) ReadOtp(key string) (*OtpData, error) {
+	var result []string
+	err := r.do("ReadOtp", radix.Cmd(&result, "HMGET", key, "idp", "h", "s", "exp"))
+	if (err != nil) || (result[1] == "") {
+		return nil, err
+	}
+	expiresAt, err := time.Parse(time.RFC3339, result[3])
+	if err != nil {
+		return nil, err
+	}
+	return &OtpData{IdPedidoEnvio: result[0], Hash: result[1], Salt: result[2], ExpiresAt: expiresAt}, nil
+}
+
+func (r *radixRepository) DeleteOtp(key string) error {
+	return r.do("DeleteOtp", radix.Cmd(nil, "DEL", key))
+}
+
+func (r *radixRepository) SaveMessage(msgData MessageData, ttlSeconds int64) error {
+	key := getMessageKey(msgData.SmsId)
+	histKey := getMessageHistoryKey(&msgData.PhoneNumber, &msgData.Bandeira)
+	sTTL := fmt.Sprintf("%d", ttlSeconds)
+	pipe := radix.Pipeline(
+		radix.Cmd(nil, "HMSET", key, "pv", msgData.Provider, "pn", msgData.PhoneNumber, "bd", msgData.Bandeira, "tsnd", msgData.TimestampSend, "st", msgData.Status, "stts", msgData.TimestampStatus),
+		radix.Cmd(nil, "EXPIRE", key, sTTL),
+		radix.Cmd(nil, "LPUSH", histKey, msgData.SmsId),
+		radix.Cmd(nil, "LTRIM", histKey, "0", strconv.Itoa(messageHistorySize-1)),
+		radix.Cmd(nil, "EXPIRE", histKey, sTTL),
+	)
+	return r.do("SaveMessage", pipe)
+}
+
+func (r *radixRepository) ReadMessage(smsId string) (*MessageData, error) {
+	var result []string
+	err := r.do("ReadMessage", radix.Cmd(&result, "HMGET", getMessageKey(smsId), "pv", "pn", "bd", "tsnd", "st", "pst", "stts", "va", "tvrf"))
+	if (err != nil) || (result[0] == "") {
+		return nil, err
+	}
+	msgData := MessageData{SmsId: smsId, Provider: result[0], PhoneNumber: result[1], Bandeira: result[2], TimestampSend: result[3],
+		Status: result[4], ProviderStatus: result[5], TimestampStatus: result[6], TimestampVerify: result[8]}
+	msgData.VerifyAttempts, _ = strconv.Atoi(result[7])
+	return &msgData, nil
+}
+
+func (r *radixRepository) ReadMessageHistory(phoneNumber string, bandeira string) (result []string, err error) {
+	err = r.do("ReadMessageHistory", radix.Cmd(&result, "LRANGE", getMessageHistoryKey(&phoneNumber, &bandeira), "0", "-1"))
+	return result, err
+}
+
+func (r *radixRepository) UpdateMessageStatus(smsId string, status string, providerStatus string, tsStatus string) error {
+	return r.do("UpdateMessageStatus", radix.Cmd(nil, "HMSET", getMessageKey(smsId), "st", status, "pst", providerStatus, "stts", tsStatus))
+}
+
+// messageExists evita recriar, sem validade, a mensagem que já expirou
+func (r *radixRepository) messageExists(key string) bool {
+	var exists int
+	return (r.do("messageExists", radix.Cmd(&exists, "EXISTS", key)) == nil) && (exists == 1)
+}
+
+func (r *radixRepository) IncrementMessageVerifyAttempts(smsId string) error {
+	key := getMessageKey(smsId)
+	if !r.messageExists(key) {
+		return nil
+	}
+	return r.do("IncrementMessageVerifyAttempts", radix.Cmd(nil, "HINCRBY", key, "va", "1"))
+}
+
+func (r *radixRepository) SetMessageVerified(smsId string, tsVerify string) error {
+	key := getMessageKey(smsId)
+	if !r.messageExists(key) {
+		return nil
+	}
+	return r.do("SetMessageVerified", radix.Cmd(nil, "HSET", key, "tvrf", tsVerify))
+}
+
+func (r *radixRepository) CreateBatchJob(job BatchJob, recipients []string, ttlSeconds int64) error {
+	key := getBatchJobKey(job.Id)
+	rcptKey := getBatchRecipientsKey(job.Id)
+	sTTL := fmt.Sprintf("%d", ttlSeconds)
+	rpushArgs := append([]string{rcptKey}, recipients...)
+	pipe := radix.Pipeline(
+		radix.Cmd(nil, "HMSET", key, "bd", job.Bandeira, "tpl", job.Template, "ip", job.ClientIp, "st", job.Status, "tot", strconv.Itoa(job.Total), "snt", "0", "fl", "0", "crt", job.Created),
+		radix.Cmd(nil, "RPUSH", rpushArgs...),
+		radix.Cmd(nil, "EXPIRE", key, sTTL),
+		radix.Cmd(nil, "EXPIRE", rcptKey, sTTL),
+		radix.Cmd(nil, "LPUSH", batchQueueKey, job.Id),
+	)
+	return r.do("CreateBatchJob", pipe)
+}
+
+func (r *radixRepository) PopBatchJob(timeoutSeconds int) (string, error) {
+	var id string
+	mn := radix.MaybeNil{Rcv: &id}
+	err := r.client.Do(radix.Cmd(&mn, "BRPOPLPUSH", batchQueueKey, getBatchRunningKey(InstanceId), strconv.Itoa(timeoutSeconds)))
+	if (err != nil) || mn.Nil {
+		return "", err
+	}
+	return id, r.client.Do(radix.Cmd(nil, "HSET", getBatchJobKey(id), "st", BatchRunning))
+}
+
+func (r *radixRepository) RequeueRunningBatchJobs(instanceId string) error {
+	for {
+		var id string
+		mn := radix.MaybeNil{Rcv: &id}
+		if err := r.do("RequeueRunningBatchJobs", radix.Cmd(&mn, "RPOPLPUSH", getBatchRunningKey(instanceId), batchQueueKey)); (err != nil) || mn.Nil {
+			return err
+		}
+		if err := r.do("RequeueRunningBatchJobs", radix.Cmd(nil, "HSET", getBatchJobKey(id), "st", BatchQueued)); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *radixRepository) FinishBatchJob(id string, tsFinish string) error {
+	pipe := radix.Pipeline(
+		radix.Cmd(nil, "HMSET", getBatchJobKey(id), "st", BatchDone, "fin", tsFinish),
+		radix.Cmd(nil, "LREM", getBatchRunningKey(InstanceId), "1", id),
+	)
+	return r.do("FinishBatchJob", pipe)
+}
+
+func (r *radixRepository) ReadBatchJob(id string) (*BatchJob, error) {
+	var result []string
+	err := r.do("ReadBatchJob", radix.Cmd(&result, "HMGET", getBatchJobKey(id), "bd", "tpl", "st", "tot", "snt", "fl", "crt", "fin", "ip"))
+	if (err != nil) || (result[2] == "") {
+		return nil, err
+	}
+	job := BatchJob{Id: id, Bandeira: result[0], Template: result[1], Status: result[2], Created: result[6], Finished: result[7], ClientIp: result[8]}
+	job.Total, _ = strconv.Atoi(result[3])
+	job.Sent, _ = strconv.Atoi(result[4])
+	job.Failed, _ = strconv.Atoi(result[5])
+	return &job, nil
+}
+
+func (r *radixRepository) ReadBatchRecipients(id string) (result []string, err error) {
+	err = r.do("ReadBatchRecipients", radix.Cmd(&result, "LRANGE", getBatchRecipientsKey(id), "0", "-1"))
+	return result, err
+}
+
+func (r *radixRepository) SaveBatchResult(id string, recipient string, result string, success bool, ttlSeconds int64) error {
+	var isNew int
+	resKey := getBatchResultsKey(id)
+	err := r.do("SaveBatchResult", radix.Cmd(&isNew, "HSETNX", resKey, recipient, result))
+	if (err != nil) || (isNew == 0) {
+		return err
+	}
+	counter := "fl"
+	if success {
+		counter = "snt"
+	}
+	pipe := radix.Pipeline(
+		radix.Cmd(nil, "HINCRBY", getBatchJobKey(id), counter, "1"),
+		radix.Cmd(nil, "EXPIRE", resKey, fmt.Sprintf("%d", ttlSeconds)),
+	)
+	return r.do("SaveBatchResult", pipe)
+}
+
+func (r *radixRepository) ReadBatchResults(id string) (result map[string]string, err error) {
+	err = r.do("ReadBatchResults", radix.Cmd(&result, "HGETALL", getBatchResultsKey(id)))
+	return result, err
+}
+
+func (r *radixRepository) RenewInstance(id string, ttlSeconds int64) error {
+	return r.do("RenewInstance", radix.Pipeline(
+		radix.Cmd(nil, "SET", getInstanceKey(id), "1", "EX", strconv.FormatInt(ttlSeconds, 10)),
+		radix.Cmd(nil, "SADD", instancesKey, id),
+	))
+}
+
+func (r *radixRepository) ExpireInstance(id string) error {
+	return r.do("ExpireInstance", radix.Cmd(nil, "DEL", getInstanceKey(id)))
+}
+
+func (r *radixRepository) ReadInstances() (map[string]bool, error) {
+	var ids []string
+	if err := r.do("ReadInstances", radix.Cmd(&ids, "SMEMBERS", instancesKey)); (err != nil) || (len(ids) == 0) {
+		return nil, err
+	}
+	alive := make([]int, len(ids))
+	cmds := make([]radix.CmdAction, len(ids))
+	for i, id := range ids {
+		cmds[i] = radix.Cmd(&alive[i], "EXISTS", getInstanceKey(id))
+	}
+	if err := r.do("ReadInstances", radix.Pipeline(cmds...)); err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		result[id] = alive[i] == 1
+	}
+	return result, nil
+}
+
+func (r *radixRepository) RemoveInstance(id string) error {
+	return r.do("RemoveInstance", radix.Cmd(nil, "SREM", instancesKey, id))
+}
+
+func (r *radixRepository) Ping() error {
+	return r.do("Ping", radix.Cmd(nil, "PING"))
+}
+
+func (r *radixRepository) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,185 @@
+package redisDb
+
+import (
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/phone"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitRule é uma janela deslizante: no máximo Limit envios em WindowSeconds para a chave
+type RateLimitRule struct {
+	Name          string
+	Key           string
+	WindowSeconds int
+	Limit         int
+}
+
+// slidingWindowScript verifica todas as janelas e só registra o envio se nenhuma estiver esgotada.
+// Cada janela é um sorted set com o horário (ms) de cada envio.
+// KEYS: uma chave por regra; ARGV[1]: agora (ms); ARGV[2]: membro único do envio; depois janela (ms) e limite de cada regra.
+// Retorna {índice da regra esgotada (0 se permitido), ms até liberar}.
+const slidingWindowScript = `
+local now = tonumber(ARGV[1])
+for i, key in ipairs(KEYS) do
+	local window = tonumber(ARGV[1 + 2 * i])
+	local limit = tonumber(ARGV[2 + 2 * i])
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+	if redis.call('ZCARD', key) >= limit then
+		local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+		local wait = window
+		if oldest[2] then
+			wait = tonumber(oldest[2]) + window - now
+		end
+		return {i, wait}
+	end
+end
+for i, key in ipairs(KEYS) do
+	redis.call('ZADD', key, now, ARGV[2])
+	redis.call('PEXPIRE', key, tonumber(ARGV[1 + 2 * i]))
+end
+return {0, 0}
+`
+
+var rateLimitSeq int64
+
+// nextRateLimitMember identifica o envio nas janelas. Dois envios no mesmo ms precisam de membros diferentes.
+func nextRateLimitMember(now time.Time) string {
+	return fmt.Sprintf("%d-%d", now.UnixNano(), atomic.AddInt64(&rateLimitSeq, 1))
+}
+
+// PhonePrefix retorna os primeiros length dígitos do telefone, ignorando "+" e formatação
+func PhonePrefix(phoneNumber string, length int) string {
+	var digits strings.Builder
+	for _, c := range phoneNumber {
+		if (c >= '0') && (c <= '9') {
+			digits.WriteRune(c)
+			if digits.Len() == length {
+				break
+			}
+		}
+	}
+	return digits.String()
+}
+
+// SendRateLimitRules monta as regras ativas em RateLimitOptions para um envio. Os limites da bandeira
+// sobrescrevem PerBandeiraPerHour e PerBandeiraPerDay.
+func SendRateLimitRules(clientIp string, bandeira string, phoneNumber string) []RateLimitRule {
+	cfg := util.AppCfg.RateLimitOptions
+	bandeiraCfg := util.AppCfg.Bandeira(bandeira)
+	if bandeiraCfg.RateLimitPerHour > 0 {
+		cfg.PerBandeiraPerHour = bandeiraCfg.RateLimitPerHour
+	}
+	if bandeiraCfg.RateLimitPerDay > 0 {
+		cfg.PerBandeiraPerDay = bandeiraCfg.RateLimitPerDay
+	}
+	hour := 60 * 60
+	day := 24 * hour
+	var rules []RateLimitRule
+	if (cfg.PerIpPerHour > 0) && (clientIp != "") {
+		rules = append(rules, RateLimitRule{"ip", "sms:rl:ip:" + clientIp, hour, cfg.PerIpPerHour})
+	}
+	if cfg.PerBandeiraPerHour > 0 {
+		rules = append(rules, RateLimitRule{"bandeira/hora", "sms:rl:bd:h:" + bandeira, hour, cfg.PerBandeiraPerHour})
+	}
+	if cfg.PerBandeiraPerDay > 0 {
+		rules = append(rules, RateLimitRule{"bandeira/dia", "sms:rl:bd:d:" + bandeira, day, cfg.PerBandeiraPerDay})
+	}
+	if cfg.PerPrefixPerHour > 0 {
+		if prefix := ConfiguredPhonePrefix(phoneNumber); prefix != "" {
+			rules = append(rules, RateLimitRule{"prefixo", "sms:rl:px:" + prefix, hour, cfg.PerPrefixPerHour})
+		}
+	}
+	if cfg.GlobalPerDay > 0 {
+		rules = append(rules, RateLimitRule{"global/dia", "sms:rl:global", day, cfg.GlobalPerDay})
+	}
+	return rules
+}
+
+const (
+	spendDayFormat = "2006-01-02"
+	spendUnit      = 1000000 //O gasto é gravado em milionésimos da moeda
+	spendTTL       = 2 * 24 * 60 * 60
+)
+
+func getSpendKey(day time.Time) string {
+	return "sms:rl:spend:" + day.Format(spendDayFormat)
+}
+
+// SpendRateLimitRule é a regra do teto de gasto (RateLimit.GlobalSpendPerDay), para o log e as métricas
+var SpendRateLimitRule = RateLimitRule{Name: "gasto/dia", Key: "sms:rl:spend", WindowSeconds: 24 * 60 * 60}
+
+// accountSpend soma ao gasto do dia o custo estimado do SMS enviado: partes × preço do país do telefone na tabela
+// [Prices.<provider>]. Providers sem preço não contam.
+func accountSpend(provider string, phoneNumber string, segments int) {
+	if util.AppCfg.RateLimitOptions.GlobalSpendPerDay <= 0 {
+		return
+	}
+	price := util.AppCfg.Prices[provider].PerSegment(phone.CountryOf(phoneNumber))
+	if price <= 0 {
+		return
+	}
+	if segments <= 0 {
+		segments = 1
+	}
+	amount := int64(math.Round(price * float64(segments) * spendUnit))
+	if err := repo.IncrementSpend(getSpendKey(time.Now()), amount, spendTTL); err != nil {
+		util.LogE("accountSpend: " + err.Error())
+	}
+}
+
+// SpendCeilingReached indica se o gasto estimado do dia, somando todas as bandeiras, atingiu
+// RateLimit.GlobalSpendPerDay e, nesse caso, os segundos até a virada do dia
+func SpendCeilingReached() (bool, int, error) {
+	ceiling := util.AppCfg.RateLimitOptions.GlobalSpendPerDay
+	if ceiling <= 0 {
+		return false, 0, nil
+	}
+	now := time.Now()
+	spent, err := repo.ReadSpend(getSpendKey(now))
+	if (err != nil) || (float64(spent) < ceiling*spendUnit) {
+		return false, 0, err
+	}
+	year, month, day := now.Date()
+	tomorrow := time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+	return true, int(math.Ceil(tomorrow.Sub(now).Seconds())), nil
+}
+
+// RateLimitReservation é o envio registrado nas janelas por CheckRateLimits
+type RateLimitReservation struct {
+	rules  []RateLimitRule
+	member string
+}
+
+// Release retira o envio das janelas. Deve ser chamada quando o envio é recusado depois de CheckRateLimits
+// (antifraude, intervalo do telefone) ou falha no provider, para que só os envios feitos ocupem os limites.
+func (r *RateLimitReservation) Release() {
+	if r == nil {
+		return
+	}
+	if err := repo.ReleaseRateLimits(r.rules, r.member); err != nil {
+		util.LogE("RateLimitReservation.Release: " + err.Error())
+	}
+}
+
+// CheckRateLimits registra o envio em todas as janelas e retorna a reserva ou, se alguma estiver esgotada, retorna
+// a regra e os segundos até ela liberar, sem registrar nada. A reserva é nil se não há regras.
+func CheckRateLimits(rules []RateLimitRule) (*RateLimitRule, int, *RateLimitReservation, error) {
+	if len(rules) == 0 {
+		return nil, 0, nil, nil
+	}
+	now := time.Now()
+	member := nextRateLimitMember(now)
+	exceeded, waitMillis, err := repo.CheckRateLimits(rules, now.UnixNano()/int64(time.Millisecond), member)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if exceeded < 0 {
+		return nil, 0, &RateLimitReservation{rules, member}, nil
+	}
+	waitSeconds := (waitMillis + 999) / 1000
+	return &rules[exceeded], waitSeconds, nil, nil
+}
@@ -1,15 +1,12 @@
 package redisDb
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"gaudium.com.br/gaudiumsoftware/sms/util"
 	"github.com/mediocregopher/radix/v3"
-	"github.com/valyala/fasthttp"
 	"log"
 	"math"
-	"net/http"
 	"strconv"
 	"time"
 )
@@ -25,7 +22,6 @@ const (
 	logServiceSaveMethod = "/save"
 )
 
-var redisClient *radix.Pool
 var errRedis error
 var redisByPass bool
 var requestTTL int64 = 6 * 30 * 24 * 60 * 60 //6 meses - Tempo que o request fica armazenado no Redis
@@ -39,6 +35,10 @@ type RequestData struct {
 	Sq            string
 	SmsId         string
 	TimestampSend string
+	Provider      string
+	//Preenchidos apenas por ReadRequest
+	FailedAttempts int
+	Locked         bool
 }
 
 type ResponseData struct {
@@ -51,14 +51,15 @@ type ResponseData struct {
 	ValidationCode   string
 	TimestampSend    string
 	TimestampReceive string
+	Provider         string
 }
 
-func NewRequestData(key string, idPedidoEnvio string, phoneNumber string, bandeira string, sq string, smsId string, tsSend string) RequestData {
-	return RequestData{key, idPedidoEnvio, phoneNumber, bandeira, sq, smsId, tsSend}
+func NewRequestData(key string, idPedidoEnvio string, phoneNumber string, bandeira string, sq string, smsId string, tsSend string, provider string) RequestData {
+	return RequestData{Key: key, IdPedidoEnvio: idPedidoEnvio, PhoneNumber: phoneNumber, Bandeira: bandeira, Sq: sq, SmsId: smsId, TimestampSend: tsSend, Provider: provider}
 }
 
-func NewResponseData(key string, idPedidoEnvio string, phoneNumber string, bandeira string, sq string, smsId string, validationCode string, tsSend string, tsReceive string) ResponseData {
-	return ResponseData{key, idPedidoEnvio, phoneNumber, bandeira, sq, smsId, validationCode, tsSend, tsReceive}
+func NewResponseData(key string, idPedidoEnvio string, phoneNumber string, bandeira string, sq string, smsId string, validationCode string, tsSend string, tsReceive string, provider string) ResponseData {
+	return ResponseData{key, idPedidoEnvio, phoneNumber, bandeira, sq, smsId, validationCode, tsSend, tsReceive, provider}
 }
 
 func SetupRedisPool() (bool, error) {
@@ -89,221 +90,142 @@ func SetupRedisPool() (bool, error) {
 		redisDialTimeout = util.AppCfg.RedisOptions.RedisDialTimeout
 	}
 
+	if util.AppCfg.RedisOptions.InMemory {
+		util.LogW("SetupRedisPool: usando armazenamento em memória. Os dados se perdem ao reiniciar o serviço")
+		SetRepository(NewMemoryRepository())
+		return false, nil
+	}
+
+	var redisClient *radix.Pool
 	redisByPass, redisClient, errRedis = ConnectToRedis(redisConnectionString, redisPoolSize, false, redisDialTimeout)
 	if errRedis != nil {
 		util.LogConsole(errRedis.Error())
 		util.LogConsole(fmt.Sprintf("SetupRedisPool: server: %s; poolSize: %d; timeout: %d", redisConnectionString, redisPoolSize, redisDialTimeout))
+	} else if redisClient != nil {
+		SetRepository(NewRadixRepository(redisClient))
 	}
 	return redisByPass, errRedis
 }
 
 func NextIdPedido() (result string, err error) {
-	err = redisClient.Do(radix.Cmd(&result, "INCR", "sms:sq:global"))
-	return result, err
+	return NextSQKey("sms:sq:global")
 }
 
-func NextSQField(key *string, incFieldName string) (result string, err error) {
-	err = redisClient.Do(radix.Cmd(&result, "HINCRBY", *key, incFieldName, "1"))
-	return result, err
+func NextSQField(key *string, incFieldName string) (string, error) {
+	value, err := repo.IncrementRequestField(*key, incFieldName)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(value), nil
 }
 
-func NextSQKey(sqName string) (result string, err error) {
-	err = redisClient.Do(radix.Cmd(&result, "INCR", sqName))
-	return result, err
+func NextSQKey(sqName string) (string, error) {
+	value, err := repo.NextSequence(sqName)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(value, 10), nil
 }
 
-func nextBilBandeira(bandeira string) (result string, err error) {
-	yearMonth := time.Now().Format("06:01")
-	sqName := fmt.Sprintf("sms:bil:%s:%s", yearMonth, bandeira)
-	return NextSQKey(sqName)
+func getBillingKey(bandeira string) string {
+	return getBillingMonthKey(time.Now().Format(billingMonthFormat), bandeira)
 }
 
-func getRequestKey(phoneNumber *string, bandeira *string) string {
-	return fmt.Sprintf("sms:rq:%s:%s", *bandeira, *phoneNumber)
+func getBillingMonthKey(month string, bandeira string) string {
+	return fmt.Sprintf("sms:bil:%s:%s", month, bandeira)
 }
 
-func DiscardRequestFields(phoneNumber *string, bandeira *string) {
-	key := getRequestKey(phoneNumber, bandeira)
-	pipe := radix.Pipeline(
-		radix.Cmd(nil, "HDEL", key, "idp"),
-		radix.Cmd(nil, "HDEL", key, "si"),
-		radix.Cmd(nil, "HDEL", key, "sq"),
-		radix.Cmd(nil, "HDEL", key, "tsnd"),
-	)
-	redisClient.Do(pipe)
-}
-
-func AccountSMS(bandeira string) string {
-	sq, err := nextBilBandeira(bandeira)
-	if err == nil {
-		util.LogD(fmt.Sprintf("$m$:%s:%s", bandeira, sq))
-	} else {
-		util.LogE(fmt.Sprintf("$m$error:%s:%s", bandeira, err.Error()))
+func nextBilBandeira(bandeira string) (string, error) {
+	value, err := repo.IncrementBilling(getBillingKey(bandeira))
+	if err != nil {
+		return "", err
 	}
-	return sq
+	return strconv.FormatInt(value, 10), nil
 }
 
-func updateLastRequestTry(key *string) error {
-	ts := time.Now().Format(time.RFC3339)
-	return redisClient.Do(radix.Cmd(nil, "HMSET", *key, "tcts", ts))
+// MonthlyUsage retorna os SMS da bandeira contados no mês corrente (AccountSMS)
+func MonthlyUsage(bandeira string) (int64, error) {
+	return repo.ReadBilling(getBillingKey(bandeira))
 }
 
-func readLastRequestTry(key *string) (*time.Time, error) {
-	var result []string
-	err := redisClient.Do(radix.Cmd(&result, "HMGET", *key, "tcts"))
-	if (err == nil) && (result[0] != "") {
-		timeRead, err1 := time.Parse(time.RFC3339, result[0])
-		return &timeRead, err1
-	}
-	return nil, err
+func getRequestKey(phoneNumber *string, bandeira *string) string {
+	return fmt.Sprintf("sms:rq:%s:%s", *bandeira, *phoneNumber)
 }
 
-func nextRequestTrycCount(key *string) (int, error) {
-	stc, err := NextSQField(key, "tc")
-	if (err == nil) && (stc != "") {
-		return strconv.Atoi(stc)
-	}
-	return 0, err
+func getResponseKey(bandeira string, sq string) string {
+	return fmt.Sprintf("sms:rs:%s:%s:%s", time.Now().Format("06:01"), bandeira, sq)
 }
 
-func resetTryCount(key *string) {
-	pipe := radix.Pipeline(
-		radix.Cmd(nil, "HDEL", *key, "tc"),
-		radix.Cmd(nil, "HDEL", *key, "tcts"),
-	)
-	redisClient.Do(pipe)
+func DiscardRequestFields(phoneNumber *string, bandeira *string) {
+	key := getRequestKey(phoneNumber, bandeira)
+	repo.DiscardRequest(key)
 }
 
-func canRequest(key *string) (bool, error) {
-	var lastRequestTime *time.Time
-	var err error
-
-	lastRequestTime, err = readLastRequestTry(key)
-	if lastRequestTime == nil {
-		lastRequestTime = new(time.Time)
-		//*lastRequestTime = time.Na//Now()
-	}
-	interval := time.Now().Sub(*lastRequestTime)
-	secondsElapsed := int(math.Round(interval.Seconds()))
-	if secondsElapsed < util.DefaultResendWaitSecondsBeforeTriesLimitReached {
-		return false, errors.New("Tente novamente em 1 minuto")
-	}
-
-	tryCount, err := nextRequestTrycCount(key)
-	if err == nil {
-		triesLimitReached := tryCount >= util.AppCfg.SmsOptions.MaxSmsRequestsPerPhone
-		if triesLimitReached {
-			interval = time.Since(*lastRequestTime)
-			minutesElapsed := int(math.Round(interval.Minutes()))
-			waitIntervalReached := minutesElapsed > util.DefaultResendWaitSecondsAfterTriesLimitReached
-			if waitIntervalReached {
-				_ = updateLastRequestTry(key)
-				resetTryCount(key)
-				return true, nil
-			} else {
-				var msg string
-				minutesToWait := util.DefaultResendWaitSecondsAfterTriesLimitReached - minutesElapsed
-				if (minutesToWait) <= 1 {
-					msg = "1 minuto"
-				} else {
-					msg = fmt.Sprintf("%d minutos", minutesToWait)
-				}
-				return false, errors.New("Número máximo de tentativas atingido. Tente novamente em " + msg)
-			}
-		} else {
-			_ = updateLastRequestTry(key)
-			return true, nil
-		}
-	}
-	return false, err
+// ClaimVerifyAttempt reserva uma tentativa de verificação do pedido pendente. O contador é zerado a cada novo pedido.
+func ClaimVerifyAttempt(phoneNumber *string, bandeira *string, maxAttempts int) (VerifyAttempt, error) {
+	return repo.ClaimVerifyAttempt(getRequestKey(phoneNumber, bandeira), maxAttempts)
 }
 
-func logRequest(requestData RequestData) {
-	jsonArray := make([]map[string]interface{}, 0, 0)
-	entity := make(map[string]interface{})
-
-	contentData := make(map[string]interface{})
-	contentData["id"] = requestData.IdPedidoEnvio
-	contentData["bandeira_id"] = requestData.Bandeira
-	contentData["telefone"] = requestData.PhoneNumber
-	contentData["data_hora_requisicao"] = requestData.TimestampSend
-	contentData["identificador_sms"] = requestData.SmsId
-
-	entity["entity"] = "historico_envio_sms"
-	entity["content"] = contentData
-	jsonArray = append(jsonArray, entity)
-	jsonBytes, _ := json.Marshal(jsonArray)
+// RefundVerifyAttempt devolve a tentativa reservada por ClaimVerifyAttempt quando o provider não conseguiu
+// verificar o código (falha de comunicação)
+func RefundVerifyAttempt(phoneNumber *string, bandeira *string) error {
+	return repo.RefundVerifyAttempt(getRequestKey(phoneNumber, bandeira))
+}
 
-	var err error = nil
-	var resp *fasthttp.Response
-	defer func() {
-		if (err != nil) || (resp.StatusCode() != http.StatusOK) {
-			writeRequestLogFailed(&requestData)
-		}
-	}()
+// LockRequest bloqueia a verificação do pedido pendente até que um novo SMS seja solicitado
+func LockRequest(phoneNumber *string, bandeira *string) error {
+	key := getRequestKey(phoneNumber, bandeira)
+	return repo.LockRequest(key)
+}
 
-	req := fasthttp.AcquireRequest()
-	req.SetRequestURI(util.AppCfg.LogMachine + logServiceSaveMethod)
-	req.Header.SetMethod("POST")
-	req.Header.SetContentType("application/json")
-	jsonString := string(jsonBytes)
-	req.SetBodyString(jsonString)
-	resp = fasthttp.AcquireResponse()
-	client := &fasthttp.Client{}
-	err = client.Do(req, resp)
+func resetVerifyAttempts(key *string) {
+	repo.ResetVerifyAttempts(*key)
 }
 
-func writeRequestLogFailed(requestData *RequestData) {
-	timestampSend, _ := time.Parse(time.RFC3339, requestData.TimestampSend)
-	anoMes := timestampSend.Format("06:01")
-	key := fmt.Sprintf("sms:logrq:%s:%s:%s", anoMes, requestData.Bandeira, requestData.Sq)
-	err := redisClient.Do(radix.Cmd(nil, "HMSET", key, "idp", requestData.IdPedidoEnvio, "pn", requestData.PhoneNumber, "si", requestData.SmsId, "tsnd", requestData.TimestampSend))
-	if err != nil {
-		util.LogConsole(err.Error())
+// AccountSMS conta o SMS enviado na cobrança da bandeira e, no detalhe (ver billing.go), o provider, o país do
+// telefone e as partes cobradas
+func AccountSMS(bandeira string, provider string, phoneNumber string, segments int) string {
+	sq, err := nextBilBandeira(bandeira)
+	if err == nil {
+		util.LogD(fmt.Sprintf("$m$:%s:%s", bandeira, sq))
+	} else {
+		util.LogE(fmt.Sprintf("$m$error:%s:%s", bandeira, err.Error()))
 	}
+	accountSent(bandeira, provider, phoneNumber, segments)
+	accountSpend(provider, phoneNumber, segments)
+	return sq
 }
 
-func logResponse(responseData ResponseData) {
-	jsonArray := make([]map[string]interface{}, 0, 0)
-	entity := make(map[string]interface{})
-
-	contentData := make(map[string]interface{})
-	contentData["id"] = responseData.IdPedidoEnvio
-	codigoValidacao, _ := strconv.Atoi(responseData.ValidationCode)
-	contentData["codigo_validacao"] = codigoValidacao
-	contentData["data_hora_confirmacao"] = responseData.TimestampReceive
-
-	entity["entity"] = "historico_confirmacao_sms"
-	entity["content"] = contentData
-	jsonArray = append(jsonArray, entity)
-	jsonBytes, _ := json.Marshal(jsonArray)
-
-	var err error = nil
-	var resp *fasthttp.Response
-	defer func() {
-		if (err != nil) || (resp.StatusCode() != http.StatusOK) {
-			writeResponseLogFailed(&responseData)
-		}
-	}()
-
-	req := fasthttp.AcquireRequest()
-	req.SetRequestURI(util.AppCfg.LogMachine + logServiceSaveMethod)
-	req.Header.SetMethod("POST")
-	req.Header.SetContentType("application/json")
-	req.SetBodyString(string(jsonBytes))
-	resp = fasthttp.AcquireResponse()
-	client := &fasthttp.Client{}
-	err = client.Do(req, resp)
+func resetTryCount(key *string) {
+	repo.ResetRequestTries(*key)
 }
 
-func writeResponseLogFailed(responseData *ResponseData) {
-	timestampSend, _ := time.Parse(time.RFC3339, responseData.TimestampSend)
-	anoMes := timestampSend.Format("06:01")
-	key := fmt.Sprintf("sms:logrs:%s:%s:%s", anoMes, responseData.Bandeira, responseData.Sq)
-	err := redisClient.Do(radix.Cmd(nil, "HMSET", key, "idp", responseData.IdPedidoEnvio, "cv", responseData.ValidationCode, "trcv", responseData.TimestampReceive))
+// canRequest aplica o intervalo mínimo entre envios e o limite de MaxSmsRequestsPerPhone tentativas (o da bandeira,
+// se configurado) numa única operação atômica do armazenamento (ver throttleScript)
+func canRequest(key *string, bandeira string) (bool, error) {
+	windowSeconds := util.DefaultResendWaitSecondsAfterTriesLimitReached * 60
+	maxTries := util.AppCfg.Bandeira(bandeira).MaxSmsRequestsPerPhone
+	if maxTries <= 0 {
+		maxTries = util.AppCfg.SmsOptions.MaxSmsRequestsPerPhone
+	}
+	result, err := repo.ThrottleRequest(*key, time.Now().Unix(), util.DefaultResendWaitSecondsBeforeTriesLimitReached, maxTries, windowSeconds)
 	if err != nil {
-		util.LogConsole(err.Error())
+		return false, err
+	}
+	if result.Allowed {
+		return true, nil
+	}
+	if !result.LimitReached {
+		return false, errors.New("Tente novamente em 1 minuto")
+	}
+	var msg string
+	minutesToWait := int(math.Ceil(float64(result.WaitSeconds) / 60))
+	if minutesToWait <= 1 {
+		msg = "1 minuto"
+	} else {
+		msg = fmt.Sprintf("%d minutos", minutesToWait)
 	}
+	return false, errors.New("Número máximo de tentativas atingido. Tente novamente em " + msg)
 }
 
 func WriteRequest(reqData RequestData) (RequestData, error) {
@@ -312,58 +234,55 @@ func WriteRequest(reqData RequestData) (RequestData, error) {
 	//Só incrementa quando insere
 	isInserting := reqData.Sq == ""
 	if isInserting {
-		canDoRequest, err := canRequest(&key)
+		canDoRequest, err := canRequest(&key, reqData.Bandeira)
 		if !canDoRequest {
 			return reqData, err
 		}
 		sRequestCount, _ := NextSQField(&key, "total")
 		util.LogD("requestCount: " + sRequestCount)
+		resetVerifyAttempts(&key)
 	}
 	idPedido := reqData.IdPedidoEnvio
 	if idPedido == "" {
 		idPedido, _ = NextIdPedido()
 	}
 	ts := time.Now().Format(time.RFC3339)
-	resultReqData := NewRequestData(key, idPedido, reqData.PhoneNumber, reqData.Bandeira, reqData.Sq, reqData.SmsId, ts)
-	err := redisClient.Do(radix.Cmd(nil, "HMSET", key, "idp", resultReqData.IdPedidoEnvio, "sq", resultReqData.Sq, "si", resultReqData.SmsId, "tsnd", resultReqData.TimestampSend))
-	if err == nil {
-		//Só tem as informações completas quando atualiza e só atualiza quando de fato solicitou um envio de SMS
-		if !isInserting {
-			go logRequest(resultReqData)
-		}
-		sIdTTL := fmt.Sprintf("%d", requestTTL)
-		err = redisClient.Do(radix.Cmd(nil, "EXPIRE", key, sIdTTL))
-		if err != nil {
-			return resultReqData, errors.New("Não foi possível armazenar a validade do pedido")
-		}
-	} else {
+	resultReqData := NewRequestData(key, idPedido, reqData.PhoneNumber, reqData.Bandeira, reqData.Sq, reqData.SmsId, ts, reqData.Provider)
+	err := repo.SaveRequest(resultReqData, requestTTL)
+	if err != nil {
 		return resultReqData, errors.New("Não foi possível armazenar o pedido")
 	}
+	//Só tem as informações completas quando atualiza e só atualiza quando de fato solicitou um envio de SMS
+	if !isInserting {
+		enqueueOutboxEvent(newRequestLogEvent(resultReqData))
+	}
 
 	return resultReqData, err
 }
 
 func ReadRequest(phoneNumber *string, bandeira *string) (*RequestData, error) {
-	var result []string
 	key := getRequestKey(phoneNumber, bandeira)
-	err := redisClient.Do(radix.Cmd(&result, "HMGET", key, "idp", "sq", "si", "tsnd"))
+	resultRequestData, err := repo.ReadRequest(key)
 	if err == nil {
-		resultRequestData := NewRequestData(key, result[0], *phoneNumber, *bandeira, result[1], result[2], result[3])
-		return &resultRequestData, err
+		resultRequestData.PhoneNumber = *phoneNumber
+		resultRequestData.Bandeira = *bandeira
+		return resultRequestData, err
 	} else {
 		return nil, err
 	}
 }
 
 func WriteResponse(responseData *ResponseData) (*ResponseData, error) {
-	key := fmt.Sprintf("sms:rs:%s:%s:%s", time.Now().Format("06:01"), responseData.Bandeira, responseData.Sq)
+	key := getResponseKey(responseData.Bandeira, responseData.Sq)
 	trcv := time.Now().Format(time.RFC3339)
-	resultResponseData := NewResponseData(key, responseData.IdPedidoEnvio, responseData.PhoneNumber, responseData.Bandeira, responseData.Sq, responseData.SmsId, responseData.ValidationCode, responseData.TimestampSend, trcv)
-	err := redisClient.Do(radix.Cmd(nil, "HMSET", key, "idp", responseData.IdPedidoEnvio, "pn", responseData.PhoneNumber, "si", responseData.SmsId, "vc", responseData.ValidationCode, "tsnd", responseData.TimestampSend, "trcv", trcv))
-	go logResponse(resultResponseData)
+	resultResponseData := NewResponseData(key, responseData.IdPedidoEnvio, responseData.PhoneNumber, responseData.Bandeira, responseData.Sq, responseData.SmsId, responseData.ValidationCode, responseData.TimestampSend, trcv, responseData.Provider)
+	err := repo.SaveResponse(resultResponseData)
+	enqueueOutboxEvent(newResponseLogEvent(resultResponseData))
 	if err == nil {
 		reqKey := getRequestKey(&responseData.PhoneNumber, &responseData.Bandeira)
 		resetTryCount(&reqKey)
+		markMessageVerified(responseData.SmsId, trcv)
+		CountFraudConversion(responseData.PhoneNumber, true)
 		//token para localizar os dados na fase de cadastro no php. O php chama findToken para obter o telefone confirmado
 		err = writeTempToken(responseData.SmsId, responseData.PhoneNumber, responseData.ValidationCode)
 		if err == nil {
@@ -376,14 +295,15 @@ func WriteResponse(responseData *ResponseData) (*ResponseData, error) {
 }
 
 func WriteFail(responseData *ResponseData) (*ResponseData, error) {
-	key := fmt.Sprintf("sms:rs:%s:%s:%s", time.Now().Format("06:01"), responseData.Bandeira, responseData.Sq)
-	err := redisClient.Do(radix.Cmd(nil, "HMSET", key, "idp", responseData.IdPedidoEnvio, "pn", responseData.PhoneNumber, "si", responseData.SmsId, "tsnd", responseData.TimestampSend))
+	key := getResponseKey(responseData.Bandeira, responseData.Sq)
+	failData := NewResponseData(key, responseData.IdPedidoEnvio, responseData.PhoneNumber, responseData.Bandeira, responseData.Sq, responseData.SmsId, "", responseData.TimestampSend, "", responseData.Provider)
+	err := repo.SaveResponse(failData)
 	if err == nil {
 		reqKey := getRequestKey(&responseData.PhoneNumber, &responseData.Bandeira)
 		resetTryCount(&reqKey)
 		err = writeTempToken(responseData.SmsId, responseData.PhoneNumber, responseData.ValidationCode)
 		if err == nil {
-			resultResponseData := NewResponseData(key, responseData.IdPedidoEnvio, responseData.PhoneNumber, responseData.Bandeira, responseData.Sq, responseData.SmsId, responseData.ValidationCode, responseData.TimestampSend, responseData.TimestampReceive)
+			resultResponseData := NewResponseData(key, responseData.IdPedidoEnvio, responseData.PhoneNumber, responseData.Bandeira, responseData.Sq, responseData.SmsId, responseData.ValidationCode, responseData.TimestampSend, responseData.TimestampReceive, responseData.Provider)
 			return &resultResponseData, err
 		} else {
 			return nil, err
@@ -395,27 +315,23 @@ func WriteFail(responseData *ResponseData) (*ResponseData, error) {
 func MovePossibleFailedRequest(phoneNumber *string, bandeira *string) error {
 	reqData, err := ReadRequest(phoneNumber, bandeira)
 	if (err == nil) && (reqData != nil) && (reqData.SmsId != "") {
-		respData := NewResponseData("", reqData.IdPedidoEnvio, *phoneNumber, *bandeira, reqData.Sq, reqData.SmsId, "", reqData.TimestampSend, "")
+		respData := NewResponseData("", reqData.IdPedidoEnvio, *phoneNumber, *bandeira, reqData.Sq, reqData.SmsId, "", reqData.TimestampSend, "", reqData.Provider)
 		_, err = WriteFail(&respData)
 	}
 	return err
 }
 
 func writeTempToken(smsId string, phoneNumber string, validationCode string) error {
-	sTempTokenTTL := fmt.Sprintf("%d", tempTokenTTL)
-	pipe := radix.Pipeline(
-		radix.Cmd(nil, "HMSET", smsId, "pn", phoneNumber, "vc", validationCode),
-		radix.Cmd(nil, "EXPIRE", smsId, sTempTokenTTL),
-	)
-	return redisClient.Do(pipe)
+	return repo.SaveTempToken(smsId, phoneNumber, validationCode, tempTokenTTL)
+}
+
+// TempTokenTTL retorna os segundos de validade restantes do token (<= 0 se não existe mais)
+func TempTokenTTL(smsId string) (int, error) {
+	return repo.TempTokenTTL(smsId)
 }
 
 func FindTempToken(smsId string) (phoneNumber string, validationCode string, err error) {
-	var result []string
-	err = redisClient.Do(radix.Cmd(&result, "HMGET", smsId, "pn", "vc"))
-	phoneNumber = result[0]
-	validationCode = result[1]
-	return phoneNumber, validationCode, err
+	return repo.FindTempToken(smsId)
 }
 
 // ConnectToRedis Open connection to Redis
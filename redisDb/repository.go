@@ -0,0 +1,136 @@
+package redisDb
+
+import "fmt"
+
+// Repository é o armazenamento usado pelas funções do pacote. Os pedidos, respostas e o OTP são
+// identificados pela chave (getRequestKey, getResponseKey, getOtpKey); mensagens e jobs pelo id.
+// A implementação padrão usa o Redis (radixRepository); memoryRepository é usada no modo de desenvolvimento local.
+type Repository interface {
+	//Pedidos (sms:rq:)
+	ReadRequest(key string) (*RequestData, error)
+	SaveRequest(reqData RequestData, ttlSeconds int64) error
+	DiscardRequest(key string) error
+	IncrementRequestField(key string, field string) (int, error)
+	//Tentativas de verificação (campos fa/lk do pedido). ClaimVerifyAttempt deve ser atômica.
+	ClaimVerifyAttempt(key string, maxAttempts int) (VerifyAttempt, error)
+	RefundVerifyAttempt(key string) error
+	LockRequest(key string) error
+	ResetVerifyAttempts(key string) error
+
+	//Limite de envios por telefone (campos tc/tcts do pedido). ThrottleRequest deve ser atômica.
+	ThrottleRequest(key string, now int64, cooldownSeconds int, maxTries int, windowSeconds int) (ThrottleResult, error)
+	ResetRequestTries(key string) error
+
+	//Janelas deslizantes por IP, bandeira, prefixo e global (sms:rl:). Retorna o índice da regra esgotada ou -1.
+	CheckRateLimits(rules []RateLimitRule, nowMillis int64, member string) (exceeded int, waitMillis int, err error)
+	//Retira o envio (member) das janelas, quando ele foi recusado depois ou falhou no provider
+	ReleaseRateLimits(rules []RateLimitRule, member string) error
+	//Gasto estimado do dia (sms:rl:spend:), em milionésimos da moeda
+	IncrementSpend(key string, amount int64, ttlSeconds int64) error
+	ReadSpend(key string) (int64, error)
+
+	//Antifraude (sms:fr:)
+	IncrementFraudCounter(key string, ttlSeconds int64) (int, error)
+	PushRecentFraudNumber(key string, phoneNumber string, size int, ttlSeconds int64) ([]string, error)
+	IncrementFraudConversion(key string, field string, ttlSeconds int64) error
+	ReadFraudConversion(key string) (sent int, verified int, err error)
+	AddFraudListEntry(key string, entry string) error
+	RemoveFraudListEntry(key string, entry string) error
+	ReadFraudList(key string) ([]string, error)
+	SaveFraudReview(phoneNumber string, review string) error
+	DeleteFraudReview(phoneNumber string) error
+	ReadFraudReviews() (map[string]string, error)
+
+	//Respostas (sms:rs:) e tokens temporários consumidos pelo cadastro
+	SaveResponse(respData ResponseData) error
+	SaveTempToken(smsId string, phoneNumber string, validationCode string, ttlSeconds int64) error
+	FindTempToken(smsId string) (phoneNumber string, validationCode string, err error)
+	TempTokenTTL(smsId string) (int, error)
+
+	//Sequências e contadores de cobrança
+	NextSequence(name string) (int64, error)
+	IncrementBilling(key string) (int64, error)
+	ReadBilling(key string) (int64, error)
+	//Detalhe da cobrança (sms:bilpv:): hash de contadores por provider e país
+	IncrementUsage(key string, increments map[string]int64) error
+	ReadUsage(key string) (map[string]int64, error)
+	//ScanKeys lista as chaves que casam com o padrão (glob do Redis). Só para relatórios: percorre todas as chaves
+	ScanKeys(pattern string) ([]string, error)
+	//CountVerifiedResponses conta as respostas (sms:rs:) verificadas do padrão, agrupadas por groupBy(pv, pn)
+	CountVerifiedResponses(pattern string, groupBy func(provider string, phoneNumber string) string) (map[string]int64, error)
+
+	//Outbox dos eventos do logmachine (sms:outbox, sms:outbox:run:<instância>, sms:outbox:retry, sms:outbox:dead)
+	PushOutboxEvent(event string) error
+	PopOutboxEvent(timeoutSeconds int) (string, error) //Move o evento para a lista em entrega da instância até AckOutboxEvent
+	AckOutboxEvent(event string) error
+	ScheduleOutboxRetry(event string, retried string, atMillis int64) error
+	DeadLetterOutboxEvent(event string, dead string) error
+	PromoteOutboxRetries(nowMillis int64, limit int) (int, error)
+	RequeueRunningOutboxEvents(instanceId string) error
+	OutboxLength() (queued int64, retrying int64, dead int64, err error)
+	//Registros que não foram entregues ao logmachine antes da outbox (sms:logrq:, sms:logrs:)
+	ReadFailedLog(key string) (map[string]string, error)
+	DrainFailedLog(key string, event string) error
+
+	//OTP local (sms:otp:)
+	SaveOtp(key string, otpData OtpData, ttlSeconds int) error
+	ReadOtp(key string) (*OtpData, error)
+	DeleteOtp(key string) error
+
+	//Mensagens enviadas (sms:msg:) e histórico por telefone (sms:hist:)
+	SaveMessage(msgData MessageData, ttlSeconds int64) error
+	ReadMessage(smsId string) (*MessageData, error)
+	ReadMessageHistory(phoneNumber string, bandeira string) ([]string, error)
+	UpdateMessageStatus(smsId string, status string, providerStatus string, tsStatus string) error
+	IncrementMessageVerifyAttempts(smsId string) error
+	SetMessageVerified(smsId string, tsVerify string) error
+
+	//Jobs de envio em lote (sms:job:, fila sms:jobs)
+	CreateBatchJob(job BatchJob, recipients []string, ttlSeconds int64) error
+	PopBatchJob(timeoutSeconds int) (string, error) //Move o job para sms:jobs:running:<instância> até FinishBatchJob
+	RequeueRunningBatchJobs(instanceId string) error
+	FinishBatchJob(id string, tsFinish string) error
+	ReadBatchJob(id string) (*BatchJob, error)
+	ReadBatchRecipients(id string) ([]string, error)
+	SaveBatchResult(id string, recipient string, result string, success bool, ttlSeconds int64) error
+	ReadBatchResults(id string) (map[string]string, error)
+
+	//Instâncias (sms:inst): cada uma tem suas listas de jobs e eventos em andamento, devolvidas à fila quando o
+	//registro sms:inst:<id> expira
+	RenewInstance(id string, ttlSeconds int64) error
+	ExpireInstance(id string) error
+	ReadInstances() (map[string]bool, error) //id: ativa
+	RemoveInstance(id string) error
+
+	//Verificação de prontidão (/ready) e encerramento: Close libera as conexões e o armazenamento não pode ser usado depois
+	Ping() error
+	Close() error
+}
+
+var repo Repository
+
+// SetRepository troca o armazenamento do pacote. Deve ser chamada antes de atender requisições.
+func SetRepository(r Repository) {
+	repo = r
+}
+
+// Ping verifica a conexão com o armazenamento
+func Ping() error {
+	if repo == nil {
+		return fmt.Errorf("armazenamento não configurado")
+	}
+	return repo.Ping()
+}
+
+// CloseRepository libera as conexões do armazenamento no encerramento do serviço
+func CloseRepository() error {
+	if repo == nil {
+		return nil
+	}
+	return repo.Close()
+}
+
+// CurrentRepository retorna o armazenamento em uso
+func CurrentRepository() Repository {
+	return repo
+}
@@ -0,0 +1,84 @@
+package redisDb
+
+import (
+	"github.com/mediocregopher/radix/v3"
+	"strconv"
+	"time"
+)
+
+// ThrottleResult é o resultado da verificação de limite de envios de um pedido
+type ThrottleResult struct {
+	Allowed      bool
+	WaitSeconds  int
+	LimitReached bool //true: atingiu MaxSmsRequestsPerPhone; false: não respeitou o intervalo mínimo entre envios
+}
+
+// throttleScript avalia o intervalo mínimo e o número máximo de tentativas e atualiza tc/tcts numa única chamada,
+// para que dois envios simultâneos do mesmo telefone não passem juntos.
+// tcts guarda o horário (unix) da última tentativa aceita. Pedidos gravados por versões anteriores têm tcts em
+// RFC3339 (ex: 2024-05-01T10:00:00-03:00) e continuam valendo até expirar (ver parseRfc3339).
+// KEYS[1]: sms:rq:; ARGV: agora, intervalo mínimo (s), máximo de tentativas, espera após o máximo (s).
+// Retorna {permitido, segundos de espera, atingiu o máximo}.
+var throttleScript = radix.NewEvalScript(1, `
+local function parseRfc3339(value)
+	local y, mo, d, h, mi, s, zone = string.match(value, '^(%d+)-(%d+)-(%d+)T(%d+):(%d+):(%d+)(.*)$')
+	if not y then
+		return nil
+	end
+	local offset = 0
+	if zone ~= 'Z' then
+		local sign, oh, om = string.match(zone, '^([+-])(%d+):(%d+)$')
+		if not sign then
+			return nil
+		end
+		offset = tonumber(oh) * 3600 + tonumber(om) * 60
+		if sign == '-' then
+			offset = -offset
+		end
+	end
+	-- Dias desde 1970-01-01 no calendário gregoriano
+	y, mo, d = tonumber(y), tonumber(mo), tonumber(d)
+	if mo <= 2 then
+		y = y - 1
+	end
+	local era = math.floor(y / 400)
+	local yoe = y - era * 400
+	local doy = math.floor((153 * ((mo + 9) % 12) + 2) / 5) + d - 1
+	local days = era * 146097 + yoe * 365 + math.floor(yoe / 4) - math.floor(yoe / 100) + doy - 719468
+	return days * 86400 + tonumber(h) * 3600 + tonumber(mi) * 60 + tonumber(s) - offset
+end
+
+local now = tonumber(ARGV[1])
+local cooldown = tonumber(ARGV[2])
+local maxTries = tonumber(ARGV[3])
+local window = tonumber(ARGV[4])
+local tcts = redis.call('HGET', KEYS[1], 'tcts')
+local last = 0
+if tcts then
+	last = tonumber(tcts) or parseRfc3339(tcts) or 0
+end
+local elapsed = now - last
+if elapsed < cooldown then
+	return {0, cooldown - elapsed, 0}
+end
+local tries = redis.call('HINCRBY', KEYS[1], 'tc', 1)
+if tries >= maxTries then
+	if elapsed <= window then
+		return {0, window - elapsed, 1}
+	end
+	redis.call('HDEL', KEYS[1], 'tc')
+end
+redis.call('HSET', KEYS[1], 'tcts', now)
+return {1, 0, 0}
+`)
+
+// parseLastTry lê o tcts do pedido como o throttleScript: unix ou, nos pedidos antigos, RFC3339
+func parseLastTry(tcts string) int64 {
+	if last, err := strconv.ParseInt(tcts, 10, 64); err == nil {
+		return last
+	}
+	if last, err := time.Parse(time.RFC3339, tcts); err == nil {
+		return last.Unix()
+	}
+	return 0
+}
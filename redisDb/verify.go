@@ -0,0 +1,38 @@
+package redisDb
+
+import (
+	"github.com/mediocregopher/radix/v3"
+)
+
+// VerifyAttempt é a tentativa de verificação reservada por ClaimVerifyAttempt
+type VerifyAttempt struct {
+	Attempts int  //Tentativas do pedido, contando esta. 0: não há pedido pendente
+	Locked   bool //O pedido está bloqueado e o código não deve ser verificado
+}
+
+// claimVerifyAttemptScript conta a tentativa antes da verificação do código, para que verificações simultâneas
+// não passem do limite lendo um fa desatualizado. A tentativa além do limite bloqueia o pedido (lk).
+// KEYS[1]: sms:rq:; ARGV: máximo de tentativas. Retorna {tentativas, bloqueado}.
+var claimVerifyAttemptScript = radix.NewEvalScript(1, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return {0, 0}
+end
+if redis.call('HGET', KEYS[1], 'lk') == '1' then
+	return {tonumber(redis.call('HGET', KEYS[1], 'fa')) or 0, 1}
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'fa', 1)
+if attempts > tonumber(ARGV[1]) then
+	redis.call('HSET', KEYS[1], 'lk', '1')
+	return {attempts, 1}
+end
+return {attempts, 0}
+`)
+
+// refundVerifyAttemptScript devolve a tentativa reservada quando o código não chegou a ser verificado. Não desconta
+// se um novo pedido zerou o contador nesse meio tempo.
+var refundVerifyAttemptScript = radix.NewEvalScript(1, `
+if (tonumber(redis.call('HGET', KEYS[1], 'fa')) or 0) > 0 then
+	redis.call('HINCRBY', KEYS[1], 'fa', -1)
+end
+return 0
+`)
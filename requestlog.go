@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"time"
+)
+
+const (
+	requestIdHeader    = "X-Request-Id"
+	requestLogKey      = "requestLog"
+	maxRequestIdLength = 64
+)
+
+// withRequestLog identifica a requisição pelo X-Request-Id recebido (ou um id novo), devolve o id no cabeçalho da
+// resposta e guarda o util.Logger da requisição, lido pelos handlers com requestLog
+func withRequestLog(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		requestId := string(ctx.Request.Header.Peek(requestIdHeader))
+		if !validRequestId(requestId) {
+			requestId = newRequestId()
+		}
+		ctx.Response.Header.Set(requestIdHeader, requestId)
+		ctx.SetUserValue(requestLogKey, util.NewLogger(requestId))
+		handler(ctx)
+	}
+}
+
+// requestLog retorna o log da requisição, com o requestId. Fora de withRequestLog é o log geral.
+func requestLog(ctx *fasthttp.RequestCtx) util.Logger {
+	if logger, ok := ctx.UserValue(requestLogKey).(util.Logger); ok {
+		return logger
+	}
+	return util.Logger{}
+}
+
+// validRequestId aceita ids de outros serviços com letras, dígitos, '-', '_', '.' e ':'
+func validRequestId(requestId string) bool {
+	if (requestId == "") || (len(requestId) > maxRequestIdLength) {
+		return false
+	}
+	for _, r := range requestId {
+		if !(((r >= '0') && (r <= '9')) || ((r >= 'a') && (r <= 'z')) || ((r >= 'A') && (r <= 'Z')) ||
+			(r == '-') || (r == '_') || (r == '.') || (r == ':')) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(id)
+}
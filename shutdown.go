@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	stopping          = make(chan struct{}) //Fechado quando o servidor já não atende: os dispatchers param de pegar trabalho novo
+	backgroundWorkers sync.WaitGroup        //Dispatchers de jobs e da outbox, aguardados no encerramento
+)
+
+// isStopping indica se o serviço está encerrando
+func isStopping() bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleepUnlessStopping espera d ou até o encerramento. Retorna false se o serviço está encerrando.
+func sleepUnlessStopping(d time.Duration) bool {
+	select {
+	case <-stopping:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func shutdownTimeout() time.Duration {
+	seconds := util.AppCfg.NetworkOptions.ShutdownTimeoutSeconds
+	if seconds <= 0 {
+		seconds = util.DefaultShutdownTimeoutSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// serveUntilSignal atende em serverAddr até receber SIGTERM ou SIGINT e então encerra o serviço (shutdown).
+// Um segundo sinal durante o encerramento interrompe o processo imediatamente.
+func serveUntilSignal(server *fasthttp.Server, serverAddr string) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe(serverAddr)
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		util.LogI("Recebido " + sig.String() + ": encerrando o serviço")
+	}
+	signal.Stop(signals)
+	shutdown(server)
+	return nil
+}
+
+// reopenLogOnHangup reabre o arquivo de log a cada SIGHUP, para a rotação externa (ex: logrotate sem copytruncate)
+func reopenLogOnHangup() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := util.ReopenLog(); err != nil {
+			util.LogE("reopenLogOnHangup: " + err.Error())
+			continue
+		}
+		util.LogI("Recebido SIGHUP: arquivo de log reaberto")
+	}
+}
+
+// shutdown para de aceitar conexões, aguarda as requisições em andamento, os envios dos jobs e as entregas ao
+// logmachine e fecha o pool do Redis, tudo dentro de Network.ShutdownTimeoutSeconds. Esgotado o prazo, jobs e
+// eventos interrompidos são retomados pelas outras instâncias ou na próxima partida.
+func shutdown(server *fasthttp.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+	if err := server.ShutdownWithContext(ctx); err != nil {
+		util.LogW("shutdown: requisições ainda em andamento: " + err.Error())
+	}
+	close(stopping)
+
+	done := make(chan struct{})
+	go func() {
+		backgroundWorkers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		util.LogW("shutdown: prazo esgotado aguardando os jobs e a outbox. O trabalho interrompido será retomado por outra instância ou na próxima partida")
+	}
+	stopInstance()
+
+	if err := db.CloseRepository(); err != nil {
+		util.LogE("shutdown: " + err.Error())
+	}
+	util.LogI("shutdown: serviço encerrado")
+}
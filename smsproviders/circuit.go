@@ -0,0 +1,131 @@
+package smsproviders
+
+import (
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"    //Provider em uso
+	CircuitOpen     = "open"      //Provider fora da cadeia até o fim do prazo (Circuit.OpenSeconds)
+	CircuitHalfOpen = "half-open" //Prazo vencido: o próximo envio testa o provider
+)
+
+// circuit acompanha os resultados de um provider nas cadeias (ProviderChain.Do). Só as falhas transitórias
+// (SmsResult.Retryable) contam: uma recusa definitiva mostra que o provider está respondendo.
+type circuit struct {
+	failures    int
+	openUntil   time.Time
+	probing     bool
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+var circuitsMutex sync.Mutex
+var circuits = map[string]*circuit{}
+
+func circuitOpenDuration() time.Duration {
+	seconds := util.AppCfg.CircuitOptions.OpenSeconds
+	if seconds <= 0 {
+		seconds = util.DefaultCircuitOpenSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// getCircuit supõe o mutex já adquirido
+func getCircuit(name string) *circuit {
+	c, ok := circuits[name]
+	if !ok {
+		c = &circuit{}
+		circuits[name] = c
+	}
+	return c
+}
+
+func (c *circuit) state(now time.Time) string {
+	switch {
+	case c.openUntil.IsZero():
+		return CircuitClosed
+	case now.Before(c.openUntil):
+		return CircuitOpen
+	default:
+		return CircuitHalfOpen
+	}
+}
+
+// allowRequest indica se o provider pode receber o envio. Com o circuito meio aberto só um envio de teste passa.
+func allowRequest(name string) bool {
+	circuitsMutex.Lock()
+	defer circuitsMutex.Unlock()
+	c := getCircuit(name)
+	switch c.state(time.Now()) {
+	case CircuitClosed:
+		return true
+	case CircuitHalfOpen:
+		if c.probing {
+			return false
+		}
+		c.probing = true
+		return true
+	}
+	return false
+}
+
+func recordResult(name string, result SmsResult) {
+	circuitsMutex.Lock()
+	defer circuitsMutex.Unlock()
+	c := getCircuit(name)
+	now := time.Now()
+	c.probing = false
+	if result.IsSuccess || !result.Retryable {
+		if !c.openUntil.IsZero() {
+			util.LogI("Circuit: " + name + " voltou a responder")
+		}
+		c.failures = 0
+		c.openUntil = time.Time{}
+		c.lastSuccess = now
+		return
+	}
+	c.failures++
+	c.lastFailure = now
+	c.lastError = result.Msg
+	threshold := util.AppCfg.CircuitOptions.FailureThreshold
+	if threshold <= 0 {
+		threshold = util.DefaultCircuitFailureThreshold
+	}
+	if c.failures >= threshold {
+		c.openUntil = now.Add(circuitOpenDuration())
+		util.LogW(fmt.Sprintf("Circuit: %s aberto após %d falhas seguidas (%s)", name, c.failures, result.Msg))
+	}
+}
+
+// CircuitState retorna o estado do circuito do provider sem consumir o envio de teste
+func CircuitState(name string) string {
+	circuitsMutex.Lock()
+	defer circuitsMutex.Unlock()
+	return getCircuit(name).state(time.Now())
+}
+
+// ProviderHealth retorna a situação do provider: instanciado, estado do circuito e últimos resultados
+func ProviderHealth(name string) util.TProviderHealth {
+	health := util.TProviderHealth{Provider: name, Available: GetProvider(name) != nil}
+	circuitsMutex.Lock()
+	defer circuitsMutex.Unlock()
+	c := getCircuit(name)
+	health.Circuit = c.state(time.Now())
+	health.ConsecutiveFailures = c.failures
+	health.LastError = c.lastError
+	if !c.lastSuccess.IsZero() {
+		health.LastSuccess = c.lastSuccess.Format(time.RFC3339)
+	}
+	if !c.lastFailure.IsZero() {
+		health.LastFailure = c.lastFailure.Format(time.RFC3339)
+	}
+	if health.Circuit == CircuitOpen {
+		health.OpenUntil = c.openUntil.Format(time.RFC3339)
+	}
+	return health
+}
@@ -0,0 +1,141 @@
+package smsproviders
+
+import (
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"testing"
+	"time"
+)
+
+// fakeProvider só responde ProviderName: os envios são simulados pela função passada a ProviderChain.Do
+type fakeProvider struct {
+	SmsProviderIntf
+	name string
+}
+
+func (p fakeProvider) ProviderName() string {
+	return p.name
+}
+
+// resetCircuits zera os circuitos e usa a configuração do teste, restaurando ambos no fim
+func resetCircuits(t *testing.T, failureThreshold int) {
+	previousCfg := util.AppCfg
+	t.Cleanup(func() {
+		util.AppCfg = previousCfg
+		circuitsMutex.Lock()
+		circuits = map[string]*circuit{}
+		circuitsMutex.Unlock()
+	})
+	util.AppCfg = util.Config{}
+	util.AppCfg.CircuitOptions.FailureThreshold = failureThreshold
+	circuitsMutex.Lock()
+	circuits = map[string]*circuit{}
+	circuitsMutex.Unlock()
+}
+
+// expireCircuit simula o fim do prazo do circuito aberto
+func expireCircuit(name string) {
+	circuitsMutex.Lock()
+	defer circuitsMutex.Unlock()
+	getCircuit(name).openUntil = time.Now().Add(-time.Second)
+}
+
+var transientFailure = *NewRetryableSmsResult(1, "timeout", "")
+
+func TestCircuitOpensAfterThreshold(t *testing.T) {
+	resetCircuits(t, 3)
+
+	for i := 0; i < 2; i++ {
+		recordResult("A", transientFailure)
+	}
+	if state := CircuitState("A"); state != CircuitClosed {
+		t.Fatalf("circuito após 2 falhas: %s, esperado %s", state, CircuitClosed)
+	}
+	//Uma recusa definitiva mostra que o provider responde e zera a contagem
+	recordResult("A", *NewSmsResult(NoSuccess, 2, "número inválido", ""))
+	for i := 0; i < 2; i++ {
+		recordResult("A", transientFailure)
+	}
+	if state := CircuitState("A"); state != CircuitClosed {
+		t.Fatalf("circuito após recusa e 2 falhas: %s, esperado %s", state, CircuitClosed)
+	}
+	recordResult("A", transientFailure)
+	if state := CircuitState("A"); state != CircuitOpen {
+		t.Fatalf("circuito após 3 falhas seguidas: %s, esperado %s", state, CircuitOpen)
+	}
+	if allowRequest("A") {
+		t.Error("circuito aberto aceitou envio")
+	}
+	if health := ProviderHealth("A"); (health.ConsecutiveFailures != 3) || (health.LastError != "timeout") {
+		t.Errorf("ProviderHealth = %+v, esperado 3 falhas e o último erro", health)
+	}
+}
+
+func TestCircuitHalfOpenProbe(t *testing.T) {
+	resetCircuits(t, 1)
+
+	recordResult("A", transientFailure)
+	expireCircuit("A")
+	if state := CircuitState("A"); state != CircuitHalfOpen {
+		t.Fatalf("circuito com o prazo vencido: %s, esperado %s", state, CircuitHalfOpen)
+	}
+	if !allowRequest("A") {
+		t.Fatal("circuito meio aberto recusou o envio de teste")
+	}
+	if allowRequest("A") {
+		t.Error("circuito meio aberto aceitou um 2º envio durante o teste")
+	}
+	//O envio de teste falhou: abre de novo
+	recordResult("A", transientFailure)
+	if state := CircuitState("A"); state != CircuitOpen {
+		t.Fatalf("circuito após falha no teste: %s, esperado %s", state, CircuitOpen)
+	}
+
+	expireCircuit("A")
+	allowRequest("A")
+	recordResult("A", *NewSmsResult(true, 0, "", ""))
+	if state := CircuitState("A"); state != CircuitClosed {
+		t.Errorf("circuito após sucesso no teste: %s, esperado %s", state, CircuitClosed)
+	}
+}
+
+func TestProviderChainSkipsOpenCircuit(t *testing.T) {
+	resetCircuits(t, 2)
+	chain := NewProviderChain(fakeProvider{name: "A"}, fakeProvider{name: "B"})
+	var called []string
+	call := func(provider SmsProviderIntf) SmsResult {
+		called = append(called, provider.ProviderName())
+		if provider.ProviderName() == "A" {
+			return transientFailure
+		}
+		return *NewSmsResult(true, 0, "", "")
+	}
+
+	for i := 0; i < 3; i++ {
+		result, provider := chain.Do(call)
+		if !result.IsSuccess || (provider.ProviderName() != "B") {
+			t.Fatalf("envio %d: %+v por %v, esperado sucesso por B", i+1, result, provider)
+		}
+	}
+	//A falhou duas vezes e saiu da cadeia no 3º envio
+	expected := []string{"A", "B", "A", "B", "B"}
+	if len(called) != len(expected) {
+		t.Fatalf("providers chamados %v, esperado %v", called, expected)
+	}
+	for i := range expected {
+		if called[i] != expected[i] {
+			t.Fatalf("providers chamados %v, esperado %v", called, expected)
+		}
+	}
+	if !chain.Available() {
+		t.Error("cadeia indisponível com B fechado")
+	}
+
+	recordResult("B", transientFailure)
+	recordResult("B", transientFailure)
+	if chain.Available() {
+		t.Error("cadeia disponível com todos os circuitos abertos")
+	}
+	if result, provider := chain.Do(call); result.IsSuccess || (result.Code != NoProviderErrorCode) || (provider != nil) {
+		t.Errorf("envio com todos os circuitos abertos: %+v por %v, esperado NoProviderErrorCode", result, provider)
+	}
+}
@@ -0,0 +1,47 @@
+package smsproviders
+
+import (
+	"gaudium.com.br/gaudiumsoftware/sms/metrics"
+	"time"
+)
+
+// instrumentedProvider registra a duração das chamadas à API do provider (metrics.ProviderRequestDuration).
+// NewProvider envolve todos os providers registrados.
+type instrumentedProvider struct {
+	SmsProviderIntf
+}
+
+func resultLabel(result SmsResult) string {
+	switch {
+	case result.IsSuccess:
+		return "success"
+	case result.Retryable:
+		return "retryable"
+	}
+	return "failure"
+}
+
+func (p instrumentedProvider) observe(operation string, start time.Time, result SmsResult) {
+	metrics.ProviderRequestDuration.ObserveSince(start, p.ProviderName(), operation, resultLabel(result))
+}
+
+func (p instrumentedProvider) SendVerificationRequest(phoneNumber string, content string, hashCode string) SmsResult {
+	start := time.Now()
+	result := p.SmsProviderIntf.SendVerificationRequest(phoneNumber, content, hashCode)
+	p.observe("send_verification", start, result)
+	return result
+}
+
+func (p instrumentedProvider) SendMessageRequest(phoneNumber string, content string, hashCode string, senderId string) SmsResult {
+	start := time.Now()
+	result := p.SmsProviderIntf.SendMessageRequest(phoneNumber, content, hashCode, senderId)
+	p.observe("send_message", start, result)
+	return result
+}
+
+func (p instrumentedProvider) VerifyRequest(phoneNumber string, sentCode string, receivedCode string) SmsResult {
+	start := time.Now()
+	result := p.SmsProviderIntf.VerifyRequest(phoneNumber, sentCode, receivedCode)
+	p.observe("verify", start, result)
+	return result
+}
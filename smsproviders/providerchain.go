@@ -0,0 +1,71 @@
+package smsproviders
+
+import (
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"strings"
+)
+
+// ProviderChain mantém os providers em ordem de prioridade. Quando um provider falha por motivo
+// transitório (SmsResult.Retryable), o pedido é repassado ao próximo da lista.
+type ProviderChain struct {
+	providers []SmsProviderIntf
+}
+
+func NewProviderChain(providers ...SmsProviderIntf) *ProviderChain {
+	return &ProviderChain{providers: providers}
+}
+
+func (c *ProviderChain) Providers() []SmsProviderIntf {
+	return c.providers
+}
+
+func (c *ProviderChain) First() SmsProviderIntf {
+	if len(c.providers) == 0 {
+		return nil
+	}
+	return c.providers[0]
+}
+
+func (c *ProviderChain) String() string {
+	names := make([]string, 0, len(c.providers))
+	for _, provider := range c.providers {
+		names = append(names, provider.ProviderName())
+	}
+	return strings.Join(names, ",")
+}
+
+// Available indica se algum provider da cadeia pode receber envios (circuito fechado ou meio aberto)
+func (c *ProviderChain) Available() bool {
+	for _, provider := range c.providers {
+		if CircuitState(provider.ProviderName()) != CircuitOpen {
+			return true
+		}
+	}
+	return false
+}
+
+// Do executa call em cada provider até obter sucesso ou uma falha definitiva. Providers com o circuito aberto
+// são pulados. Retorna o resultado e o provider que o produziu (nil se nenhum foi chamado).
+func (c *ProviderChain) Do(call func(provider SmsProviderIntf) SmsResult) (result SmsResult, provider SmsProviderIntf) {
+	result = *NewSmsResult(NoSuccess, NoProviderErrorCode, "Nenhum provider disponível", "")
+	var last SmsProviderIntf
+	for _, provider = range c.providers {
+		if !allowRequest(provider.ProviderName()) {
+			continue
+		}
+		last = provider
+		result = call(provider)
+		recordResult(provider.ProviderName(), result)
+		if result.IsSuccess || !result.Retryable {
+			return result, provider
+		}
+		util.LogW("ProviderChain: " + provider.ProviderName() + " falhou (" + result.Msg + "), tentando o próximo")
+	}
+	return result, last
+}
+
+func (c *ProviderChain) SendVerificationRequest(phoneNumber string, content string, hashCode string) (SmsResult, SmsProviderIntf) {
+	return c.Do(func(provider SmsProviderIntf) SmsResult {
+		return provider.SendVerificationRequest(phoneNumber, content, hashCode)
+	})
+}
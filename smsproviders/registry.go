@@ -0,0 +1,123 @@
+package smsproviders
+
+import (
+	"fmt"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ConfigField descreve um parâmetro de configuração do provider. Name é um campo de util.ProviderOptions
+// (AppKey, AppSecret, BaseUrl, SenderId, TimeoutSeconds) ou uma chave de ProviderOptions.Options.
+// Campos Secret podem ser informados pela variável de ambiente util.ProviderEnvVar(provider, Name).
+type ConfigField struct {
+	Name     string
+	Default  string
+	Required bool
+	Secret   bool
+}
+
+type ProviderFactory func(cfg util.ProviderOptions) (SmsProviderIntf, error)
+
+type registration struct {
+	schema  []ConfigField
+	factory ProviderFactory
+}
+
+var registryMutex sync.RWMutex
+var registry = map[string]registration{}
+var instances = map[string]SmsProviderIntf{}
+
+// Register é chamado no init() de cada pacote de provider. Basta importar o pacote para o provider ficar disponível.
+func Register(name string, schema []ConfigField, factory ProviderFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, exists := registry[name]; exists {
+		panic("smsproviders: provider registrado duas vezes: " + name)
+	}
+	registry[name] = registration{schema, factory}
+}
+
+func RegisteredProviders() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func ProviderSchema(name string) ([]ConfigField, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	reg, exists := registry[name]
+	return reg.schema, exists
+}
+
+// applySchema aplica as variáveis de ambiente dos campos secretos, preenche os valores default
+// e verifica os campos obrigatórios
+func applySchema(name string, schema []ConfigField, cfg util.ProviderOptions) (util.ProviderOptions, error) {
+	options := make(map[string]string, len(cfg.Options))
+	for k, v := range cfg.Options {
+		options[k] = v
+	}
+	cfg.Options = options
+	for _, field := range schema {
+		if field.Secret {
+			if envValue, isSet := os.LookupEnv(util.ProviderEnvVar(name, field.Name)); isSet {
+				cfg.Set(field.Name, envValue)
+			}
+		}
+		if (cfg.Get(field.Name) == "") && (field.Default != "") {
+			cfg.Set(field.Name, field.Default)
+		}
+		if field.Required && (cfg.Get(field.Name) == "") {
+			return cfg, fmt.Errorf("parâmetro obrigatório não configurado: %s", field.Name)
+		}
+	}
+	return cfg, nil
+}
+
+func NewProvider(name string, cfg util.ProviderOptions) (SmsProviderIntf, error) {
+	registryMutex.RLock()
+	reg, exists := registry[name]
+	registryMutex.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("provider não registrado: %s", name)
+	}
+	cfg, err := applySchema(name, reg.schema, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	provider, err := reg.factory(cfg)
+	if (err != nil) || (provider == nil) {
+		return provider, err
+	}
+	return instrumentedProvider{provider}, nil
+}
+
+// InitProviders instancia todos os providers registrados com a configuração de cada um.
+// Providers com configuração inválida ficam indisponíveis e o erro é retornado.
+func InitProviders(providersCfg map[string]util.ProviderOptions) (errs []error) {
+	for _, name := range RegisteredProviders() {
+		provider, err := NewProvider(name, providersCfg[name])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		registryMutex.Lock()
+		instances[name] = provider
+		registryMutex.Unlock()
+	}
+	return errs
+}
+
+// GetProvider retorna o provider instanciado por InitProviders, ou nil
+func GetProvider(name string) SmsProviderIntf {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return instances[name]
+}
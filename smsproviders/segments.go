@@ -0,0 +1,50 @@
+package smsproviders
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	gsmSingleSegment     = 160 //Septetos de um SMS com o alfabeto GSM 03.38
+	gsmMultiSegment      = 153 //Septetos de cada parte de um SMS concatenado (o restante é o cabeçalho UDH)
+	unicodeSingleSegment = 70  //Caracteres UTF-16 de um SMS em UCS-2
+	unicodeMultiSegment  = 67
+)
+
+// gsmBasic é o alfabeto padrão GSM 03.38 (1 septeto); gsmExtended exige o escape (2 septetos)
+const gsmBasic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+const gsmExtended = "^{}\\[~]|€\f"
+
+// MessageText é o texto enviado ao provider: o conteúdo e, se informado, o hash do app na última linha
+func MessageText(content string, hashCode string) string {
+	if hashCode == "" {
+		return content
+	}
+	return content + "\n" + hashCode
+}
+
+// CountSegments retorna em quantas partes o texto é enviado e cobrado. Texto com caracteres fora do alfabeto GSM
+// (ex: ã, ç, ó, emojis) é enviado em UCS-2, com bem menos caracteres por parte.
+func CountSegments(text string) int {
+	septets := 0
+	for _, c := range text {
+		switch {
+		case strings.ContainsRune(gsmBasic, c):
+			septets++
+		case strings.ContainsRune(gsmExtended, c):
+			septets += 2
+		default:
+			return segments(len(utf16.Encode([]rune(text))), unicodeSingleSegment, unicodeMultiSegment)
+		}
+	}
+	return segments(septets, gsmSingleSegment, gsmMultiSegment)
+}
+
+func segments(length int, single int, multi int) int {
+	if length <= single {
+		return 1
+	}
+	return (length + multi - 1) / multi
+}
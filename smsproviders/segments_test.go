@@ -0,0 +1,39 @@
+package smsproviders
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCountSegments(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		segments int
+	}{
+		{"vazio", "", 1},
+		{"GSM em uma parte", strings.Repeat("a", 160), 1},
+		{"GSM em duas partes", strings.Repeat("a", 161), 2},
+		{"GSM em três partes", strings.Repeat("a", 307), 3},
+		{"escape GSM conta 2 septetos", strings.Repeat("€", 80), 1},
+		{"escape GSM além de uma parte", strings.Repeat("€", 81), 2},
+		{"UCS-2 em uma parte", "Seu código é " + strings.Repeat("1", 57), 1},
+		{"UCS-2 em duas partes", "Seu código é " + strings.Repeat("1", 58), 2},
+		{"emoji ocupa 2 caracteres UTF-16", strings.Repeat("😀", 35), 1},
+		{"emoji além de uma parte", strings.Repeat("😀", 36), 2},
+	}
+	for _, c := range cases {
+		if segments := CountSegments(c.text); segments != c.segments {
+			t.Errorf("%s: CountSegments = %d, esperado %d", c.name, segments, c.segments)
+		}
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	if text := MessageText("Seu código é 1234", ""); text != "Seu código é 1234" {
+		t.Errorf("MessageText sem hash = %q", text)
+	}
+	if text := MessageText("Seu código é 1234", "FA+9qCX9VSu"); text != "Seu código é 1234\nFA+9qCX9VSu" {
+		t.Errorf("MessageText com hash = %q", text)
+	}
+}
@@ -1,8 +1,10 @@
 package sinchprovider
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"errors"
 	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
 	"gaudium.com.br/gaudiumsoftware/sms/util"
 	"github.com/valyala/fasthttp"
@@ -13,14 +15,14 @@ import (
 
 const (
 	SinchProviderName				  = "Sinch"
-	// sinchAppKey                       = "9da910fc-e2ea-4a4c-90cc-10cb30d330e4"
-	// sinchAppKey                       = "YjVjNThlZjUtOTBkNC00MzkwLWE3NDEtZTk1NWU5YmNkNDZjOm5qSW5oM0R3TUVxRzNqNm9qbE5Kc3c9PQ=="
-	sinchAppKey                       = "YjVjNThlZjUtOTBkNC00MzkwLWE3NDEtZTk1NWU5YmNkNDZjOm5qSW5oM0R3TUVxRzNqNm9qbE5Kc3c9PQ=="
-	sinchSendURI                      = "https://verificationapi-v1.sinch.com/verification/v1/verifications"
-	sinchVerifyURI                    = "https://verificationapi-v1.sinch.com/verification/v1/verifications/number/%s"
-	sinchSendSmsTemplate			  = `{"from": "Gaudium","to": ["%s"],"body": "%s \n(%s)"}'"`
+	sinchBaseURI                      = "https://verificationapi-v1.sinch.com/verification/v1"
+	sinchSendPath                     = "/verifications"
+	sinchVerifyPath                   = "/verifications/number/%s"
+	sinchSmsBaseURI                   = "https://sms.api.sinch.com/xms/v1"
+	sinchSmsPath                      = "/%s/batches"
 	sinchSendVerificationJsonTemplate = `{"identity":{"type":"number","endpoint":"%s"},"method":"sms","smsOptions":{"applicationHash":"%s"}}`
 	sinchVerifyJsonTemplate 		  = `{"method": "sms","sms": { "code": "%s" }}`
+	sinchDateFormat                   = "2006-01-02T15:04:05.0000000Z" //Cabeçalho Date, em UTC
 
 	SinchVerifySuccess    = "SUCCESSFUL"
 	SinchParseErrorCode   = 1
@@ -69,6 +71,11 @@ func NewSinchSendResponse(content []byte) (result TSinchSendResponse, err error)
 	return result, err
 }
 
+//Erros em que outro provider pode ter sucesso: falta de crédito, capacidade e indisponibilidade do Sinch
+func isRetryableError(errorCode int64) bool {
+	return errorCode == 40200 || errorCode == 42201 || errorCode == 42900 || errorCode >= 50000
+}
+
 func translateMessage(errorCode int64) string {
 	switch errorCode {
 	//BadRequest
@@ -149,52 +156,103 @@ type TSendResponse struct {
 
 //------
 
+//TSinchBatchRequest ------ API de SMS (batches)
+type TSinchBatchRequest struct {
+	From string   `json:"from"`
+	To   []string `json:"to"`
+	Body string   `json:"body"`
+}
+
+//Sucesso: id preenchido. Erro: code e text preenchidos
+type TSinchBatchResponse struct {
+	Id   string `json:"id"`
+	Code string `json:"code"`
+	Text string `json:"text"`
+}
+
+//TSinchDeliveryReport ------ Callback da API de SMS. Por destinatário (recipient_delivery_report_sms)
+//ou resumo do batch (delivery_report_sms)
+type TSinchDeliveryStatus struct {
+	Code       int      `json:"code"`
+	Status     string   `json:"status"`
+	Recipients []string `json:"recipients"`
+}
+
+type TSinchDeliveryReport struct {
+	Type      string                 `json:"type"`
+	BatchId   string                 `json:"batch_id"`
+	Recipient string                 `json:"recipient"`
+	Code      int                    `json:"code"`
+	Status    string                 `json:"status"`
+	At        string                 `json:"at"`
+	Statuses  []TSinchDeliveryStatus `json:"statuses"`
+}
+
+//------
+
 type SinchSmsVerifier struct {
 	providerName string
-	appKey string
+	authorization string
 	sendUri string
 	verifyUri string
+	smsUri string
+	servicePlanId string
+	smsToken string
+	from string
+	timeout time.Duration
 }
 
-func NewSinchSmsVerifier() smsproviders.SmsProviderIntf {
-	result := &SinchSmsVerifier{SinchProviderName, sinchAppKey, sinchSendURI, sinchVerifyURI}
-	return result
+func init() {
+	smsproviders.Register(SinchProviderName, []smsproviders.ConfigField{
+		//Application key e secret da API de verificação
+		{Name: "AppKey", Required: true, Secret: true},
+		{Name: "AppSecret", Required: true, Secret: true},
+		{Name: "BaseUrl", Default: sinchBaseURI, Required: true},
+		{Name: "SenderId"}, //Remetente dos SMS de texto das bandeiras sem SenderId próprio
+		//API de SMS. Sem service plan o envio de SMS de texto passa para o próximo provider
+		{Name: "smsBaseUrl", Default: sinchSmsBaseURI},
+		{Name: "servicePlanId"},
+		{Name: "smsToken", Secret: true},
+	}, NewSinchSmsVerifier)
+}
+
+func NewSinchSmsVerifier(cfg util.ProviderOptions) (smsproviders.SmsProviderIntf, error) {
+	authorization := base64.StdEncoding.EncodeToString([]byte(cfg.AppKey + ":" + cfg.AppSecret))
+	result := &SinchSmsVerifier{SinchProviderName, authorization, cfg.BaseUrl + sinchSendPath, cfg.BaseUrl + sinchVerifyPath,
+		cfg.Get("smsBaseUrl") + sinchSmsPath, cfg.Get("servicePlanId"), cfg.Get("smsToken"), cfg.SenderId, cfg.Timeout()}
+	return result, nil
 }
 
 func (s *SinchSmsVerifier) ProviderName() string {
 	return s.providerName
 }
 
-func (s *SinchSmsVerifier) SendVerificationRequest(phoneNumber string, content string, hashCode string) (result smsproviders.SmsResult) {
-	util.LogD("SendVerificationRequest.1: " + phoneNumber + ":" + content + ":" + hashCode)
+func (s *SinchSmsVerifier) HasVerificationApi() bool {
+	return true
+}
 
-	if !strings.HasPrefix(phoneNumber, "+55") {
-		util.LogD("Validação de SMS sem os parâmetros corretos - Telefone: " + phoneNumber + " - Hashcode: " + hashCode)
-		resultaError := *smsproviders.NewSmsResult(smsproviders.Success, smsproviders.SuccessCode, "Pedido de verificação enviado com sucesso", "")
-		return resultaError
-	}
+func (s *SinchSmsVerifier) SendVerificationRequest(phoneNumber string, content string, hashCode string) (result smsproviders.SmsResult) {
+	util.LogD("SendVerificationRequest.1: " + util.MaskPhone(phoneNumber))
 
 	req := fasthttp.AcquireRequest()
 	req.SetRequestURI(s.sendUri)
 	req.Header.SetMethod("POST")
 	req.Header.SetContentType("application/json")
 	t := time.Now()
-	req.Header.Add("Date", t.Format("2019-12-20T19:49:00.0000000Z"))
+	req.Header.Add("Date", t.UTC().Format(sinchDateFormat))
 	util.LogD(string(req.Header.Peek("Date")))
-	// req.Header.Add("Authorization", "Application " + s.appKey)
-	req.Header.Add("Authorization", "Basic " + s.appKey)
+	req.Header.Add("Authorization", "Basic " + s.authorization)
 	req.Header.Add("Accept-Language", "pt-BR")
 	if content != "" {
 		content = ""
 	}
 	reqBody := fmt.Sprintf(sinchSendVerificationJsonTemplate, phoneNumber, hashCode);
-	util.LogD("SendVerificationRequest.2: " + reqBody)
 	req.SetBodyString(reqBody)
 	resp := fasthttp.AcquireResponse()
 	client := &fasthttp.Client{}
-	if err := client.Do(req, resp); err != nil {
+	if err := client.DoTimeout(req, resp, s.timeout); err != nil {
 		util.LogE("SendVerificationRequest.3 (falha): " + err.Error())
-		result = *smsproviders.NewSmsResult(smsproviders.NoSuccess, SinchSendVrErrorCode, err.Error(), "")
+		result = *smsproviders.NewRetryableSmsResult(SinchSendVrErrorCode, err.Error(), "")
 	} else {
 		util.LogD("SendVerificationRequest.4 (sucesso)")
 		result = s.CheckSendVerificationResponse(resp.Body())
@@ -204,8 +262,6 @@ func (s *SinchSmsVerifier) SendVerificationRequest(phoneNumber string, content s
 }
 
 func (s *SinchSmsVerifier) CheckSendVerificationResponse(content []byte) (result smsproviders.SmsResult) {
-	util.LogD("CheckSendVerificationResponse.1: " + string(content))
-
 	var vSendResp TSinchSendResponse
 	err := json.Unmarshal(content, &vSendResp)
 	util.LogD("CheckSendVerificationResponse.unmarshal.id: " + vSendResp.Id)
@@ -218,49 +274,69 @@ func (s *SinchSmsVerifier) CheckSendVerificationResponse(content []byte) (result
 			util.LogD("CheckSendVerificationResponse.3: falha")
 			vSendResp.Message = translateMessage(vSendResp.ErrorCode)
 			result = *smsproviders.NewSmsResult(smsproviders.NoSuccess, SinchSendVrErrorCode, vSendResp.Message, strconv.FormatInt(vSendResp.ErrorCode, 10) + " - " + vSendResp.Reference)
+			result.Retryable = isRetryableError(vSendResp.ErrorCode)
 		}
+	} else {
+		result = *smsproviders.NewRetryableSmsResult(SinchParseErrorCode, err.Error(), "")
 	}
 	util.LogD("CheckSendVerificationResponse.4: " + strconv.FormatBool(result.IsSuccess) + ":" + result.Msg + ":" + fmt.Sprintf("%v", result.Data))
 	return result
 }
 
-	/*func (s *SinchSmsVerifier) SendMessageRequest(phoneNumber string, content string, hashCode string) (result smsproviders.SmsResult) {
-	util.LogD("SendMessageRequest: " + phoneNumber + ":" + content + ":" + hashCode)
+func (s *SinchSmsVerifier) SendMessageRequest(phoneNumber string, content string, hashCode string, senderId string) (result smsproviders.SmsResult) {
+	util.LogD("SendMessageRequest: " + util.MaskPhone(phoneNumber))
 
+	if s.servicePlanId == "" {
+		return *smsproviders.NewRetryableSmsResult(SinchSendSmsErrorCode, "API de SMS do Sinch não configurada", "")
+	}
+	text := smsproviders.MessageText(content, hashCode)
+	from := s.from
+	if senderId != "" {
+		from = senderId
+	}
+	if from == "" {
+		return *smsproviders.NewRetryableSmsResult(SinchSendSmsErrorCode, "Remetente (SenderId) não configurado", "")
+	}
+	reqBody, _ := json.Marshal(TSinchBatchRequest{From: from, To: []string{phoneNumber}, Body: text})
 	req := fasthttp.AcquireRequest()
-	req.SetRequestURI(s.sendUri)
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(fmt.Sprintf(s.smsUri, s.servicePlanId))
 	req.Header.SetMethod("POST")
 	req.Header.SetContentType("application/json")
-	t := time.Now()
-	req.Header.Add("Date", t.Format("2019-12-20T19:49:00.0000000Z"))
-	req.Header.Add("Authorization", "Application " + s.appKey)
-	req.Header.Add("Accept-Language", "pt-BR")
-	req.SetBodyString(fmt.Sprintf(sinchSendSmsTemplate, phoneNumber, content, hashCode))
+	req.Header.Add("Authorization", "Bearer " + s.smsToken)
+	req.SetBody(reqBody)
 	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
 	client := &fasthttp.Client{}
-	if err := client.Do(req, resp); err != nil {
-		result = *smsproviders.NewSmsResult(smsproviders.NoSuccess, err.Error(), "")
+	if err := client.DoTimeout(req, resp, s.timeout); err != nil {
+		result = *smsproviders.NewRetryableSmsResult(SinchSendSmsErrorCode, err.Error(), "")
 	} else {
-		bodyBytes := resp.Body()
-		result = s.CheckSendMessageResponse(bodyBytes)
+		result = s.CheckSendMessageResponse(resp.Body())
+		if !result.IsSuccess && (resp.StatusCode() == fasthttp.StatusTooManyRequests || resp.StatusCode() >= fasthttp.StatusInternalServerError) {
+			result.Retryable = true
+		}
+	}
+	if result.IsSuccess {
+		result.Segments = smsproviders.CountSegments(text)
 	}
 	util.LogD("SendMessageRequest: " + strconv.FormatBool(result.IsSuccess) + ":" + result.Msg + ":" + fmt.Sprintf("%v", result.Data))
 	return result
-}*/
+}
 
-/*func (s *SinchSmsVerifier) CheckSendMessageResponse(content []byte) (result smsproviders.SmsResult) {
-	var vResp TSinchVerifyResponse;
+func (s *SinchSmsVerifier) CheckSendMessageResponse(content []byte) (result smsproviders.SmsResult) {
+	var vResp TSinchBatchResponse
 	err := json.Unmarshal(content, &vResp)
 	if err == nil {
-		if vResp.Status == SINCH_SMS_SUCCESS {
-			result = *smsproviders.NewSmsResult(SINCH_SUCCESS, "SMS enviado com sucesso", vResp.Id)
+		if vResp.Id != "" {
+			result = *smsproviders.NewSmsResult(smsproviders.Success, smsproviders.SuccessCode, "SMS enviado com sucesso", vResp.Id)
 		} else {
-			result = *smsproviders.NewSmsResult(SINCH_SEND_SMS_ERROR_CODE, vResp.Message + "(" + vResp.ErrorCode + ")", vResp.Id)
+			result = *smsproviders.NewSmsResult(smsproviders.NoSuccess, SinchSendSmsErrorCode, vResp.Text, vResp.Code)
 		}
+	} else {
+		result = *smsproviders.NewRetryableSmsResult(SinchParseErrorCode, err.Error(), "")
 	}
-	util.LogD("SendVerificationResponse: " + string(result.IsSuccess) + ":" + result.Msg + ":" + result.Data)
 	return result
-}*/
+}
 
 func (s *SinchSmsVerifier) VerifyRequest(phoneNumber string, sentCode string, receivedCode string) (result smsproviders.SmsResult) {
 	util.LogD("SmsVerifyRequest")
@@ -270,16 +346,15 @@ func (s *SinchSmsVerifier) VerifyRequest(phoneNumber string, sentCode string, re
 	req.Header.SetMethod("PUT")
 	req.Header.SetContentType("application/json")
 	t := time.Now()
-	req.Header.Add("Date", t.Format("2006-02-01T15:04:05.0000000Z"))
+	req.Header.Add("Date", t.UTC().Format(sinchDateFormat))
 	util.LogD(string(req.Header.Peek("Date")))
-	// req.Header.Add("Authorization", "Application " + s.appKey)
-	req.Header.Add("Authorization", "Basic " + s.appKey)
+	req.Header.Add("Authorization", "Basic " + s.authorization)
 	req.Header.Add("Accept-Language", "pt-BR")
 	req.SetBodyString(fmt.Sprintf(sinchVerifyJsonTemplate, receivedCode))
 	resp := fasthttp.AcquireResponse()
 	client := &fasthttp.Client{}
-	if err := client.Do(req, resp); err != nil {
-		result = *smsproviders.NewSmsResult(smsproviders.NoSuccess, SinchVerifyErrorCode, err.Error(), "")
+	if err := client.DoTimeout(req, resp, s.timeout); err != nil {
+		result = *smsproviders.NewRetryableSmsResult(SinchVerifyErrorCode, err.Error(), "")
 	} else {
 		result = s.CheckVerifyResponse(resp.Body())
 	}
@@ -301,7 +376,46 @@ func (s *SinchSmsVerifier) CheckVerifyResponse(content []byte) (result smsprovid
 			}
 		}
 	} else {
-		result = *smsproviders.NewSmsResult(smsproviders.NoSuccess, SinchParseErrorCode, err.Error(), "")
+		result = *smsproviders.NewRetryableSmsResult(SinchParseErrorCode, err.Error(), "")
 	}
 	return result
 }
+
+func translateDeliveryStatus(status string) string {
+	switch status {
+	case "Queued", "Dispatched":
+		return smsproviders.DeliverySent
+	case "Delivered":
+		return smsproviders.DeliveryDelivered
+	case "Aborted", "Rejected", "Failed", "Cancelled", "Deleted":
+		return smsproviders.DeliveryFailed
+	case "Expired":
+		return smsproviders.DeliveryExpired
+	default:
+		return smsproviders.DeliveryUnknown
+	}
+}
+
+func (s *SinchSmsVerifier) CheckDeliveryReport(content []byte) ([]smsproviders.DeliveryReport, error) {
+	var dlr TSinchDeliveryReport
+	if err := json.Unmarshal(content, &dlr); err != nil {
+		return nil, err
+	}
+	if dlr.BatchId == "" {
+		return nil, errors.New("batch_id não informado")
+	}
+	var reports []smsproviders.DeliveryReport
+	switch dlr.Type {
+	case "recipient_delivery_report_sms":
+		reports = append(reports, smsproviders.DeliveryReport{SmsId: dlr.BatchId, PhoneNumber: dlr.Recipient,
+			Status: translateDeliveryStatus(dlr.Status), ProviderStatus: dlr.Status, Timestamp: dlr.At})
+	case "delivery_report_sms":
+		for _, status := range dlr.Statuses {
+			reports = append(reports, smsproviders.DeliveryReport{SmsId: dlr.BatchId, PhoneNumber: strings.Join(status.Recipients, ","),
+				Status: translateDeliveryStatus(status.Status), ProviderStatus: status.Status, Timestamp: time.Now().Format(time.RFC3339)})
+		}
+	default:
+		return nil, errors.New("Tipo de relatório não suportado: " + dlr.Type)
+	}
+	return reports, nil
+}
@@ -4,6 +4,15 @@ const (
 	Success   = true
 	SuccessCode = 0
 	NoSuccess = false
+
+	NoProviderErrorCode = 30 //Nenhum provider configurado ou todos falharam
+
+	//Status de entrega normalizados entre providers
+	DeliverySent      = "sent"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+	DeliveryExpired   = "expired"
+	DeliveryUnknown   = "unknown"
 )
 
 type SmsResult struct {
@@ -11,22 +20,44 @@ type SmsResult struct {
 	Code		int
 	Msg			string
 	Data		interface{}
+	//Retryable indica falha transitória do provider (comunicação, crédito, capacidade), em que vale tentar o próximo provider
+	Retryable	bool
+	//Segments é o número de partes cobradas do SMS enviado (ver CountSegments). 0: não informado, conta como 1
+	Segments	int
 }
 
 func NewSmsResult(isSuccess bool, code int, msg string, data interface{}) *SmsResult {
 	return &SmsResult{IsSuccess: isSuccess, Code: code, Msg: msg, Data: data}
 }
 
+func NewRetryableSmsResult(code int, msg string, data interface{}) *SmsResult {
+	return &SmsResult{IsSuccess: NoSuccess, Code: code, Msg: msg, Data: data, Retryable: true}
+}
+
+//DeliveryReport é um relatório de entrega (DLR) recebido do provider, já normalizado
+type DeliveryReport struct {
+	SmsId          string
+	PhoneNumber    string
+	Status         string
+	ProviderStatus string
+	Timestamp      string
+}
+
 type SmsProviderIntf interface {
 	ProviderName() string
+	//HasVerificationApi indica se o fornecedor gera e valida o código. Se não, a verificação usa o OTP local (pacote otp)
+	HasVerificationApi() bool
 
 	SendVerificationRequest(phoneNumber string, content string, hashCode string) (result SmsResult)
 	CheckSendVerificationResponse(content []byte) (result SmsResult)
 
-	/*SendMessageRequest(phoneNumber string, content string, hashCode string) (result SmsResult)
-	CheckSendMessageResponse(content []byte) (result SmsResult)*/
+	//SendMessageRequest envia SMS de texto. senderId vazio usa o remetente configurado no provider
+	SendMessageRequest(phoneNumber string, content string, hashCode string, senderId string) (result SmsResult)
+	CheckSendMessageResponse(content []byte) (result SmsResult)
 
 	VerifyRequest(phoneNumber string, sentCode string, receivedCode string) (result SmsResult)
 	CheckVerifyResponse(content []byte) (result SmsResult)
 
+	//CheckDeliveryReport interpreta o payload do callback de entrega do provider
+	CheckDeliveryReport(content []byte) ([]DeliveryReport, error)
 }
@@ -2,21 +2,21 @@ package zenviaprovider
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
 	"github.com/valyala/fasthttp"
 	"log"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	ZenviaProviderName 					= "Zenvia"
-	zenviaAppKey 						= "hKp94crjv9OF3UGrCpSXUJw1-UYHhRvLKNLt"
-	zenviaSendURI 						= "https://api.zenvia.com/v1/channels/sms/messages"
-	//zenviaVerifyURI = "%s"
-	zenviaSendSmsTemplate              	=  `{"from":"Gaudium","to":"%s","contents":[{"type": "text","text": "%s"},{"type": "text","text": "%s"}]}`
-	zenviaSendVerificationJsonTemplate 	= `{"from":"Gaudium","to":"%s","contents":[{"type": "text","text": "%s"},{"type": "text","text": "%s"}]}`
-	zenviaVerifyResponseJsonTemplate   	= `{"method": "sms","sms": { "code": "%s" }}`
+	zenviaBaseURI 						= "https://api.zenvia.com/v1"
+	zenviaSendPath 						= "/channels/sms/messages"
 
 	ZENVIA_VERIFY_SUCCESS      = "SUCCESSFUL"
 	ZENVIA_FAILED              = 1
@@ -26,53 +26,113 @@ const (
 	ZENVIA_VERIFY_ERROR_CODE   = 20 //
 )
 
+type TZenviaContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type TZenviaSendMessageRequest struct {
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Contents []TZenviaContent `json:"contents"`
+}
+
+//Sucesso: id preenchido. Erro: code e message preenchidos
 type TZenviaSendMessageResponse struct {
-	Message string
-	Status string
+	Id      string `json:"id"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+//TZenviaStatusEvent ------ Callback de status (type MESSAGE_STATUS)
+type TZenviaMessageStatus struct {
+	Timestamp   string `json:"timestamp"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+type TZenviaStatusEvent struct {
+	Type          string               `json:"type"`
+	MessageId     string               `json:"messageId"`
+	MessageStatus TZenviaMessageStatus `json:"messageStatus"`
 }
 
+//------
+
 type ZenviaSmsVerifier struct {
 	providerName string
 	appKey string
 	sendUri string
+	from string
+	timeout time.Duration
 }
 
-func NewZenviaSmsVerifier() smsproviders.SmsProviderIntf {
-	result := &ZenviaSmsVerifier{ZenviaProviderName,zenviaAppKey, zenviaSendURI}
-	return result
+func init() {
+	smsproviders.Register(ZenviaProviderName, []smsproviders.ConfigField{
+		{Name: "AppKey", Required: true, Secret: true}, //X-API-TOKEN
+		{Name: "BaseUrl", Default: zenviaBaseURI, Required: true},
+		{Name: "SenderId"}, //Remetente das bandeiras sem SenderId próprio
+	}, NewZenviaSmsVerifier)
+}
+
+func NewZenviaSmsVerifier(cfg util.ProviderOptions) (smsproviders.SmsProviderIntf, error) {
+	result := &ZenviaSmsVerifier{ZenviaProviderName, cfg.AppKey, cfg.BaseUrl + zenviaSendPath, cfg.SenderId, cfg.Timeout()}
+	return result, nil
 }
 
 func (s *ZenviaSmsVerifier) ProviderName() string {
 	return s.providerName
 }
 
+//Zenvia não tem API de verificação: o código é gerado, enviado via SendMessageRequest e validado pelo pacote otp
+func (s *ZenviaSmsVerifier) HasVerificationApi() bool {
+	return false
+}
+
 func (s *ZenviaSmsVerifier) SendVerificationRequest(phoneNumber string, content string, hashCode string) (result smsproviders.SmsResult) {
-	log.Println("SmsSendMessage: " + content + " / " + hashCode)
-	return s.SendMessageRequest(phoneNumber, content, hashCode)
+	log.Println("SmsSendVerificationRequest: Zenvia não possui API de verificação")
+	return *smsproviders.NewRetryableSmsResult(ZENVIA_SEND_VR_ERROR_CODE, "Verificação não suportada pelo provider", "")
 }
 
 func (s *ZenviaSmsVerifier) CheckSendVerificationResponse(content []byte) (result smsproviders.SmsResult) {
-	result = s.CheckSendVerificationResponse(content)
+	result = s.CheckSendMessageResponse(content)
 	log.Println("CheckSendVerificationResponse: " + strconv.FormatBool(result.IsSuccess) + ":" + result.Msg + ":" + fmt.Sprintf("%v", result.Data))
 	return result
 }
 
-func (s *ZenviaSmsVerifier) SendMessageRequest(phoneNumber string, content string, hashCode string) (result smsproviders.SmsResult) {
-	log.Print("SmsSendVerificationRequest")
+func (s *ZenviaSmsVerifier) SendMessageRequest(phoneNumber string, content string, hashCode string, senderId string) (result smsproviders.SmsResult) {
+	log.Print("SmsSendMessageRequest")
 
+	text := smsproviders.MessageText(content, hashCode)
+	from := s.from
+	if senderId != "" {
+		from = senderId
+	}
+	if from == "" {
+		return *smsproviders.NewRetryableSmsResult(ZENVIA_SEND_SMS_ERROR_CODE, "Remetente (SenderId) não configurado", "")
+	}
+	//Zenvia espera o número sem o "+"
+	reqBody, _ := json.Marshal(TZenviaSendMessageRequest{From: from, To: strings.TrimPrefix(phoneNumber, "+"), Contents: []TZenviaContent{{Type: "text", Text: text}}})
 	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
 	req.SetRequestURI(s.sendUri)
 	req.Header.SetMethod("POST")
 	req.Header.SetContentType("application/json")
-	req.Header.Add("X-API-TOKEN", zenviaAppKey)
-	req.SetBodyString(fmt.Sprint(zenviaSendVerificationJsonTemplate, phoneNumber, content, hashCode))
+	req.Header.Add("X-API-TOKEN", s.appKey)
+	req.SetBody(reqBody)
 	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
 	client := &fasthttp.Client{}
-	if err := client.Do(req, resp); err != nil {
-		result = *smsproviders.NewSmsResult(smsproviders.NoSuccess, ZENVIA_SEND_SMS_ERROR_CODE, err.Error(), "")
+	if err := client.DoTimeout(req, resp, s.timeout); err != nil {
+		result = *smsproviders.NewRetryableSmsResult(ZENVIA_SEND_SMS_ERROR_CODE, err.Error(), "")
 	} else {
-		bodyBytes := resp.Body()
-		result = s.CheckSendMessageResponse(bodyBytes)
+		result = s.CheckSendMessageResponse(resp.Body())
+		if !result.IsSuccess && (resp.StatusCode() == fasthttp.StatusTooManyRequests || resp.StatusCode() >= fasthttp.StatusInternalServerError) {
+			result.Retryable = true
+		}
+	}
+	if result.IsSuccess {
+		result.Segments = smsproviders.CountSegments(text)
 	}
 	return result
 }
@@ -81,25 +141,50 @@ func (s *ZenviaSmsVerifier) CheckSendMessageResponse(content []byte) (result sms
 	var vResp TZenviaSendMessageResponse
 	err := json.Unmarshal(content, &vResp)
 	if err == nil {
-		if vResp.Status == "***" {
-			result = *smsproviders.NewSmsResult(smsproviders.Success, smsproviders.SuccessCode, vResp.Message, vResp.Status)
+		if vResp.Id != "" {
+			result = *smsproviders.NewSmsResult(smsproviders.Success, smsproviders.SuccessCode, "SMS enviado com sucesso", vResp.Id)
 		} else {
-			result = *smsproviders.NewSmsResult(smsproviders.NoSuccess, ZENVIA_SEND_SMS_ERROR_CODE, vResp.Message, vResp.Status)
+			result = *smsproviders.NewSmsResult(smsproviders.NoSuccess, ZENVIA_SEND_SMS_ERROR_CODE, vResp.Message, vResp.Code)
 		}
+	} else {
+		result = *smsproviders.NewRetryableSmsResult(ZENVIA_PARSE_ERROR_CODE, err.Error(), "")
 	}
 	return result
 }
 
 func (s *ZenviaSmsVerifier) VerifyRequest(phoneNumber string, sentCode string, receivedCode string) (result smsproviders.SmsResult) {
-	log.Print("SmsVerifyRequest")
-
-	if sentCode != receivedCode {
-		return *smsproviders.NewSmsResult(smsproviders.Success, ZENVIA_FAILED, "Código inválido", "")
-	} else {
-		return *smsproviders.NewSmsResult(smsproviders.Success, smsproviders.SuccessCode, "Validado com sucesso", "")
-	}
+	log.Print("SmsVerifyRequest: Zenvia não possui API de verificação")
+	return *smsproviders.NewSmsResult(smsproviders.NoSuccess, ZENVIA_VERIFY_ERROR_CODE, "Código expirado. Solicite um novo SMS", "")
 }
 
 func (s *ZenviaSmsVerifier) CheckVerifyResponse(content []byte) (result smsproviders.SmsResult) {
 	return s.CheckSendMessageResponse(content)
 }
+
+func translateDeliveryStatus(code string) string {
+	switch code {
+	case "SENT":
+		return smsproviders.DeliverySent
+	case "DELIVERED", "READ":
+		return smsproviders.DeliveryDelivered
+	case "NOT_DELIVERED", "REJECTED":
+		return smsproviders.DeliveryFailed
+	default:
+		return smsproviders.DeliveryUnknown
+	}
+}
+
+func (s *ZenviaSmsVerifier) CheckDeliveryReport(content []byte) ([]smsproviders.DeliveryReport, error) {
+	var event TZenviaStatusEvent
+	if err := json.Unmarshal(content, &event); err != nil {
+		return nil, err
+	}
+	if event.Type != "MESSAGE_STATUS" {
+		return nil, errors.New("Tipo de evento não suportado: " + event.Type)
+	}
+	if event.MessageId == "" {
+		return nil, errors.New("messageId não informado")
+	}
+	return []smsproviders.DeliveryReport{{SmsId: event.MessageId, Status: translateDeliveryStatus(event.MessageStatus.Code),
+		ProviderStatus: event.MessageStatus.Code, Timestamp: event.MessageStatus.Timestamp}}, nil
+}
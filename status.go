@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	db "gaudium.com.br/gaudiumsoftware/sms/redisDb"
+	"gaudium.com.br/gaudiumsoftware/sms/smsproviders"
+	"gaudium.com.br/gaudiumsoftware/sms/util"
+	"github.com/valyala/fasthttp"
+	"sort"
+)
+
+// messageStatusHandler monta a linha do tempo dos SMS de um telefone (ou de um único smsId) para o suporte
+func messageStatusHandler(ctx *fasthttp.RequestCtx) {
+	sReq, err := util.NewStatusRequest(ctx.Request.Body())
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INVALID_JSON_READ, err.Error()))
+		return
+	}
+	var smsIds []string
+	if sReq.SmsId != "" {
+		msgData, err := db.ReadMessage(sReq.SmsId)
+		if err != nil {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisNotFoundError, "A leitura da mensagem falhou", err.Error()))
+			return
+		}
+		if msgData == nil {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisNotFoundError, "Mensagem não encontrada", ""))
+			return
+		}
+		sReq.PhoneNumber = msgData.PhoneNumber
+		sReq.Bandeira = msgData.Bandeira
+		smsIds = []string{sReq.SmsId}
+	} else if (sReq.PhoneNumber != "") && (sReq.Bandeira != "") {
+		if !normalizePhone(ctx, sReq.Bandeira, &sReq.PhoneNumber) {
+			return
+		}
+		smsIds, err = db.ReadMessageHistory(&sReq.PhoneNumber, &sReq.Bandeira)
+		if err != nil {
+			util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(db.RedisNotFoundError, "A leitura do histórico falhou", err.Error()))
+			return
+		}
+	} else {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INVALID_JSON_READ, "Informe smsId ou phoneNumber e bandeira"))
+		return
+	}
+
+	status := util.TStatusResponse{PhoneNumber: sReq.PhoneNumber, Bandeira: sReq.Bandeira, Messages: []util.TMessageTimeline{}}
+	reqData, err := db.ReadRequest(&sReq.PhoneNumber, &sReq.Bandeira)
+	if (err == nil) && (reqData != nil) && (reqData.IdPedidoEnvio != "") {
+		status.Pending = &util.TPendingRequest{IdPedidoEnvio: reqData.IdPedidoEnvio, SmsId: reqData.SmsId, Provider: reqData.Provider,
+			TimestampSend: reqData.TimestampSend, FailedAttempts: reqData.FailedAttempts, Locked: reqData.Locked}
+	}
+	for _, smsId := range smsIds {
+		msgData, err := db.ReadMessage(smsId)
+		if (err != nil) || (msgData == nil) {
+			continue
+		}
+		status.Messages = append(status.Messages, newMessageTimeline(msgData))
+	}
+
+	bts, err := json.Marshal(status)
+	if err != nil {
+		util.SendResponse(ctx, fasthttp.StatusOK, newErrorResponseFromValues(util.CD_INVALID_JSON, util.MSG_INAVLID_JSON_WRITE, err.Error()))
+		return
+	}
+	util.SendResponse(ctx, fasthttp.StatusOK, newOkResponseFromValues("OK", string(bts)))
+}
+
+func newMessageTimeline(msgData *db.MessageData) util.TMessageTimeline {
+	timeline := util.TMessageTimeline{SmsId: msgData.SmsId, Provider: msgData.Provider, Status: msgData.Status,
+		ProviderStatus: msgData.ProviderStatus, VerifyAttempts: msgData.VerifyAttempts, Verified: msgData.TimestampVerify != ""}
+	timeline.Events = append(timeline.Events, util.TTimelineEvent{Timestamp: msgData.TimestampSend, Event: "sent", Detail: msgData.Provider})
+	if msgData.Status != smsproviders.DeliverySent {
+		timeline.Events = append(timeline.Events, util.TTimelineEvent{Timestamp: msgData.TimestampStatus, Event: msgData.Status, Detail: msgData.ProviderStatus})
+	}
+	if timeline.Verified {
+		timeline.Events = append(timeline.Events, util.TTimelineEvent{Timestamp: msgData.TimestampVerify, Event: "verified"})
+		//O token temporário é gerado junto com a verificação e consumido pelo cadastro via findToken
+		tokenDetail := "expirado"
+		if ttl, err := db.TempTokenTTL(msgData.SmsId); (err == nil) && (ttl > 0) {
+			timeline.TokenTTL = ttl
+			tokenDetail = "disponível"
+		}
+		timeline.Events = append(timeline.Events, util.TTimelineEvent{Timestamp: msgData.TimestampVerify, Event: "token", Detail: tokenDetail})
+	}
+	sort.SliceStable(timeline.Events, func(i, j int) bool { return timeline.Events[i].Timestamp < timeline.Events[j].Timestamp })
+	return timeline
+}
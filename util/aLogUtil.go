@@ -1,52 +1,148 @@
 package util
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
 )
 
 var logEnabled = true
 var logOptionsEnabled = "D;I;W;E" //D=Debug; I=Info, W=Warn, E=Error
+var logLevels = parseLogOptions(logOptionsEnabled)
+
+var logMutex sync.Mutex
+var logOutput io.Writer = os.Stderr
+
+// TLogEntry é uma linha do log: um objeto JSON por linha
+type TLogEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Msg       string `json:"msg"`
+	RequestId string `json:"requestId,omitempty"`
+	Bandeira  string `json:"bandeira,omitempty"`
+	Provider  string `json:"provider,omitempty"`
+}
+
+// Logger grava com os campos da requisição. O valor zero é o log geral (LogD, LogI, LogW, LogE).
+type Logger struct {
+	RequestId string
+	Bandeira  string
+	Provider  string
+}
+
+func NewLogger(requestId string) Logger {
+	return Logger{RequestId: requestId}
+}
+
+func (l Logger) WithBandeira(bandeira string) Logger {
+	l.Bandeira = bandeira
+	return l
+}
+
+func (l Logger) WithProvider(provider string) Logger {
+	l.Provider = provider
+	return l
+}
 
-func doLog(levelLabel string, Msg string) {
-	if logEnabled || (levelLabel == "L") {
-		log.Printf("%s: %s", levelLabel, Msg)
+func (l Logger) D(msg string) {
+	l.log(LevelDebug, msg)
+}
+
+func (l Logger) I(msg string) {
+	l.log(LevelInfo, msg)
+}
+
+func (l Logger) W(msg string) {
+	l.log(LevelWarn, msg)
+}
+
+func (l Logger) E(msg string) {
+	l.log(LevelError, msg)
+}
+
+func (l Logger) log(level string, msg string) {
+	if logLevels[level] {
+		l.write(level, msg)
+	}
+}
+
+func (l Logger) write(level string, msg string) {
+	if !logEnabled {
+		return
+	}
+	entry := TLogEntry{Time: time.Now().Format(time.RFC3339Nano), Level: level, Msg: MaskPII(msg),
+		RequestId: l.RequestId, Bandeira: l.Bandeira, Provider: l.Provider}
+	var line bytes.Buffer
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(entry); err != nil {
+		line.Reset()
+		fmt.Fprintf(&line, "{\"time\":%q,\"level\":%q,\"msg\":%q}\n", entry.Time, LevelError, err.Error())
 	}
+	logMutex.Lock()
+	logOutput.Write(line.Bytes())
+	logMutex.Unlock()
+}
+
+// SetLogOutput troca o destino do log (ver InitLog)
+func SetLogOutput(w io.Writer) {
+	logMutex.Lock()
+	logOutput = w
+	logMutex.Unlock()
+}
+
+// As mensagens do pacote log (ex: log.Println, log.Fatal) também saem em JSON, no mesmo destino
+func init() {
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+}
+
+// stdLogWriter recebe as linhas do pacote log (ex: log.Println, log.Fatal) e grava como info
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	Logger{}.write(LevelInfo, strings.TrimRight(string(p), "\n"))
+	return len(p), nil
 }
 
 //Sempre loga, independente das options, se logEnabled = true
 func Log(msg string) {
-	doLog("L", msg)
+	Logger{}.write(LevelInfo, msg)
 }
 
 func LogConsole(msg string) {
-	doLog("L", msg)
-	fmt.Println(msg)
+	Log(msg)
+	fmt.Println(MaskPII(msg))
 }
 
 func LogD(Msg string) {
-	if strings.ContainsAny(logOptionsEnabled, "Dd") {
-		doLog("D", Msg)
-	}
+	Logger{}.D(Msg)
 }
 
 func LogI(Msg string) {
-	if strings.ContainsAny(logOptionsEnabled, "Ii") {
-		doLog("I", Msg)
-	}
+	Logger{}.I(Msg)
 }
 
 func LogW(Msg string) {
-	if strings.ContainsAny(logOptionsEnabled, "Ww") {
-		doLog("W", Msg)
-	}
+	Logger{}.W(Msg)
 }
 
 func LogE(Msg string) {
-	if strings.ContainsAny(logOptionsEnabled, "Ee") {
-		doLog("E", Msg)
-	}
+	Logger{}.E(Msg)
 }
 
 func SetLogEnabled(newValue bool) {
@@ -57,6 +153,69 @@ func GetLogEnabled() bool {
 	return logEnabled
 }
 
+// SetLogOptions define os níveis gravados: letras ou nomes separados por vírgula, ponto e vírgula ou espaço.
+// Ex: "D,I,W,E", "warn;error"
 func SetLogOptions(newValue string) {
 	logOptionsEnabled = newValue
-}
\ No newline at end of file
+	logLevels = parseLogOptions(newValue)
+}
+
+func parseLogOptions(options string) map[string]bool {
+	levels := map[string]bool{}
+	for _, option := range strings.FieldsFunc(strings.ToLower(options), func(r rune) bool {
+		return (r == ',') || (r == ';') || (r == ' ')
+	}) {
+		switch option {
+		case "d", LevelDebug:
+			levels[LevelDebug] = true
+		case "i", LevelInfo:
+			levels[LevelInfo] = true
+		case "w", LevelWarn, "warning":
+			levels[LevelWarn] = true
+		case "e", LevelError:
+			levels[LevelError] = true
+		}
+	}
+	return levels
+}
+
+var (
+	//Trechos separados por pontuação: chaves do Redis (sms:rq:<bd>:<pn>), JSON, "pn: <telefone>"
+	logTokenPattern = regexp.MustCompile(`[+0-9A-Za-z_-]+`)
+	phonePattern    = regexp.MustCompile(`^\+?[0-9]{10,15}$`)
+	//Códigos de verificação depois de uma palavra-chave. Ex: "code": "123456", cv: 1234, código de verificação é 123456
+	otpCodePattern = regexp.MustCompile(`(?i)(c[oó]digo|code|otp|cv|validationcode)([^0-9A-Za-z]{1,4}|[^0-9]{1,40}?\s)([0-9]{4,8})\b`)
+	authPattern    = regexp.MustCompile(`(?i)\b(basic|bearer|application)\s+[A-Za-z0-9+/=._:-]{8,}`)
+)
+
+// MaskPII mascara telefones (mantém os 4 últimos dígitos), códigos de verificação e credenciais de Authorization.
+// Aplicada a todas as mensagens do log. Números de 10 a 15 dígitos isolados são tratados como telefone.
+// É uma rede de proteção: telefones formatados e textos de SMS não são reconhecidos, então o conteúdo das mensagens
+// e os corpos das requisições não devem ser logados, e os telefones ainda não normalizados passam por MaskPhone.
+func MaskPII(msg string) string {
+	msg = authPattern.ReplaceAllString(msg, "$1 ***")
+	msg = otpCodePattern.ReplaceAllString(msg, "$1$2***")
+	return logTokenPattern.ReplaceAllStringFunc(msg, func(token string) string {
+		if !phonePattern.MatchString(token) {
+			return token
+		}
+		return MaskPhone(token)
+	})
+}
+
+// MaskPhone mantém o + e os 4 últimos dígitos do telefone
+func MaskPhone(phoneNumber string) string {
+	if len(phoneNumber) <= 4 {
+		return phoneNumber
+	}
+	prefix := ""
+	digits := phoneNumber
+	if strings.HasPrefix(digits, "+") {
+		prefix = "+"
+		digits = digits[1:]
+	}
+	if len(digits) <= 4 {
+		return phoneNumber
+	}
+	return prefix + strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+}
@@ -10,6 +10,11 @@ const (
 	MSG_INVALID_JSON_READ  string = "Informação inválida para leitura"
 	MSG_INAVLID_JSON_WRITE string = "Informação inválida para escrita"
 
+	//Faixa 120: verificação do código. Os códigos baixos (1, 2, 10, 11, 20) são dos providers
+	CD_WRONG_CODE     int    = 120
+	CD_VERIFY_LOCKED  int    = 121
+	MSG_VERIFY_LOCKED string = "Número máximo de tentativas atingido. Solicite um novo SMS"
+
 	/*MSG_SMS_SENT string = "SMS enviado"
 	MSG_SMS_WAIT string = "Aguarde a chegada do SMS"
 	MSG_SMS_EXPIRED string = "Envio expirado, tente novamente"
@@ -73,6 +78,198 @@ func NewFindTokenResponseToJson(phoneNumber string, validationCode string) (stri
 
 //------
 
+//TBatchRequest ------
+type TBatchRecipient struct {
+	PhoneNumber string            `json:"phoneNumber"`
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+type TBatchRequest struct {
+	Bandeira   string            `json:"bandeira"`
+	Template   string            `json:"template"`
+	Recipients []TBatchRecipient `json:"recipients"`
+}
+
+func NewBatchRequest(content []byte) (result TBatchRequest, err error) {
+	err = json.Unmarshal(content, &result)
+	return result, err
+}
+
+//Conteúdo da mensagem para o destinatário: cada {{chave}} do template é substituída por Params[chave]
+func (r TBatchRecipient) Render(template string) string {
+	for k, v := range r.Params {
+		template = strings.Replace(template, "{{"+k+"}}", v, -1)
+	}
+	return template
+}
+
+type TBatchRecipientResult struct {
+	Index       int    `json:"index"` //Posição do destinatário em recipients
+	PhoneNumber string `json:"phoneNumber"`
+	Success     bool   `json:"success"`
+	Code        int    `json:"code"`
+	Msg         string `json:"msg"`
+	Provider    string `json:"provider"`
+	SmsId       string `json:"smsId"`
+}
+
+type TBatchJobStatus struct {
+	Id       string                  `json:"id"`
+	Bandeira string                  `json:"bandeira"`
+	Status   string                  `json:"status"`
+	Total    int                     `json:"total"`
+	Sent     int                     `json:"sent"`
+	Failed   int                     `json:"failed"`
+	Created  string                  `json:"created"`
+	Finished string                  `json:"finished,omitempty"`
+	Results  []TBatchRecipientResult `json:"results,omitempty"`
+}
+
+//TStatusRequest ------ Informar phoneNumber e bandeira ou apenas smsId
+type TStatusRequest struct {
+	PhoneNumber string `json:"phoneNumber"`
+	Bandeira    string `json:"bandeira"`
+	SmsId       string `json:"smsId"`
+}
+
+func NewStatusRequest(content []byte) (result TStatusRequest, err error) {
+	err = json.Unmarshal(content, &result)
+	return result, err
+}
+
+type TTimelineEvent struct {
+	Timestamp string `json:"timestamp"`
+	Event     string `json:"event"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+type TMessageTimeline struct {
+	SmsId          string           `json:"smsId"`
+	Provider       string           `json:"provider"`
+	Status         string           `json:"status"`
+	ProviderStatus string           `json:"providerStatus,omitempty"`
+	VerifyAttempts int              `json:"verifyAttempts"`
+	Verified       bool             `json:"verified"`
+	TokenTTL       int              `json:"tokenTTL"`
+	Events         []TTimelineEvent `json:"events"`
+}
+
+type TPendingRequest struct {
+	IdPedidoEnvio  string `json:"idPedidoEnvio"`
+	SmsId          string `json:"smsId"`
+	Provider       string `json:"provider"`
+	TimestampSend  string `json:"timestampSend"`
+	FailedAttempts int    `json:"failedAttempts"`
+	Locked         bool   `json:"locked"`
+}
+
+type TStatusResponse struct {
+	PhoneNumber string             `json:"phoneNumber"`
+	Bandeira    string             `json:"bandeira"`
+	Pending     *TPendingRequest   `json:"pending,omitempty"`
+	Messages    []TMessageTimeline `json:"messages"`
+}
+
+//TFraudListRequest ------ action: allow, deny ou remove; type: number, prefix ou ip
+type TFraudListRequest struct {
+	Action   string `json:"action"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Bandeira string `json:"bandeira,omitempty"` //Completa o país dos números sem código do país
+}
+
+func NewFraudListRequest(content []byte) (result TFraudListRequest, err error) {
+	err = json.Unmarshal(content, &result)
+	if err == nil {
+		result.Value = strings.TrimSpace(result.Value)
+	}
+	return result, err
+}
+
+//Envio retido para revisão
+type TFraudReview struct {
+	PhoneNumber string   `json:"phoneNumber"`
+	Bandeira    string   `json:"bandeira"`
+	Ip          string   `json:"ip"`
+	Score       int      `json:"score"`
+	Signals     []string `json:"signals"`
+	Timestamp   string   `json:"timestamp"`
+}
+
+type TFraudListsResponse struct {
+	Allow   []string       `json:"allow"`
+	Deny    []string       `json:"deny"`
+	Reviews []TFraudReview `json:"reviews"`
+}
+
+//Alerta de cota mensal. level: warn (aviso) ou block (cota esgotada)
+type TQuotaAlert struct {
+	Bandeira  string `json:"bandeira"`
+	Level     string `json:"level"`
+	Month     string `json:"month"`
+	Used      int64  `json:"used"`
+	Limit     int    `json:"limit"`
+	Quota     int    `json:"quota"`
+	Timestamp string `json:"timestamp"`
+}
+
+//Uso de uma bandeira num mês, por provider e país de destino. Provider vazio: envios contados antes do detalhe
+//por provider; país vazio: antes do detalhe por país ou fora da tabela de países.
+type TUsageRow struct {
+	Month     string  `json:"month"`
+	Bandeira  string  `json:"bandeira"`
+	Provider  string  `json:"provider"`
+	Country   string  `json:"country"`
+	Sent      int64   `json:"sent"`
+	Segments  int64   `json:"segments"`
+	Verified  int64   `json:"verified"`
+	Failed    int64   `json:"failed"`
+	UnitPrice float64 `json:"unitPrice"`
+	Cost      float64 `json:"cost"`
+}
+
+type TUsageReport struct {
+	From          string      `json:"from"`
+	To            string      `json:"to"`
+	Bandeira      string      `json:"bandeira,omitempty"`
+	Currency      string      `json:"currency"`
+	Rows          []TUsageRow `json:"rows"`
+	TotalSent     int64       `json:"totalSent"`
+	TotalSegments int64       `json:"totalSegments"`
+	TotalCost     float64     `json:"totalCost"`
+}
+
+//Situação de um provider: Available indica se foi instanciado (credenciais válidas); Circuit é closed, open ou
+//half-open (ver smsproviders.CircuitState)
+type TProviderHealth struct {
+	Provider            string `json:"provider"`
+	Available           bool   `json:"available"`
+	Circuit             string `json:"circuit"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	LastSuccess         string `json:"lastSuccess,omitempty"`
+	LastFailure         string `json:"lastFailure,omitempty"`
+	LastError           string `json:"lastError,omitempty"`
+	OpenUntil           string `json:"openUntil,omitempty"`
+}
+
+type THealthCheck struct {
+	Ok        bool   `json:"ok"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+//Resposta de /ready. Status: ready, degraded (envia, mas o logmachine não responde) ou unavailable
+type TReadiness struct {
+	Status     string            `json:"status"`
+	Redis      THealthCheck      `json:"redis"`
+	LogMachine THealthCheck      `json:"logMachine"`
+	Chain      string            `json:"chain"`
+	Providers  []TProviderHealth `json:"providers"`
+	Outbox     map[string]int64  `json:"outbox,omitempty"`
+}
+
+//------
+
 type TResponse struct {
 	Success bool   `json:"success"`
 	Code    int    `json:"code"`
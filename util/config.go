@@ -1,5 +1,12 @@
 package util
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 const (
 	DefaultHttpPort		= 80
 	DefaultConfigPath   = "./etc/"
@@ -13,17 +20,103 @@ var AppCfg Config
 var DefaultMaxSmsRequestsPerPhone int = 5
 var DefaultResendWaitSecondsBeforeTriesLimitReached int = 45 //45 segundos - Só envia novo SMS com, pelo menos, %d segundos de intervalo, que é o intervalo da validação
 var DefaultResendWaitSecondsAfterTriesLimitReached int = 2   //minutos - Depois de %d tentativas, exige que se aguarde %d minutos para iniciar um novo ciclo de tentativas
+var DefaultMaxVerifyAttempts int = 5                         //Depois de %d códigos errados o pedido é bloqueado e é preciso solicitar novo SMS
+
+const (
+	DefaultOtpCodeLength      = 6
+	DefaultOtpTtlSeconds      = 10 * 60
+	DefaultOtpMessageTemplate = "<#> Seu código de verificação é {code}"
+
+	DefaultBatchConcurrency   = 10
+	DefaultBatchMaxRecipients = 10000
+
+	DefaultProviderTimeoutSeconds = 15
+
+	DefaultShutdownTimeoutSeconds = 30
+
+	DefaultCircuitFailureThreshold = 5
+	DefaultCircuitOpenSeconds      = 30
+
+	DefaultLogMaxSizeMB  = 100
+	DefaultLogMaxBackups = 14
+	DefaultLogMaxAgeDays = 30
 
-func NewConfig(defRedisConnectionString string, defRedisPoolSize int, defRedisDialTimeout int, defaultPort int, defaultMaxSmsRequestsPerPhone int, resendWaitSecondsAfterTriesLimitReached int) Config {
+	DefaultRateLimitPrefixLength = 5
+
+	DefaultCountryCode = "55"
+
+	DefaultQuotaWarnPercent = 80
+
+	DefaultBillingCurrency  = "BRL"
+	DefaultBillingMaxMonths = 24
+
+	DefaultOutboxWorkers          = 2
+	DefaultOutboxMaxAttempts      = 50
+	DefaultOutboxRetryBaseSeconds = 5
+	DefaultOutboxRetryMaxSeconds  = 60 * 60
+
+	DefaultFraudChallengeScore        = 50
+	DefaultFraudBlockScore            = 80
+	DefaultFraudHighRiskPrefixScore   = 40
+	DefaultFraudNumberVelocityPerHour = 3
+	DefaultFraudNumberVelocityScore   = 30
+	DefaultFraudSequentialDistance    = 10
+	DefaultFraudSequentialMinCount    = 3
+	DefaultFraudSequentialScore       = 40
+	DefaultFraudMinConversionSample   = 20
+	DefaultFraudMinConversionRate     = 20
+	DefaultFraudLowConversionScore    = 30
+)
+
+func NewConfig(defRedisConnectionString string, defRedisPoolSize int, defRedisDialTimeout int, defaultPort int, defaultMaxSmsRequestsPerPhone int, resendWaitSecondsAfterTriesLimitReached int, defaultProviderChain string) Config {
 	return Config{DefaultLogPath + DefaultLogFile,
 		"D,I,W,E",
 		true,
 		"http://elb-kdev-microservices.gaudium.lan/api/logmachine",
 		"",
 		"",
-		Redis{defRedisConnectionString, defRedisPoolSize, defRedisDialTimeout},
-		Network{defaultPort},
-		Sms{defaultMaxSmsRequestsPerPhone, resendWaitSecondsAfterTriesLimitReached}}
+		Redis{defRedisConnectionString, defRedisPoolSize, defRedisDialTimeout, false},
+		Network{defaultPort, DefaultShutdownTimeoutSeconds},
+		Sms{SmsSecureRequestIntervalInMinutes: defaultMaxSmsRequestsPerPhone,
+			MaxSmsRequestsPerPhone: resendWaitSecondsAfterTriesLimitReached,
+			ProviderChain:          defaultProviderChain,
+			MaxVerifyAttempts:      DefaultMaxVerifyAttempts},
+		Otp{CodeLength: DefaultOtpCodeLength,
+			TtlSeconds:      DefaultOtpTtlSeconds,
+			MessageTemplate: DefaultOtpMessageTemplate},
+		Messaging{},
+		Batch{Concurrency: DefaultBatchConcurrency,
+			MaxRecipients: DefaultBatchMaxRecipients},
+		Dlr{},
+		RateLimit{PrefixLength: DefaultRateLimitPrefixLength},
+		Phone{DefaultCountryCode: DefaultCountryCode},
+		Fraud{ChallengeScore: DefaultFraudChallengeScore,
+			BlockScore:            DefaultFraudBlockScore,
+			HighRiskPrefixScore:   DefaultFraudHighRiskPrefixScore,
+			NumberVelocityPerHour: DefaultFraudNumberVelocityPerHour,
+			NumberVelocityScore:   DefaultFraudNumberVelocityScore,
+			SequentialDistance:    DefaultFraudSequentialDistance,
+			SequentialMinCount:    DefaultFraudSequentialMinCount,
+			SequentialScore:       DefaultFraudSequentialScore,
+			MinConversionSample:   DefaultFraudMinConversionSample,
+			MinConversionRate:     DefaultFraudMinConversionRate,
+			LowConversionScore:    DefaultFraudLowConversionScore},
+		Quota{WarnPercent: DefaultQuotaWarnPercent},
+		Billing{Currency: DefaultBillingCurrency},
+		Outbox{Workers: DefaultOutboxWorkers,
+			MaxAttempts:      DefaultOutboxMaxAttempts,
+			RetryBaseSeconds: DefaultOutboxRetryBaseSeconds,
+			RetryMaxSeconds:  DefaultOutboxRetryMaxSeconds},
+		Circuit{FailureThreshold: DefaultCircuitFailureThreshold,
+			OpenSeconds: DefaultCircuitOpenSeconds},
+		LogRotation{MaxSizeMB: DefaultLogMaxSizeMB,
+			Daily:      true,
+			MaxBackups: DefaultLogMaxBackups,
+			MaxAgeDays: DefaultLogMaxAgeDays,
+			Compress:   true},
+		map[string]ProviderOptions{},
+		map[string]BandeiraOptions{},
+		map[string]PriceOptions{}}
 }
 
 type Config struct {
@@ -36,19 +129,267 @@ type Config struct {
 	RedisOptions       Redis
 	NetworkOptions     Network
 	SmsOptions         Sms
+	OtpOptions         Otp
+	MessagingOptions   Messaging
+	BatchOptions       Batch
+	DlrOptions         Dlr
+	RateLimitOptions   RateLimit
+	PhoneOptions       Phone
+	FraudOptions       Fraud
+	QuotaOptions       Quota
+	BillingOptions     Billing
+	OutboxOptions      Outbox
+	CircuitOptions     Circuit
+	LogRotationOptions LogRotation
+	Providers          map[string]ProviderOptions //Seções [Providers.<nome do provider>]
+	Bandeiras          map[string]BandeiraOptions //Seções [Bandeiras.<bandeira>]
+	Prices             map[string]PriceOptions    //Seções [Prices.<nome do provider>]
+}
+
+//Bandeira retorna a configuração própria da bandeira. Sem seção, todos os campos ficam com a configuração geral.
+func (c Config) Bandeira(bandeira string) BandeiraOptions {
+	return c.Bandeiras[bandeira]
 }
 
 type Redis struct {
 	RedisConnectionString string
 	RedisPoolSize         int
 	RedisDialTimeout      int
+	InMemory              bool //Modo de desenvolvimento local: guarda os dados em memória, sem Redis
 }
 
 type Network struct {
-	ListeningPort int
+	ListeningPort          int
+	ShutdownTimeoutSeconds int //Prazo para concluir as requisições e entregas em andamento ao receber SIGTERM/SIGINT
 }
 
 type Sms struct {
 	SmsSecureRequestIntervalInMinutes int
 	MaxSmsRequestsPerPhone            int
+	ProviderChain                     string //Providers em ordem de prioridade, separados por vírgula. Ex: "Sinch,Zenvia"
+	MaxVerifyAttempts                 int
+}
+
+type Otp struct {
+	Enabled         bool //Usa o OTP local também nos providers que têm API de verificação
+	CodeLength      int
+	TtlSeconds      int
+	MessageTemplate string //{code} é substituído pelo código gerado
+}
+
+//Envio de SMS de texto (sendSms e lote). A chave é obrigatória: os pedidos enviam ?key=<ApiKey>. Vazia, os endpoints
+//recusam tudo.
+type Messaging struct {
+	ApiKey string
+}
+
+func (o Messaging) String() string {
+	return fmt.Sprintf("{ApiKey:%s}", mask(o.ApiKey))
+}
+
+type Batch struct {
+	Concurrency   int //Envios simultâneos por job
+	MaxRecipients int
+}
+
+type Dlr struct {
+	CallbackKey string //Obrigatória: os callbacks de entrega enviam ?key=<CallbackKey>. Vazia, os callbacks são recusados
+}
+
+func (o Dlr) String() string {
+	return fmt.Sprintf("{CallbackKey:%s}", mask(o.CallbackKey))
+}
+
+//Janelas deslizantes aplicadas antes de qualquer envio de SMS avulso ou de verificação. Limites zerados ficam desativados.
+type RateLimit struct {
+	TrustForwardedFor  bool //Obtém o IP do cliente do X-Forwarded-For (serviço atrás do load balancer)
+	PerIpPerHour       int
+	PerBandeiraPerHour int
+	PerBandeiraPerDay  int
+	PrefixLength       int //Dígitos iniciais do telefone que formam o prefixo. Ex: 5 = país + DDD + primeiro dígito
+	PerPrefixPerHour   int
+	GlobalPerDay       int     //Teto de quantidade de SMS por dia (janela de 24 horas) somando todas as bandeiras
+	GlobalSpendPerDay  float64 //Teto de gasto estimado no dia (moeda de Billing, tabela [Prices]) somando todas as bandeiras
+}
+
+//Telefones sem código do país são completados com o país da bandeira (BandeiraOptions.DefaultCountryCode) ou este
+type Phone struct {
+	DefaultCountryCode string
+}
+
+//Pontuação antifraude dos envios. Os prefixos usam RateLimit.PrefixLength.
+type Fraud struct {
+	Enabled               bool
+	AdminKey              string //Obrigatória para alterar as listas (?key=<AdminKey>). Vazia, as alterações são recusadas
+	ChallengeScore        int    //A partir desta pontuação o envio fica retido para revisão
+	BlockScore            int    //A partir desta pontuação o envio é bloqueado
+	HighRiskPrefixes      string //Prefixos de alto custo ou tarifação premium, separados por vírgula. Ex: "882,883,5511900"
+	HighRiskPrefixScore   int
+	NumberVelocityPerHour int //Pedidos ao mesmo número por hora tolerados
+	NumberVelocityScore   int
+	SequentialDistance    int //Números do mesmo prefixo a até %d de distância de um pedido recente contam como sequenciais
+	SequentialMinCount    int //Quantos números sequenciais recentes pontuam
+	SequentialScore       int
+	MinConversionSample   int //Envios de verificação no prefixo, no dia, para avaliar a conversão
+	MinConversionRate     int //Percentual mínimo de códigos verificados por envio no prefixo
+	LowConversionScore    int
+}
+
+func (o Fraud) String() string {
+	type fraud Fraud //Sem o método String, para formatar os demais campos
+	masked := fraud(o)
+	masked.AdminKey = mask(o.AdminKey)
+	return fmt.Sprintf("%+v", masked)
+}
+
+//Configuração por bandeira. Campos vazios ou zerados usam a configuração geral.
+type BandeiraOptions struct {
+	ProviderChain          string //Providers permitidos, em ordem de prioridade. Ex: "Zenvia,Sinch"
+	SenderId               string //Remetente ("from") dos SMS. Vazio: o SenderId do provider
+	OtpMessageTemplate     string //{code} é substituído pelo código gerado
+	DefaultCountryCode     string
+	AllowedCountries       string //Códigos de país aceitos, separados por vírgula. Ex: "55,351". Vazio: todos
+	MaxSmsRequestsPerPhone int
+	RateLimitPerHour       int //Sobrescreve RateLimit.PerBandeiraPerHour
+	RateLimitPerDay        int //Sobrescreve RateLimit.PerBandeiraPerDay
+	MonthlyQuota           int //SMS por mês; ao atingir, os envios são recusados. 0: sem cota
+	MonthlyQuotaWarn       int //Ao atingir, só emite o alerta. 0: Quota.WarnPercent da cota
+}
+
+//AllowsCountry indica se a bandeira envia para o código de país informado
+func (b BandeiraOptions) AllowsCountry(countryCode string) bool {
+	if strings.TrimSpace(b.AllowedCountries) == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(b.AllowedCountries, ",") {
+		if strings.TrimSpace(allowed) == countryCode {
+			return true
+		}
+	}
+	return false
+}
+
+//Alertas das cotas mensais das bandeiras (BandeiraOptions.MonthlyQuota)
+type Quota struct {
+	WarnPercent int    //Percentual da cota que dispara o alerta de aviso, se a bandeira não tem MonthlyQuotaWarn
+	AlertUrl    string //Se informada, recebe o alerta (TQuotaAlert) por POST quando a bandeira atinge o aviso ou a cota
+}
+
+//Relatório de uso e custo (endpoint /billing e comando report)
+type Billing struct {
+	Currency string
+}
+
+//Entrega dos eventos do histórico (historico_envio_sms, historico_confirmacao_sms) ao LogMachine
+type Outbox struct {
+	Workers          int //Entregas simultâneas
+	MaxAttempts      int //Depois de %d tentativas o evento vai para sms:outbox:dead. 0: tenta até conseguir
+	RetryBaseSeconds int //Espera antes da 2ª tentativa; dobra a cada falha
+	RetryMaxSeconds  int
+}
+
+//Circuito por provider: depois de FailureThreshold falhas transitórias seguidas o provider sai da cadeia por
+//OpenSeconds; passado o prazo, um único envio de teste decide se ele volta.
+type Circuit struct {
+	FailureThreshold int
+	OpenSeconds      int
+}
+
+//Rotação do arquivo de log (LogFileName). Os arquivos antigos ficam ao lado, com a data e hora da rotação no nome.
+type LogRotation struct {
+	MaxSizeMB  int  //Roda ao atingir o tamanho. 0: sem limite
+	Daily      bool //Roda na virada do dia
+	MaxBackups int  //Arquivos antigos mantidos. 0: todos
+	MaxAgeDays int  //Apaga os arquivos antigos com mais de %d dias. 0: não apaga por idade
+	Compress   bool //Compacta os arquivos antigos (gzip)
+}
+
+//Tabela de preços do provider, usada para estimar o custo no relatório de uso. O preço é por parte cobrada do SMS.
+type PriceOptions struct {
+	PerSms    float64
+	Countries map[string]float64 //Preço por país de destino. Seção [Prices.<provider>.Countries]. Ex: 351 = 0.09
+}
+
+//PerSegment retorna o preço de uma parte do SMS para o país ou, se ele não estiver na tabela, PerSms
+func (p PriceOptions) PerSegment(countryCode string) float64 {
+	if price, ok := p.Countries[countryCode]; ok {
+		return price
+	}
+	return p.PerSms
+}
+
+//Chaves e segredos não devem ficar no arquivo versionado: use as variáveis de ambiente (ver ProviderEnvVar)
+type ProviderOptions struct {
+	AppKey         string
+	AppSecret      string
+	BaseUrl        string
+	SenderId       string
+	TimeoutSeconds int
+	Options        map[string]string //Parâmetros específicos do provider. Seção [Providers.<nome>.Options]
+}
+
+//Get retorna um campo comum pelo nome ou, se não for um deles, o parâmetro específico em Options
+func (o ProviderOptions) Get(name string) string {
+	switch name {
+	case "AppKey":
+		return o.AppKey
+	case "AppSecret":
+		return o.AppSecret
+	case "BaseUrl":
+		return o.BaseUrl
+	case "SenderId":
+		return o.SenderId
+	case "TimeoutSeconds":
+		if o.TimeoutSeconds == 0 {
+			return ""
+		}
+		return strconv.Itoa(o.TimeoutSeconds)
+	default:
+		return o.Options[name]
+	}
+}
+
+func (o *ProviderOptions) Set(name string, value string) {
+	switch name {
+	case "AppKey":
+		o.AppKey = value
+	case "AppSecret":
+		o.AppSecret = value
+	case "BaseUrl":
+		o.BaseUrl = value
+	case "SenderId":
+		o.SenderId = value
+	case "TimeoutSeconds":
+		o.TimeoutSeconds, _ = strconv.Atoi(value)
+	default:
+		if o.Options == nil {
+			o.Options = map[string]string{}
+		}
+		o.Options[name] = value
+	}
+}
+
+func (o ProviderOptions) Timeout() time.Duration {
+	if o.TimeoutSeconds <= 0 {
+		return DefaultProviderTimeoutSeconds * time.Second
+	}
+	return time.Duration(o.TimeoutSeconds) * time.Second
+}
+
+//Não exibe chaves e segredos no log (PrintConfig)
+func mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "***"
+}
+
+func (o ProviderOptions) String() string {
+	return fmt.Sprintf("{AppKey:%s AppSecret:%s BaseUrl:%s SenderId:%s TimeoutSeconds:%d Options:%d}",
+		mask(o.AppKey), mask(o.AppSecret), o.BaseUrl, o.SenderId, o.TimeoutSeconds, len(o.Options))
+}
+
+//ProviderEnvVar é a variável de ambiente que sobrescreve um parâmetro secreto do provider. Ex: SMS_SINCH_APPSECRET
+func ProviderEnvVar(providerName string, fieldName string) string {
+	return strings.ToUpper("SMS_" + providerName + "_" + fieldName)
 }
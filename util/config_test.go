@@ -0,0 +1,14 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFraudStringMasksAdminKey(t *testing.T) {
+	printed := fmt.Sprintf("%v", Fraud{Enabled: true, AdminKey: "segredo", BlockScore: 80})
+	if strings.Contains(printed, "segredo") || !strings.Contains(printed, "AdminKey:***") || !strings.Contains(printed, "BlockScore:80") {
+		t.Errorf("Fraud impresso como %q, esperado AdminKey mascarada e os demais campos", printed)
+	}
+}
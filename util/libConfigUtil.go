@@ -4,20 +4,31 @@ import (
 	"fmt"
 	toml "github.com/pelletier/go-toml"
 	"log"
-	"os"
 	"reflect"
 )
 
-func InitLog(logFileName string) *os.File {
-	f, err := os.OpenFile(logFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+var logFile *LogFile
+
+// InitLog abre o arquivo de log com a rotação de AppCfg.LogRotationOptions
+func InitLog(logFileName string) *LogFile {
+	f, err := OpenLogFile(logFileName, AppCfg.LogRotationOptions)
 	if err != nil {
 		log.Fatalf("error opening file: %v", err)
 	}
-	log.SetOutput(f)
+	logFile = f
+	SetLogOutput(f)
 	log.Println("Starting sms server")
 	return f
 }
 
+// ReopenLog reabre o arquivo de log pelo nome (SIGHUP), depois de uma rotação externa
+func ReopenLog() error {
+	if logFile == nil {
+		return nil
+	}
+	return logFile.Reopen()
+}
+
 func loadConfigSections(conf *toml.Tree, configStruct interface{}) {
 	t := reflect.TypeOf(configStruct)
     v := reflect.ValueOf(configStruct)
@@ -30,6 +41,8 @@ func loadConfigSections(conf *toml.Tree, configStruct interface{}) {
 					if v.Elem().Field(i).CanInterface() && v.Elem().Field(i).CanAddr() {
 						loadConfigSections(conf, v.Elem().Field(i).Addr().Interface())
 					}
+				} else if v.Elem().Field(i).Kind() == reflect.Map {
+					loadConfigMap(conf, typeOfS.Field(i).Name, v.Elem().Field(i))
 				} else {
 					kValue := conf.Get(sectionName + "." + typeOfS.Field(i).Name)
 					if kValue != nil {
@@ -44,6 +57,47 @@ func loadConfigSections(conf *toml.Tree, configStruct interface{}) {
 	}
 }
 
+//Mapas são lidos das subseções com o nome do campo. Ex: Providers map[string]ProviderOptions lê [Providers.Sinch], [Providers.Zenvia]
+func loadConfigMap(conf *toml.Tree, sectionName string, mapValue reflect.Value) {
+	section, ok := conf.Get(sectionName).(*toml.Tree)
+	if !ok {
+		return
+	}
+	if mapValue.IsNil() {
+		mapValue.Set(reflect.MakeMap(mapValue.Type()))
+	}
+	elemType := mapValue.Type().Elem()
+	for _, key := range section.Keys() {
+		elem := reflect.New(elemType).Elem()
+		if current := mapValue.MapIndex(reflect.ValueOf(key)); current.IsValid() {
+			elem.Set(current)
+		}
+		if elemType.Kind() == reflect.Struct {
+			if subSection, ok := section.Get(key).(*toml.Tree); ok {
+				loadConfigStruct(subSection, elem)
+			}
+		} else if kValue := section.Get(key); kValue != nil {
+			elem.Set(reflect.ValueOf(kValue).Convert(elemType))
+		}
+		mapValue.SetMapIndex(reflect.ValueOf(key), elem)
+	}
+}
+
+func loadConfigStruct(section *toml.Tree, structValue reflect.Value) {
+	for i := 0; i < structValue.NumField(); i++ {
+		field := structValue.Field(i)
+		fieldName := structValue.Type().Field(i).Name
+		if !field.CanSet() {
+			continue
+		}
+		if field.Kind() == reflect.Map {
+			loadConfigMap(section, fieldName, field)
+		} else if kValue := section.Get(fieldName); kValue != nil {
+			field.Set(reflect.ValueOf(kValue).Convert(field.Type()))
+		}
+	}
+}
+
 func LoadConfig(configFileName string, configStruct interface{}) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -0,0 +1,220 @@
+package util
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logBackupTimeFormat = "20060102-150405"
+	logDayFormat        = "2006-01-02"
+	compressedSuffix    = ".gz"
+)
+
+// LogFile é o arquivo de log com rotação por tamanho e por dia (LogRotation). Os arquivos antigos recebem a data e
+// hora da rotação no nome (sms.log.20240305-000000), são compactados e apagados em segundo plano. Reopen reabre o
+// arquivo depois de uma rotação externa (logrotate sem copytruncate + SIGHUP).
+type LogFile struct {
+	mutex   sync.Mutex
+	path    string
+	options LogRotation
+	file    *os.File
+	size    int64
+	day     string
+	cleanup sync.Mutex
+}
+
+func OpenLogFile(path string, options LogRotation) (*LogFile, error) {
+	l := &LogFile{path: path, options: options}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open supõe o mutex já adquirido (ou o arquivo ainda não compartilhado)
+func (l *LogFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	l.day = time.Now().Format(logDayFormat)
+	if l.size > 0 {
+		//Arquivo de outro dia: roda na primeira gravação
+		l.day = info.ModTime().Format(logDayFormat)
+	}
+	return nil
+}
+
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return 0, os.ErrClosed
+	}
+	if l.mustRotate(int64(len(p))) {
+		if err := l.rotate(); err != nil {
+			//Sem rotação o log continua no arquivo atual
+			fmt.Fprintln(os.Stderr, "LogFile.rotate: "+err.Error())
+			if l.file == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *LogFile) mustRotate(next int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.options.Daily && (time.Now().Format(logDayFormat) != l.day) {
+		return true
+	}
+	return (l.options.MaxSizeMB > 0) && (l.size+next > int64(l.options.MaxSizeMB)*1024*1024)
+}
+
+// rotate supõe o mutex já adquirido
+func (l *LogFile) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	backup := l.backupName(time.Now())
+	renameErr := os.Rename(l.path, backup)
+	if err := l.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	go l.compressAndClean(backup)
+	return nil
+}
+
+// backupName retorna um nome ainda não usado para o arquivo rodado
+func (l *LogFile) backupName(t time.Time) string {
+	name := l.path + "." + t.Format(logBackupTimeFormat)
+	candidate := name
+	for i := 1; ; i++ {
+		_, err := os.Stat(candidate)
+		_, errGz := os.Stat(candidate + compressedSuffix)
+		if os.IsNotExist(err) && os.IsNotExist(errGz) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s.%d", name, i)
+	}
+}
+
+// Reopen fecha e reabre o arquivo pelo nome, para continuar gravando depois que ele foi movido por fora
+func (l *LogFile) Reopen() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	return l.open()
+}
+
+func (l *LogFile) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *LogFile) compressAndClean(backup string) {
+	l.cleanup.Lock()
+	defer l.cleanup.Unlock()
+	if l.options.Compress {
+		if err := compressFile(backup); err != nil {
+			fmt.Fprintln(os.Stderr, "LogFile.compress: "+err.Error())
+		}
+	}
+	l.removeOldBackups()
+}
+
+func compressFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(path+compressedSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(target)
+	_, err = io.Copy(writer, source)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + compressedSuffix)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// removeOldBackups apaga os arquivos rodados além de MaxBackups ou rodados há mais de MaxAgeDays
+func (l *LogFile) removeOldBackups() {
+	if (l.options.MaxBackups <= 0) && (l.options.MaxAgeDays <= 0) {
+		return
+	}
+	matches, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return
+	}
+	type backup struct {
+		path    string
+		rotated time.Time
+	}
+	var backups []backup
+	for _, match := range matches {
+		//sms.log.<data e hora>[.<n>][.gz]
+		stamp := strings.SplitN(strings.TrimPrefix(match, l.path+"."), ".", 2)[0]
+		if rotated, err := time.ParseInLocation(logBackupTimeFormat, stamp, time.Local); err == nil {
+			backups = append(backups, backup{match, rotated})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].rotated.Equal(backups[j].rotated) {
+			return backups[i].rotated.After(backups[j].rotated)
+		}
+		return backups[i].path > backups[j].path
+	})
+	cutoff := time.Now().AddDate(0, 0, -l.options.MaxAgeDays)
+	for i, b := range backups {
+		tooMany := (l.options.MaxBackups > 0) && (i >= l.options.MaxBackups)
+		tooOld := (l.options.MaxAgeDays > 0) && b.rotated.Before(cutoff)
+		if tooMany || tooOld {
+			if err := os.Remove(b.path); err != nil {
+				fmt.Fprintln(os.Stderr, "LogFile.removeOldBackups: "+err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// backupsOf retorna os arquivos rodados do log, em ordem de nome
+func backupsOf(t *testing.T, path string) []string {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func openTestLog(t *testing.T, options LogRotation) (*LogFile, string) {
+	path := filepath.Join(t.TempDir(), "sms.log")
+	l, err := OpenLogFile(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, path
+}
+
+func TestLogFileRotateBySize(t *testing.T) {
+	l, path := openTestLog(t, LogRotation{MaxSizeMB: 1})
+	line := bytes.Repeat([]byte("x"), 600*1024)
+
+	for i := 0; i < 2; i++ {
+		if _, err := l.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups := backupsOf(t, path)
+	if len(backups) != 1 {
+		t.Fatalf("arquivos rodados = %v, esperado 1", backups)
+	}
+	for _, file := range []string{path, backups[0]} {
+		if info, err := os.Stat(file); (err != nil) || (info.Size() != int64(len(line))) {
+			t.Errorf("%s: %v, esperado %d bytes", file, err, len(line))
+		}
+	}
+}
+
+func TestLogFileRotateDaily(t *testing.T) {
+	l, path := openTestLog(t, LogRotation{Daily: true})
+	if _, err := l.Write([]byte("ontem\n")); err != nil {
+		t.Fatal(err)
+	}
+	l.day = time.Now().AddDate(0, 0, -1).Format(logDayFormat)
+
+	if _, err := l.Write([]byte("hoje\n")); err != nil {
+		t.Fatal(err)
+	}
+	if backups := backupsOf(t, path); len(backups) != 1 {
+		t.Errorf("arquivos rodados = %v, esperado 1 na virada do dia", backups)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "hoje\n" {
+		t.Errorf("log atual = %q, esperado só o registro de hoje", content)
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	l, path := openTestLog(t, LogRotation{})
+	l.Write([]byte("antes\n"))
+	//Rotação externa (logrotate sem copytruncate)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	l.Write([]byte("depois\n"))
+
+	if content, _ := os.ReadFile(path); string(content) != "depois\n" {
+		t.Errorf("log reaberto = %q, esperado só o registro depois do Reopen", content)
+	}
+	if content, _ := os.ReadFile(path + ".1"); string(content) != "antes\n" {
+		t.Errorf("log movido = %q, esperado o registro anterior", content)
+	}
+}
+
+func TestCompressFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.log.20240305-000000")
+	if err := os.WriteFile(path, []byte("registro\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("arquivo original ainda existe: %v", err)
+	}
+	file, err := os.Open(path + compressedSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := io.ReadAll(reader); string(content) != "registro\n" {
+		t.Errorf("conteúdo descompactado = %q", content)
+	}
+}
+
+func TestRemoveOldBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.log")
+	now := time.Now()
+	var names []string
+	for _, age := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour, 10 * 24 * time.Hour} {
+		names = append(names, path+"."+now.Add(-age).Format(logBackupTimeFormat))
+	}
+	names[1] += compressedSuffix
+	for _, name := range append(names, path+".antigo") {
+		if err := os.WriteFile(name, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//Por idade: só o de 10 dias sai
+	l := &LogFile{path: path, options: LogRotation{MaxAgeDays: 7}}
+	l.removeOldBackups()
+	if backups := backupsOf(t, path); len(backups) != 4 {
+		t.Errorf("depois de MaxAgeDays = %v, esperado 3 rodados e o arquivo fora do padrão", backups)
+	}
+
+	//Por quantidade: ficam os mais recentes
+	l.options = LogRotation{MaxBackups: 1}
+	l.removeOldBackups()
+	if backups := backupsOf(t, path); (len(backups) != 2) || (backups[0] != names[0]) || (backups[1] != path+".antigo") {
+		t.Errorf("depois de MaxBackups = %v, esperado %s e o arquivo fora do padrão", backups, names[0])
+	}
+}